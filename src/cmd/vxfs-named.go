@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	dao "vxfs/dao/name"
 	"vxfs/libs"
 	"vxfs/libs/glog"
 	"vxfs/name"
@@ -14,9 +18,18 @@ var (
 	myVer  = "1.1"
 	myArgs = struct {
 		address string
+		leader  string
+
+		reapProxy    string
+		reapSafeCode string
+		reapRefresh  int
+
+		usagePrefixes string
+		nameMaxLength int
 
 		dataFreeMB   int
 		statsRefresh int
+		syncRefresh  int
 	}{}
 )
 
@@ -24,10 +37,23 @@ func init() {
 	flag.StringVar(&myArgs.address, "vxfsAddress", ":1720", "network bind address, [host:]port")
 	flag.IntVar(&myArgs.dataFreeMB, "vxfsDataFree", 100, "require data store free space, MB")
 	flag.IntVar(&myArgs.statsRefresh, "vxfsStatsRefresh", 10, "stats refresh interval, second")
+	flag.StringVar(&myArgs.leader, "vxfsLeader", "", "run as follower of the leader name server, host:port")
+	flag.IntVar(&myArgs.syncRefresh, "vxfsSyncRefresh", 1, "follower sync interval, second")
+	flag.StringVar(&myArgs.reapProxy, "vxfsReapProxy", "", "delete the expired names with store data by the proxy server, http://host:port")
+	flag.StringVar(&myArgs.reapSafeCode, "vxfsReapSafeCode", "", "the proxy server safe code for delete")
+	flag.IntVar(&myArgs.reapRefresh, "vxfsReapRefresh", 60, "expired names reap interval, second")
+	flag.StringVar(&myArgs.usagePrefixes, "vxfsUsagePrefixes", "", "count the usage of names under the prefixes, prefix1/,prefix2/...")
+	flag.IntVar(&myArgs.nameMaxLength, "vxfsNameMaxLength", dao.MaxNameLength, "reject the names longer than the bytes on write")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "The vxfs name server, version: %s\n"+
 			"\n%s <data store path>\n"+
-			"\nOptions:\n", myVer, myName)
+			"%s promote <follower name server>\n"+
+			"%s lookup <name server list> <sid> [store key file | -]\n"+
+			"%s export <data store path> [snapshot file | -]\n"+
+			"%s import <data store path> [snapshot file | -]\n"+
+			"\nThe lookup print the names refer to the store keys, or all keys of the sid, a name server of every shard in the list.\n"+
+			"The export and import run on the stopped name server, the import require an empty data store path.\n"+
+			"\nOptions:\n", myVer, myName, myName, myName, myName, myName)
 		flag.PrintDefaults()
 	}
 }
@@ -41,6 +67,19 @@ func main() {
 		return
 	}
 
+	if flag.Args()[0] == "promote" {
+		runPromote(flag.Args()[1:])
+		return
+	}
+	if flag.Args()[0] == "lookup" {
+		runLookup(flag.Args()[1:])
+		return
+	}
+	if flag.Args()[0] == "export" || flag.Args()[0] == "import" {
+		runSnapshot(flag.Args()[0], flag.Args()[1:])
+		return
+	}
+
 	dataDir := flag.Args()[0]
 
 	if !libs.IsHostPort(myArgs.address) {
@@ -49,16 +88,47 @@ func main() {
 		return
 	}
 
+	if len(myArgs.leader) > 0 && !libs.IsStrictHostPort(myArgs.leader) {
+		fmt.Println("incorrect option: vxfsLeader")
+		flag.Usage()
+		return
+	}
+
+	if len(myArgs.reapProxy) > 0 && !strings.HasPrefix(myArgs.reapProxy, "http://") && !strings.HasPrefix(myArgs.reapProxy, "https://") {
+		fmt.Println("incorrect option: vxfsReapProxy")
+		flag.Usage()
+		return
+	}
+
 	publicAddress, err := libs.GetPublicHostPort(myArgs.address)
 	if err != nil {
 		glog.Exitln(err)
 	}
 
-	nameGroup, err := name.NewNameGroup(dataDir, myArgs.dataFreeMB, myArgs.statsRefresh)
+	var usagePrefixes []string
+	if len(myArgs.usagePrefixes) > 0 {
+		usagePrefixes = strings.Split(myArgs.usagePrefixes, ",")
+	}
+	nameGroup, err := name.NewNameGroup(dataDir, myArgs.dataFreeMB, myArgs.statsRefresh, usagePrefixes)
 	if err != nil {
 		glog.Exitln(err)
 	}
 
+	nameGroup.SetMaxNameLength(myArgs.nameMaxLength)
+
+	if len(myArgs.leader) > 0 {
+		if err = nameGroup.Follow(myArgs.leader, myArgs.syncRefresh); err != nil {
+			nameGroup.Close()
+			glog.Exitln(err)
+		}
+		glog.Infof("Follow leader name server (%s)\n", myArgs.leader)
+	}
+
+	if len(myArgs.reapProxy) < 1 {
+		glog.Warningln("No reap proxy, the store data of expired names was kept")
+	}
+	nameGroup.Reap(myArgs.reapProxy, myArgs.reapSafeCode, myArgs.reapRefresh)
+
 	server, err := libs.NewRpcServer(myArgs.address, name.NewNameService(nameGroup))
 	if err != nil {
 		nameGroup.Close()
@@ -74,3 +144,165 @@ func main() {
 		glog.Infof("Stop net/rpc server at (%s) %% (%s)\n", myArgs.address, publicAddress)
 	})
 }
+
+func runPromote(args []string) {
+	if len(args) < 1 || !libs.IsStrictHostPort(args[0]) {
+		fmt.Println("incorrect parameter: follower name server format")
+		flag.Usage()
+		return
+	}
+
+	client := libs.NetRpcClient(args[0])
+	defer client.Close()
+
+	res := &dao.PromoteResponse{}
+	if err := client.Call("NameService.Promote", &dao.PromoteRequest{}, res); err != nil {
+		glog.Exitln(err)
+	}
+	fmt.Printf("promote (%s) to leader of term %d\n", args[0], res.Term)
+}
+
+func readKeys(file string) (keys []int64, err error) {
+	var (
+		f   = os.Stdin
+		key int64
+	)
+	if file != "-" {
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		defer f.Close()
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < 1 {
+			continue
+		}
+		if key, err = strconv.ParseInt(line, 10, 64); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = scanner.Err()
+	return
+}
+
+func runLookup(args []string) {
+	if len(args) < 2 {
+		fmt.Println("incorrect parameter count")
+		flag.Usage()
+		return
+	}
+	var (
+		err     error
+		sid     int64
+		keys    []int64
+		clients []*libs.RpcClient
+		found   = 0
+	)
+	for _, address := range strings.Split(args[0], ",") {
+		if !libs.IsStrictHostPort(address) {
+			fmt.Println("incorrect parameter: name server list format")
+			flag.Usage()
+			return
+		}
+		client := libs.NetRpcClient(address)
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	if sid, err = strconv.ParseInt(args[1], 10, 32); err != nil {
+		fmt.Println("incorrect parameter: sid")
+		flag.Usage()
+		return
+	}
+	if len(args) > 2 {
+		if keys, err = readKeys(args[2]); err != nil {
+			glog.Exitln(err)
+		}
+	}
+
+	report := func(res *dao.LookupResponse) {
+		for _, v := range res.Keys {
+			for _, n := range v.Names {
+				fmt.Printf("%d\t%d\t%s\n", sid, v.Key, n)
+			}
+			found += 1
+		}
+	}
+	for _, client := range clients {
+		if len(keys) > 0 {
+			for i := 0; i < len(keys); i += 1000 {
+				j := i + 1000
+				if j > len(keys) {
+					j = len(keys)
+				}
+				res := &dao.LookupResponse{}
+				if err = client.Call("NameService.LookupByKey", &dao.LookupRequest{Sid: int32(sid), Keys: keys[i:j]}, res); err != nil {
+					glog.Exitln(err)
+				}
+				report(res)
+			}
+			continue
+		}
+		req := &dao.LookupRequest{Sid: int32(sid)}
+		for {
+			res := &dao.LookupResponse{}
+			if err = client.Call("NameService.LookupByKey", req, res); err != nil {
+				glog.Exitln(err)
+			}
+			report(res)
+			if !res.More || len(res.Keys) < 1 {
+				break
+			}
+			req.Marker = res.Keys[len(res.Keys)-1].Key
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d store keys were referred by names\n", found)
+}
+
+func runSnapshot(command string, args []string) {
+	if len(args) < 1 {
+		fmt.Println("incorrect parameter count")
+		flag.Usage()
+		return
+	}
+	var (
+		err   error
+		count int
+		file  = "-"
+	)
+	if len(args) > 1 {
+		file = args[1]
+	}
+
+	nameGroup, err := name.NewNameGroup(args[0], 0, myArgs.statsRefresh, nil)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	defer nameGroup.Close()
+
+	if command == "export" {
+		f := os.Stdout
+		if file != "-" {
+			if f, err = os.Create(file); err != nil {
+				glog.Exitln(err)
+			}
+			defer f.Close()
+		}
+		count, err = nameGroup.Export(f)
+	} else {
+		f := os.Stdin
+		if file != "-" {
+			if f, err = os.Open(file); err != nil {
+				glog.Exitln(err)
+			}
+			defer f.Close()
+		}
+		count, err = nameGroup.Import(f)
+	}
+	if err != nil {
+		glog.Errorf("%s \"%s\" error(%v)", command, args[0], err)
+	}
+	fmt.Fprintf(os.Stderr, "%s %d names\n", command, count)
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"vxfs/dao/name"
 	"vxfs/libs"
 	"vxfs/libs/glog"
 	"vxfs/proxy"
@@ -15,9 +17,30 @@ var (
 	myName = "vxfs-proxyd"
 	myVer  = "1.1"
 	myArgs = struct {
-		address   string
-		safeCode  string
-		noDigMime bool
+		address    string
+		safeCode   string
+		noDigMime  bool
+		versioning string
+		trashDays  int
+		buckets    bool
+		bucketHost string
+		quotas     string
+		chunkMB    int
+		s3Address  string
+		s3Region   string
+		s3Keys     string
+		uploadDays int
+		keyring    string
+		signPrefix string
+		credential string
+		thumbMP    int
+		thumbWork  int
+		thumbSizes string
+
+		nameMaxLength int
+		nameNFC       bool
+		nameFoldCase  bool
+		nameForbidden string
 
 		statsRefresh     int
 		nameDataFreeMB   int
@@ -28,20 +51,43 @@ var (
 
 func init() {
 	flag.StringVar(&myArgs.address, "vxfsAddress", ":1750", "network bind address, [host:]port")
-	flag.StringVar(&myArgs.safeCode, "vxfsSafeCode", "", "validate http header VXFS-SAFE-CODE on PUT & DELETE")
+	flag.StringVar(&myArgs.safeCode, "vxfsSafeCode", "", "validate http header VXFS-SAFE-CODE, the key of all permissions")
+	flag.StringVar(&myArgs.credential, "vxfsCredentials", "", "the credential file of the keys and prefix permissions, reloaded on change")
 	flag.BoolVar(&myArgs.noDigMime, "vxfsNoDigMime", false, "disable http content type deep guess on PUT")
+	flag.StringVar(&myArgs.versioning, "vxfsVersioning", "", "keep versions of the names under the prefixes, prefix1,prefix2...")
+	flag.IntVar(&myArgs.trashDays, "vxfsTrashDays", 0, "move the deleted files into trash and keep the days, 0 for disable")
+	flag.BoolVar(&myArgs.buckets, "vxfsBuckets", false, "enable the namespace buckets, the first path segment is the bucket")
+	flag.StringVar(&myArgs.bucketHost, "vxfsBucketHost", "", "enable the virtual host buckets under the domain, <bucket>.domain")
+	flag.StringVar(&myArgs.quotas, "vxfsQuotas", "", "limit the names under the prefixes, prefix1/=bytes[:count],prefix2/=bytes..., 0 for unlimited")
+	flag.IntVar(&myArgs.chunkMB, "vxfsChunkSize", proxy.DefaultChunkSize/1024/1024, "split the uploads larger than the size into chunks, MB, 0 for disable")
+	flag.IntVar(&myArgs.uploadDays, "vxfsUploadDays", proxy.DefaultUploadExpire/24/3600, "expire the unfinished multipart uploads after the days, 0 for never")
+	flag.StringVar(&myArgs.keyring, "vxfsKeyring", "", "the keyring file of the presigned urls, json {\"key id\": \"secret\"}")
+	flag.StringVar(&myArgs.signPrefix, "vxfsSignPrefixes", "", "require the presigned urls for GET the names under the prefixes, prefix1/,prefix2/...")
+	flag.IntVar(&myArgs.thumbWork, "vxfsThumbWorkers", proxy.DefaultThumbWorkers, "make the image thumbnails of GET ?w=&h= by the workers, 0 for disable")
+	flag.StringVar(&myArgs.thumbSizes, "vxfsThumbSizes", "", "cache the thumbnails of the sizes, 200x200,400x0..., the other sizes were not cached")
+	flag.IntVar(&myArgs.thumbMP, "vxfsThumbPixels", proxy.DefaultThumbPixels/1000/1000, "reject the thumbnails of the images larger than the pixels, megapixel")
+	flag.StringVar(&myArgs.s3Address, "vxfsS3Address", "", "enable the s3 compatible api at the address, [host:]port")
+	flag.StringVar(&myArgs.s3Region, "vxfsS3Region", proxy.DefaultS3Region, "the region of the s3 signatures")
+	flag.StringVar(&myArgs.s3Keys, "vxfsS3Keys", "", "the s3 access keys, access1:secret1,access2:secret2..., the secrets were the keys of the credentials")
+	flag.IntVar(&myArgs.nameMaxLength, "vxfsNameMaxLength", proxy.DefaultNameLength, "reject the names longer than the bytes")
+	flag.BoolVar(&myArgs.nameNFC, "vxfsNameNFC", false, "normalize the names to unicode NFC")
+	flag.BoolVar(&myArgs.nameFoldCase, "vxfsNameFoldCase", false, "fold the names by the unicode case folding")
+	flag.StringVar(&myArgs.nameForbidden, "vxfsNameForbidden", "", "reject the names with any of the characters")
 	flag.IntVar(&myArgs.statsRefresh, "vxfsStatsRefresh", 5, "stats refresh interval, second")
 	flag.IntVar(&myArgs.nameDataFreeMB, "vxfsNameDataFree", 100, "require <name server> data free space, MB")
 	flag.IntVar(&myArgs.storeDataFreeMB, "vxfsStoreDataFree", 200, "require <sotre server> data free space, MB")
 	flag.IntVar(&myArgs.storeIndexFreeMB, "vxfsStoreIndexFree", 60, "require <sotre server> index free space, MB")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "The vxfs proxy server, Version: %s\n"+
-			"\n%s <machine id> <name server> <store server list>\n"+
+			"\n%s <machine id> <name server list | shard map file> <store server list>\n"+
 			"\nFormats:\n"+
 			"  <machine id> 1 ~ "+fmt.Sprintf("%d\n", libs.MaxMachineId)+
-			"  <name server> host:port\n"+
+			"  <name server list> host1:port1,host2:port2..., writes go to the leader\n"+
+			"  <shard map file> json file, the name server list of each shard\n"+
 			"  <sotre server list> id1/host1:port1,id2/host2:port2..., the id must gt 0\n"+
-			"\nOptions:\n", myVer, myName)
+			"\n%s sign <keyring file> <key id> <method> <url> [ttl seconds]\n"+
+			"\nThe sign print the presigned url of the method, expire after the ttl (default %d), the urls of the virtual host buckets require vxfsBucketHost.\n"+
+			"\nOptions:\n", myVer, myName, myName, proxy.DefaultSignTTL)
 		flag.PrintDefaults()
 	}
 }
@@ -49,6 +95,11 @@ func init() {
 func main() {
 	flag.Parse()
 
+	if flag.NArg() > 0 && flag.Args()[0] == "sign" {
+		runSign(flag.Args()[1:])
+		return
+	}
+
 	if flag.NArg() < 3 {
 		fmt.Println("incorrect parameter count")
 		flag.Usage()
@@ -56,7 +107,7 @@ func main() {
 	}
 
 	machineIdStr := flag.Args()[0]
-	nameServerAddress := flag.Args()[1]
+	nameServerGroup := flag.Args()[1]
 	storeServerGroup := flag.Args()[2]
 
 	if !libs.IsHostPort(myArgs.address) {
@@ -77,12 +128,31 @@ func main() {
 
 	serviceManager := proxy.NewServiceManager(myArgs.nameDataFreeMB, myArgs.storeDataFreeMB, myArgs.storeIndexFreeMB, myArgs.statsRefresh)
 
-	if !libs.IsStrictHostPort(nameServerAddress) {
-		fmt.Println("incorrect parameter: name server format")
-		flag.Usage()
-		return
+	if strings.Contains(nameServerGroup, ":") && !strings.Contains(nameServerGroup, "/") {
+		nameServerList := strings.Split(nameServerGroup, ",")
+		for _, nameServerAddress := range nameServerList {
+			if !libs.IsStrictHostPort(nameServerAddress) {
+				fmt.Println("incorrect parameter: name server format")
+				flag.Usage()
+				return
+			}
+		}
+		shardMap, err := proxy.NewShardMap(nameServerList)
+		if err != nil {
+			fmt.Println("incorrect parameter: name server, address was repeated")
+			flag.Usage()
+			return
+		}
+		serviceManager.SetShardMap(shardMap, "")
+	} else {
+		shardMap, err := proxy.LoadShardMap(nameServerGroup)
+		if err != nil {
+			fmt.Printf("incorrect parameter: shard map file, %v\n", err)
+			flag.Usage()
+			return
+		}
+		serviceManager.SetShardMap(shardMap, nameServerGroup)
 	}
-	serviceManager.SetNameService(nameServerAddress)
 
 	for _, storeServerUnit := range strings.Split(storeServerGroup, ",") {
 		fields := strings.Split(storeServerUnit, "/")
@@ -104,6 +174,68 @@ func main() {
 		}
 	}
 
+	namePolicy := &name.NamePolicy{
+		MaxLength: myArgs.nameMaxLength,
+		Normalize: myArgs.nameNFC,
+		FoldCase:  myArgs.nameFoldCase,
+		Forbidden: myArgs.nameForbidden,
+	}
+	if len(myArgs.quotas) > 0 {
+		quotas, err := proxy.ParseQuotas(myArgs.quotas, namePolicy)
+		if err != nil {
+			fmt.Println("incorrect option: vxfsQuotas")
+			flag.Usage()
+			return
+		}
+		serviceManager.SetQuotas(quotas)
+	}
+
+	thumbSizes, err := proxy.ParseThumbSizes(myArgs.thumbSizes)
+	if err != nil {
+		fmt.Println("incorrect option: vxfsThumbSizes")
+		flag.Usage()
+		return
+	}
+	s3Keys := map[string]string{}
+	if len(myArgs.s3Keys) > 0 {
+		var err error
+		if s3Keys, err = proxy.ParseS3Keys(myArgs.s3Keys); err != nil {
+			fmt.Println("incorrect option: vxfsS3Keys")
+			flag.Usage()
+			return
+		}
+	}
+	if len(myArgs.s3Address) > 0 && len(s3Keys) < 1 {
+		fmt.Println("incorrect option: vxfsS3Keys, require the keys of vxfsS3Address")
+		flag.Usage()
+		return
+	}
+
+	var signKeys map[string]string
+	if len(myArgs.keyring) > 0 {
+		var err error
+		if signKeys, err = proxy.LoadKeyring(myArgs.keyring); err != nil {
+			fmt.Printf("incorrect option: vxfsKeyring, %v\n", err)
+			flag.Usage()
+			return
+		}
+	}
+
+	credentials := proxy.NewCredentialStore(myArgs.safeCode)
+	if len(myArgs.credential) > 0 {
+		if err := credentials.Load(myArgs.credential); err != nil {
+			fmt.Printf("incorrect option: vxfsCredentials, %v\n", err)
+			flag.Usage()
+			return
+		}
+	}
+	// the bucket records were written by the admin keys only
+	if (myArgs.buckets || len(myArgs.bucketHost) > 0) && !credentials.Secured() {
+		fmt.Println("incorrect option: vxfsBuckets, require vxfsSafeCode or vxfsCredentials")
+		flag.Usage()
+		return
+	}
+
 	serviceManager.Startup()
 
 	publicAddress, err := libs.GetPublicHostPort(myArgs.address)
@@ -112,17 +244,85 @@ func main() {
 	}
 
 	keyMaker, _ := libs.NewSnowFlake(int64(machineId))
-	server, err := proxy.NewProxyServer(myArgs.address, myArgs.safeCode, myArgs.noDigMime, keyMaker, serviceManager)
+	server, err := proxy.NewProxyServer(myArgs.address, credentials, myArgs.noDigMime, keyMaker, serviceManager)
 	if err != nil {
 		glog.Exitln(err)
 	}
+	server.SetNamePolicy(namePolicy)
+	server.SetChunkSize(int64(myArgs.chunkMB) * 1024 * 1024)
+	server.SetUploadExpire(int64(myArgs.uploadDays) * 24 * 3600)
+	server.SetThumbnails(int64(myArgs.thumbMP)*1000*1000, myArgs.thumbWork, thumbSizes)
+	if len(myArgs.versioning) > 0 {
+		server.SetVersionPrefixes(strings.Split(myArgs.versioning, ","))
+	}
+	if myArgs.trashDays > 0 {
+		server.SetTrash(int64(myArgs.trashDays) * 24 * 3600)
+	}
+	if myArgs.buckets || len(myArgs.bucketHost) > 0 {
+		server.SetBuckets(myArgs.bucketHost)
+	}
+	if len(signKeys) > 0 || len(myArgs.signPrefix) > 0 {
+		var prefixes []string
+		if len(myArgs.signPrefix) > 0 {
+			prefixes = strings.Split(myArgs.signPrefix, ",")
+		}
+		server.SetPresign(signKeys, prefixes)
+	}
+
+	var s3Server *proxy.S3Server
+	if len(myArgs.s3Address) > 0 {
+		if s3Server, err = proxy.NewS3Server(myArgs.s3Address, myArgs.s3Region, s3Keys, server); err != nil {
+			glog.Exitln(err)
+		}
+		glog.Infof("Run s3 server at (%s)\n", myArgs.s3Address)
+		go s3Server.Serve()
+	}
 
 	glog.Infof("Run http server at (%s) %% (%s)\n", myArgs.address, publicAddress)
 
 	go server.Serve()
 	libs.WaitProcessExit(func() {
+		if s3Server != nil {
+			s3Server.Close()
+		}
 		server.Close()
+		credentials.Close()
 		serviceManager.Cleanup()
 		glog.Infof("Stop http server at (%s) %% (%s)\n", myArgs.address, publicAddress)
 	})
 }
+
+func runSign(args []string) {
+	if len(args) < 4 {
+		fmt.Println("incorrect parameter count")
+		flag.Usage()
+		return
+	}
+	keys, err := proxy.LoadKeyring(args[0])
+	if err != nil {
+		fmt.Printf("incorrect parameter: keyring file, %v\n", err)
+		flag.Usage()
+		return
+	}
+	secret, ok := keys[args[1]]
+	if !ok {
+		fmt.Println("incorrect parameter: key id not in keyring")
+		flag.Usage()
+		return
+	}
+	ttl := proxy.DefaultSignTTL
+	if len(args) > 4 {
+		if ttl, err = strconv.Atoi(args[4]); err != nil || ttl < 1 {
+			fmt.Println("incorrect parameter: ttl")
+			flag.Usage()
+			return
+		}
+	}
+	signed, err := proxy.PresignUrl(args[3], args[2], args[1], secret, time.Now().Unix()+int64(ttl), myArgs.bucketHost)
+	if err != nil {
+		fmt.Println("incorrect parameter: url")
+		flag.Usage()
+		return
+	}
+	fmt.Println(signed)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	dao "vxfs/dao/name"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+	"vxfs/name"
+	"vxfs/proxy"
+)
+
+var (
+	myName = "vxfs-reshard"
+	myVer  = "1.1"
+	myArgs = struct {
+		grace int
+		purge bool
+	}{}
+)
+
+func init() {
+	flag.IntVar(&myArgs.grace, "vxfsGrace", 20, "wait proxy servers reload the shard map, second")
+	flag.BoolVar(&myArgs.purge, "vxfsPurge", false, "delete the moved names from the old owner after cutover")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "The vxfs resharding tool, version: %s\n"+
+			"\n%s <shard map file> <new shard map file>\n"+
+			"\nThe old owner keep serving until the cutover, when the new shard map replace the shard map file.\n"+
+			"\nOptions:\n", myVer, myName)
+		flag.PrintDefaults()
+	}
+}
+
+type shardCursor struct {
+	id     int32
+	leader *libs.RpcClient
+	fid    int64
+	offset int64
+}
+
+type resharder struct {
+	olds    []*shardCursor
+	nexts   map[int32]*libs.RpcClient
+	nextMap *proxy.ShardMap
+}
+
+func findLeader(config *proxy.ShardConfig) (client *libs.RpcClient, err error) {
+	for _, address := range config.Servers {
+		var (
+			c   = libs.NetRpcClient(address)
+			res = &dao.StatsResponse{}
+		)
+		if err = c.Call("NameService.Stats", &dao.StatsRequest{}, res); err == nil && res.Stats.Leader {
+			client = c
+			return
+		}
+		c.Close()
+	}
+	err = fmt.Errorf("shard(%d) no living leader", config.Id)
+	return
+}
+
+func newResharder(oldMap *proxy.ShardMap, nextMap *proxy.ShardMap) (r *resharder, err error) {
+	r = &resharder{
+		nexts:   make(map[int32]*libs.RpcClient),
+		nextMap: nextMap,
+	}
+	for i := range oldMap.Shards {
+		cursor := &shardCursor{id: oldMap.Shards[i].Id}
+		if cursor.leader, err = findLeader(&oldMap.Shards[i]); err != nil {
+			r.close()
+			return
+		}
+		r.olds = append(r.olds, cursor)
+	}
+	for i := range nextMap.Shards {
+		var client *libs.RpcClient
+		if client, err = findLeader(&nextMap.Shards[i]); err != nil {
+			r.close()
+			return
+		}
+		r.nexts[nextMap.Shards[i].Id] = client
+	}
+	return
+}
+
+func (r *resharder) close() {
+	for _, v := range r.olds {
+		v.leader.Close()
+	}
+	for _, v := range r.nexts {
+		v.Close()
+	}
+}
+
+func (r *resharder) owner(cursor *shardCursor, path string) (client *libs.RpcClient, moved bool) {
+	client = r.nexts[r.nextMap.Locate(path)]
+	moved = client.Address != cursor.leader.Address
+	return
+}
+
+func (r *resharder) scan(cursor *shardCursor, fn func(*dao.SyncRecord) error) (count int, err error) {
+	for {
+		var (
+			req = &dao.SyncRequest{Fid: cursor.fid, Offset: cursor.offset, Limit: 1000}
+			res = &dao.SyncResponse{}
+		)
+		if err = cursor.leader.Call("NameService.Sync", req, res); err != nil {
+			return
+		}
+		for i := range res.Records {
+			if err = fn(&res.Records[i]); err != nil {
+				return
+			}
+		}
+		count += len(res.Records)
+		if res.Fid == cursor.fid && res.Offset == cursor.offset {
+			return
+		}
+		cursor.fid = res.Fid
+		cursor.offset = res.Offset
+	}
+}
+
+func (r *resharder) copyPass() (err error) {
+	for _, cursor := range r.olds {
+		moved := 0
+		if _, err = r.scan(cursor, func(record *dao.SyncRecord) (err error) {
+			client, ok := r.owner(cursor, record.Name)
+			if !ok {
+				return
+			}
+			switch record.Flag {
+			case name.FlagOk:
+				req := &dao.WriteRequest{
+					Name:      record.Name,
+					Sid:       record.Sid,
+					Key:       record.Key,
+					Meta:      record.Meta,
+					Versioned: record.Meta.Version != 0,
+				}
+				// the names invalid to the new shard were skipped, and kept in the old one
+				if err = client.Call("NameService.Write", req, &dao.WriteResponse{}); err != nil && libs.IsErrorSame(err, dao.ErrNameExists) {
+					err = nil
+				} else if err != nil && (libs.IsErrorSame(err, dao.ErrNameInvalid) || libs.IsErrorSame(err, dao.ErrNameTooLong)) {
+					fmt.Printf("shard(%d) skip the name %q error(%v)\n", cursor.id, record.Name, err)
+					err = nil
+					return
+				}
+			case name.FlagTomb:
+				if record.Meta.Version != 0 {
+					err = client.Call("NameService.Delete", &dao.DeleteRequest{Name: record.Name, Version: record.Meta.Version}, &dao.DeleteResponse{})
+					break
+				}
+				nres := &dao.ReadResponse{}
+				if err = client.Call("NameService.Read", &dao.ReadRequest{Name: record.Name}, nres); err != nil {
+					if libs.IsErrorSame(err, dao.ErrNameNotExists) {
+						err = nil
+					}
+					return
+				}
+				if nres.Sid == record.Sid && nres.Key == record.Key {
+					err = client.Call("NameService.Delete", &dao.DeleteRequest{Name: record.Name}, &dao.DeleteResponse{})
+				}
+			}
+			moved += 1
+			return
+		}); err != nil {
+			return
+		}
+		fmt.Printf("shard(%d) %s moved %d records, position %d/%d\n", cursor.id, cursor.leader.Address, moved, cursor.fid, cursor.offset)
+	}
+	return
+}
+
+func (r *resharder) purgePass() (err error) {
+	for _, cursor := range r.olds {
+		purged := 0
+		cursor.fid = 0
+		cursor.offset = 0
+		if _, err = r.scan(cursor, func(record *dao.SyncRecord) (err error) {
+			client, ok := r.owner(cursor, record.Name)
+			if !ok || record.Flag != name.FlagOk {
+				return
+			}
+			// the skipped names were not in the new shard, and kept
+			if err = client.Call("NameService.Read", &dao.ReadRequest{Name: record.Name, Version: record.Meta.Version}, &dao.ReadResponse{}); err != nil {
+				if libs.IsErrorSame(err, dao.ErrNameNotExists) {
+					err = nil
+				}
+				return
+			}
+			if record.Meta.Version != 0 {
+				if err = cursor.leader.Call("NameService.Delete", &dao.DeleteRequest{Name: record.Name, Version: record.Meta.Version}, &dao.DeleteResponse{}); err != nil {
+					return
+				}
+				purged += 1
+				return
+			}
+			nres := &dao.ReadResponse{}
+			if err = cursor.leader.Call("NameService.Read", &dao.ReadRequest{Name: record.Name}, nres); err != nil {
+				if libs.IsErrorSame(err, dao.ErrNameNotExists) {
+					err = nil
+				}
+				return
+			}
+			if nres.Sid == record.Sid && nres.Key == record.Key {
+				if err = cursor.leader.Call("NameService.Delete", &dao.DeleteRequest{Name: record.Name}, &dao.DeleteResponse{}); err != nil {
+					return
+				}
+				purged += 1
+			}
+			return
+		}); err != nil {
+			return
+		}
+		fmt.Printf("shard(%d) %s purged %d names\n", cursor.id, cursor.leader.Address, purged)
+	}
+	return
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		fmt.Println("incorrect parameter count")
+		flag.Usage()
+		return
+	}
+
+	shardFile := flag.Args()[0]
+	nextFile := flag.Args()[1]
+
+	oldMap, err := proxy.LoadShardMap(shardFile)
+	if err != nil {
+		fmt.Printf("incorrect parameter: shard map file, %v\n", err)
+		flag.Usage()
+		return
+	}
+	nextMap, err := proxy.LoadShardMap(nextFile)
+	if err != nil {
+		fmt.Printf("incorrect parameter: new shard map file, %v\n", err)
+		flag.Usage()
+		return
+	}
+	nextMap.Version = oldMap.Version + 1
+
+	r, err := newResharder(oldMap, nextMap)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	defer r.close()
+
+	// copy & catch up, the old owner keep serving
+	for i := 0; i < 2; i++ {
+		if err = r.copyPass(); err != nil {
+			glog.Exitln(err)
+		}
+	}
+
+	if err = nextMap.Save(shardFile); err != nil {
+		glog.Exitln(err)
+	}
+	fmt.Printf("cutover shard map version %d, wait %d seconds\n", nextMap.Version, myArgs.grace)
+	time.Sleep(time.Duration(myArgs.grace) * time.Second)
+
+	// the writes on the old owner before proxy reloaded
+	if err = r.copyPass(); err != nil {
+		glog.Exitln(err)
+	}
+	if myArgs.purge {
+		if err = r.purgePass(); err != nil {
+			glog.Exitln(err)
+		}
+	}
+	fmt.Println("reshard completed")
+}
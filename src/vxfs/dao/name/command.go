@@ -1,33 +1,236 @@
 package name
 
+type NameMeta struct {
+	Size  int64  `json:"size"`
+	Mime  string `json:"mime,omitempty"`
+	ETag  string `json:"etag,omitempty"`
+	Ctime int64  `json:"ctime"`
+	Mtime int64  `json:"mtime"`
+
+	// the hex checksums of the whole file, served with the etag
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// the user metadata and the standard headers, replayed on the reads
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Version int64 `json:"version,omitempty"`
+	Marker  bool  `json:"marker,omitempty"`
+
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	Expires int64 `json:"expires,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// the store data was a manifest of the chunks
+	Chunked bool `json:"chunked,omitempty"`
+
+	// the block rewrote the meta of the name in place, as the tags
+	Update bool `json:"update,omitempty"`
+
+	Bucket *Bucket `json:"bucket,omitempty"`
+}
+
+type Bucket struct {
+	Name     string  `json:"name"`
+	SafeCode string  `json:"safe_code,omitempty"`
+	Sids     []int32 `json:"sids,omitempty"`
+	MaxSize  int64   `json:"max_size,omitempty"`
+	TTL      int64   `json:"ttl,omitempty"`
+	Quota    int64   `json:"quota,omitempty"`
+}
+
+type NameUsage struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
 type WriteRequest struct {
-	Name string
-	Sid  int32
-	Key  int64
+	Name      string
+	Sid       int32
+	Key       int64
+	Meta      NameMeta
+	Versioned bool
+	Link      bool
+	// the unversioned name was replaced in place, the store data of the old one was not deleted
+	Replace bool
+	// the term of the leader known by the proxy, 0 for unknown
+	Term int64
 }
 
 type WriteResponse struct {
 }
 
 type ReadRequest struct {
-	Name string
+	Name    string
+	Version int64
+	Expired bool
 }
 
 type ReadResponse struct {
-	Sid int32
-	Key int64
+	Sid  int32
+	Key  int64
+	Meta NameMeta
+	Refs int32
 }
 
 type DeleteRequest struct {
-	Name string
+	Name    string
+	Version int64
+	Marker  int64
+	Term    int64
 }
 
 type DeleteResponse struct {
+	Removed bool
+	Sid     int32
+	Key     int64
+	Refs    int32
+	Meta    NameMeta
+}
+
+type RenameRequest struct {
+	Name    string
+	To      string
+	Expires int64
+	Term    int64
+}
+
+type RenameResponse struct {
+}
+
+// replace the tags of the latest version
+type TagRequest struct {
+	Name string
+	Tags map[string]string
+	Term int64
+}
+
+type TagResponse struct {
+}
+
+type VersionsRequest struct {
+	Name string
+}
+
+type NameVersion struct {
+	Sid  int32
+	Key  int64
+	Meta NameMeta
 }
 
+type VersionsResponse struct {
+	Versions []NameVersion
+}
+
+// the names with all the "key:value" tags if any
+type ListRequest struct {
+	Prefix string
+	Marker string
+	Limit  int
+	Tags   []string
+}
+
+type NameEntry struct {
+	Name string   `json:"name"`
+	Sid  int32    `json:"sid"`
+	Key  int64    `json:"key"`
+	Meta NameMeta `json:"meta"`
+}
+
+type ListResponse struct {
+	Entries []NameEntry
+	More    bool
+}
+
+type LookupRequest struct {
+	Sid    int32
+	Keys   []int64
+	Marker int64
+	Limit  int
+}
+
+type KeyNames struct {
+	Key   int64
+	Names []string
+}
+
+type LookupResponse struct {
+	Keys []KeyNames
+	More bool
+}
+
+// the configured prefixes of the name server if empty
+type UsageRequest struct {
+	Prefixes []string
+	// the prefixes were counted from now on, instead of walking the names every time
+	Track bool
+}
+
+type UsageResponse struct {
+	Prefixes []string
+	Usages   []NameUsage
+}
+
+// the highest term of the shard seen by the proxy, the leader of a lower term stop writing
 type StatsRequest struct {
+	Term int64
 }
 
 type StatsResponse struct {
 	Stats NameStats
 }
+
+type SyncRequest struct {
+	Fid    int64
+	Offset int64
+	Limit  int
+}
+
+type SyncRecord struct {
+	Name string
+	Flag byte
+	Sid  int32
+	Key  int64
+	Meta NameMeta
+}
+
+type SyncResponse struct {
+	Term    int64
+	Fid     int64
+	Offset  int64
+	Records []SyncRecord
+}
+
+// the term of the new leader, the next term of the highest one seen if 0
+type PromoteRequest struct {
+	Term int64
+}
+
+type PromoteResponse struct {
+	Term int64
+}
+
+type WatchRequest struct {
+	Sequence int64
+	Prefix   string
+	Limit    int
+	Wait     int
+}
+
+type NameEvent struct {
+	Sequence int64
+	Type     string
+	Name     string
+	From     string
+	Sid      int32
+	Key      int64
+	Meta     NameMeta
+}
+
+type WatchResponse struct {
+	Sequence int64
+	Events   []NameEvent
+}
@@ -9,9 +9,17 @@ var (
 
 	ErrNameExists    = errors.New("name exists")
 	ErrNameNotExists = errors.New("name not exists")
+	ErrNameFollower  = errors.New("name follower readonly")
+	ErrNameVersion   = errors.New("name version invalid")
+	ErrNameNotEmpty  = errors.New("name data not empty")
+	ErrNameInvalid   = errors.New("name invalid")
+	ErrNameTooLong   = errors.New("name too long")
+	ErrNameTerm      = errors.New("name term not higher")
+	ErrNameTermStale = errors.New("name term stale")
 
 	ErrDataNoSpace     = errors.New("data no disk space")
 	ErrDataHeadMagic   = errors.New("data head magic not match")
 	ErrDataHeadVersion = errors.New("data head version not match")
 	ErrDataBlockMagic  = errors.New("data block magic not match")
+	ErrDataBlockSize   = errors.New("data block size too large")
 )
@@ -0,0 +1,68 @@
+package name
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the name size field of the data block was uint16
+const MaxNameLength = 65534
+
+type NamePolicy struct {
+	MaxLength int
+	Normalize bool
+	FoldCase  bool
+	Forbidden string
+}
+
+// the folded and NFC normalized name, for the names and prefixes, the caser was not shared
+func (p *NamePolicy) Transform(name string) string {
+	if p.FoldCase {
+		name = cases.Fold().String(name)
+	}
+	if p.Normalize {
+		name = norm.NFC.String(name)
+	}
+	return name
+}
+
+func (p *NamePolicy) Apply(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", ErrNameInvalid
+	}
+	name = p.Transform(name)
+	if err := CheckName(name, p.MaxLength); err != nil {
+		return "", err
+	}
+	if len(p.Forbidden) > 0 && strings.ContainsAny(name, p.Forbidden) {
+		return "", ErrNameInvalid
+	}
+	return name, nil
+}
+
+// the utf-8 names without control characters and empty, "." or ".." segments
+func CheckName(name string, maxLength int) error {
+	if maxLength < 1 || maxLength > MaxNameLength {
+		maxLength = MaxNameLength
+	}
+	if len(name) > maxLength {
+		return ErrNameTooLong
+	}
+	if len(name) < 1 || !utf8.ValidString(name) {
+		return ErrNameInvalid
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return ErrNameInvalid
+		}
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if len(segment) < 1 || segment == "." || segment == ".." {
+			return ErrNameInvalid
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package name
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckName(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLength int
+		err       error
+	}{
+		{"photos/a.jpg", 0, nil},
+		{"a", 1, nil},
+		{"ab", 1, ErrNameTooLong},
+		{strings.Repeat("a", MaxNameLength), 0, nil},
+		{strings.Repeat("a", MaxNameLength+1), MaxNameLength + 10, ErrNameTooLong},
+		{"", 0, ErrNameInvalid},
+		{"/photos/a.jpg", 0, ErrNameInvalid},
+		{"photos/", 0, ErrNameInvalid},
+		{"photos//a.jpg", 0, ErrNameInvalid},
+		{"photos/./a.jpg", 0, ErrNameInvalid},
+		{"photos/../a.jpg", 0, ErrNameInvalid},
+		{"photos/..a.jpg", 0, nil},
+		{"photos/a\n.jpg", 0, ErrNameInvalid},
+		{"photos/a\x7f.jpg", 0, ErrNameInvalid},
+		{"photos/\xff.jpg", 0, ErrNameInvalid},
+		{"사진/a.jpg", 0, nil},
+	}
+	for _, tt := range tests {
+		if err := CheckName(tt.name, tt.maxLength); err != tt.err {
+			t.Errorf("CheckName(%q, %d) = %v, want %v", tt.name, tt.maxLength, err, tt.err)
+		}
+	}
+}
+
+func TestNamePolicy(t *testing.T) {
+	tests := []struct {
+		policy    NamePolicy
+		name      string
+		transform string
+		apply     string
+		err       error
+	}{
+		{NamePolicy{}, "Photos/A.jpg", "Photos/A.jpg", "Photos/A.jpg", nil},
+		{NamePolicy{FoldCase: true}, "Photos/A.jpg", "photos/a.jpg", "photos/a.jpg", nil},
+		{NamePolicy{Normalize: true}, "cafe\u0301", "caf\u00e9", "caf\u00e9", nil},
+		{NamePolicy{Normalize: true}, "\u03b1\u0301", "\u03ac", "\u03ac", nil},
+		{NamePolicy{Normalize: true}, "\u304b\u3099", "\u304c", "\u304c", nil},
+		{NamePolicy{Normalize: true}, "\u212b", "\u00c5", "\u00c5", nil},
+		{NamePolicy{Normalize: true}, "\u1112\u1161\u11ab", "\ud55c", "\ud55c", nil},
+		{NamePolicy{Normalize: true, FoldCase: true}, "CAFE\u0301", "caf\u00e9", "caf\u00e9", nil},
+		{NamePolicy{FoldCase: true}, "Stra\u00dfe/\u03a3\u03bf\u03c6\u03b9\u03b1\u03c2", "strasse/\u03c3\u03bf\u03c6\u03b9\u03b1\u03c3", "strasse/\u03c3\u03bf\u03c6\u03b9\u03b1\u03c3", nil},
+		{NamePolicy{FoldCase: true}, "\u212a", "k", "k", nil},
+		{NamePolicy{MaxLength: 4}, "café", "café", "", ErrNameTooLong},
+		{NamePolicy{MaxLength: 5, Normalize: true}, "cafe\u0301", "caf\u00e9", "caf\u00e9", nil},
+		{NamePolicy{Forbidden: "\\:*"}, "photos/a:b", "photos/a:b", "", ErrNameInvalid},
+		{NamePolicy{Forbidden: "\\:*"}, "photos/ab", "photos/ab", "photos/ab", nil},
+		{NamePolicy{Forbidden: ":"}, "photos/\xff", "photos/\xff", "", ErrNameInvalid},
+		{NamePolicy{}, "photos/../a", "photos/../a", "", ErrNameInvalid},
+	}
+	for _, tt := range tests {
+		if name := tt.policy.Transform(tt.name); name != tt.transform {
+			t.Errorf("%+v Transform(%q) = %q, want %q", tt.policy, tt.name, name, tt.transform)
+		}
+		if name, err := tt.policy.Apply(tt.name); name != tt.apply || err != tt.err {
+			t.Errorf("%+v Apply(%q) = %q, %v, want %q, %v", tt.policy, tt.name, name, err, tt.apply, tt.err)
+		}
+	}
+}
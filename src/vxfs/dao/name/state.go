@@ -7,7 +7,10 @@ type NameCounters struct {
 	DeleteCount uint64 `json:"delete_count"`
 }
 
+// the term was increased by the promote, the proxies write to the leader of the highest term
 type NameStats struct {
+	Leader     bool         `json:"leader"`
+	Term       int64        `json:"term"`
 	DataFreeMB uint64       `json:"data_freemb"`
 	Counters   NameCounters `json:"counters"`
 }
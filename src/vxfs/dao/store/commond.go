@@ -1,5 +1,8 @@
 package store
 
+// the meta of a data block was at most the bytes
+const MaxMetaSize = 65534
+
 type WriteRequest struct {
 	Key  int64
 	Meta []byte
@@ -9,10 +12,14 @@ type WriteRequest struct {
 type WriteResponse struct {
 }
 
+// the data from the offset for the length, the whole data if the length was 0
 type ReadRequest struct {
-	Key int64
+	Key    int64
+	Offset int64
+	Length int64
 }
 
+// the size of the whole data
 type ReadResponse struct {
 	Meta []byte
 	Data []byte
@@ -26,6 +33,13 @@ type DeleteRequest struct {
 type DeleteResponse struct {
 }
 
+type UndeleteRequest struct {
+	Key int64
+}
+
+type UndeleteResponse struct {
+}
+
 type StatsRequest struct {
 }
 
@@ -21,4 +21,5 @@ var (
 	ErrDataHeadVersion = errors.New("data head version not match")
 	ErrDataBlockMagic  = errors.New("data block magic not match")
 	ErrDataBlockSizes  = errors.New("data block sizes failed")
+	ErrDataMetaSize    = errors.New("data meta size exceeded")
 )
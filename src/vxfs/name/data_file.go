@@ -19,7 +19,7 @@ import . "vxfs/dao/name"
 // | block ...     |
 // -----------------
 
-// block
+// block (version 1)
 // -----------------
 // | magic number  | --- 4 bytes
 // | store id      | --- 4 bytes
@@ -33,18 +33,39 @@ import . "vxfs/dao/name"
 // | padding       | --- 0~7 bytes
 // -----------------
 
+// block (version 2)
+// -----------------
+// | magic number  | --- 4 bytes
+// | store id      | --- 4 bytes
+// | store key     | --- 8 bytes
+// | flag          | --- 1 byte
+// | padding size  | --- 1 bytes
+// | name size     | --- 2 bytes
+// | meta size     | --- 2 bytes
+// | reserved      | --- 2 bytes
+// | ~~~~~~~~~~~~~ |
+// |    name ...   | --- 0~65534 bytes
+// | ~~~~~~~~~~~~~ |
+// |    meta ...   | --- 0~65534 bytes
+// | ~~~~~~~~~~~~~ |
+// | padding       | --- 0~7 bytes
+// -----------------
+
 const (
-	dataHeadSize      = 16
-	dataBlockHeadSize = 20
+	dataHeadSize        = 16
+	dataBlockHeadSize   = 20
+	dataBlockHeadSizeV2 = 24
 
-	FlagOk  = byte(0)
-	FlagDel = byte(1)
+	FlagOk   = byte(0)
+	FlagDel  = byte(1)
+	FlagTomb = byte(2)
 )
 
 var (
 	dataHeadMagic       = []byte{0xff, 0x4e, 0x46, 0x49}
 	dataHeadMagicSize   = len(dataHeadMagic)
-	dataHeadVersion     = []byte{0x10}
+	dataHeadVersion     = []byte{0x20}
+	dataHeadVersionV1   = []byte{0x10}
 	dataHeadVersionSize = len(dataHeadVersion)
 	dataHeadPadding     = bytes.Repeat([]byte{0x00}, dataHeadSize-dataHeadMagicSize-dataHeadVersionSize)
 
@@ -56,9 +77,10 @@ var (
 type DataFile struct {
 	f *os.File
 
-	File   string
-	Size   int64
-	Offset int64
+	File    string
+	Size    int64
+	Offset  int64
+	Version byte
 }
 
 func NewDataFile(file string) (d *DataFile, err error) {
@@ -90,6 +112,7 @@ func (d *DataFile) init() (err error) {
 			return
 		}
 		d.Size = dataHeadSize
+		d.Version = dataHeadVersion[0]
 	} else {
 		if err = d.parseHead(); err != nil {
 			glog.Errorf("DataFile: \"%s\" parseHead() error(%v)", d.File, err)
@@ -140,20 +163,66 @@ func (d *DataFile) parseHead() (err error) {
 		return ErrDataHeadMagic
 	}
 	cursor += dataBlockHeadMagicSize
-	if !bytes.Equal(header[cursor:cursor+dataHeadVersionSize], dataHeadVersion) {
+	if !bytes.Equal(header[cursor:cursor+dataHeadVersionSize], dataHeadVersion) && !bytes.Equal(header[cursor:cursor+dataHeadVersionSize], dataHeadVersionV1) {
 		return ErrDataHeadVersion
 	}
+	d.Version = header[cursor]
+	return
+}
+
+func (d *DataFile) IsV1() bool {
+	return d.Version == dataHeadVersionV1[0]
+}
+
+func (d *DataFile) blockHeadSize() int32 {
+	if d.IsV1() {
+		return dataBlockHeadSize
+	}
+	return dataBlockHeadSizeV2
+}
+
+func (d *DataFile) parseBlockHead(blockBuffer []byte) (flag byte, sid int32, skey int64, paddingSize int32, nameSize int32, metaSize int32, err error) {
+	cursor := 0
+	if !bytes.Equal(blockBuffer[cursor:cursor+dataBlockHeadMagicSize], dataBlockHeadMagic) {
+		err = ErrDataBlockMagic
+		return
+	}
+	cursor += dataBlockHeadMagicSize
+	sid = int32(binary.BigEndian.Uint32(blockBuffer[cursor:]))
+	cursor += 4
+	skey = int64(binary.BigEndian.Uint64(blockBuffer[cursor:]))
+	cursor += 8
+	flag = blockBuffer[cursor]
+	cursor += 1
+	paddingSize = int32(blockBuffer[cursor])
+	cursor += 1
+	nameSize = int32(binary.BigEndian.Uint16(blockBuffer[cursor:]))
+	cursor += 2
+	if !d.IsV1() {
+		metaSize = int32(binary.BigEndian.Uint16(blockBuffer[cursor:]))
+	}
 	return
 }
 
-func (d *DataFile) Write(name string, sid int32, skey int64) (offset int64, size int32, err error) {
+func (d *DataFile) Write(name string, flag byte, sid int32, skey int64, meta []byte) (offset int64, size int32, err error) {
+	if d.IsV1() {
+		err = ErrDataHeadVersion
+		return
+	}
+
 	var (
 		cursor                 = 0
 		nameBuffer             = []byte(name)
 		nameSize               = len(nameBuffer)
-		blockSize, paddingSize = libs.AlignSize(int32(dataBlockHeadSize+nameSize), 8)
-		blockBuffer            = libs.AllocBuffer(blockSize)
+		metaSize               = len(meta)
+		blockSize, paddingSize = libs.AlignSize(int32(dataBlockHeadSizeV2+nameSize+metaSize), 8)
+		blockBuffer            []byte
 	)
+	if nameSize > MaxNameLength || metaSize > MaxNameLength {
+		err = ErrDataBlockSize
+		return
+	}
+	blockBuffer = libs.AllocBuffer(blockSize)
 	defer libs.FreeBuffer(blockBuffer)
 
 	copy(blockBuffer[cursor:], dataBlockHeadMagic)
@@ -162,13 +231,22 @@ func (d *DataFile) Write(name string, sid int32, skey int64) (offset int64, size
 	cursor += 4
 	binary.BigEndian.PutUint64(blockBuffer[cursor:], uint64(skey))
 	cursor += 8
-	blockBuffer[cursor] = byte(0)
+	blockBuffer[cursor] = flag
 	cursor += 1
 	blockBuffer[cursor] = byte(paddingSize)
 	cursor += 1
 	binary.BigEndian.PutUint16(blockBuffer[cursor:], uint16(nameSize))
 	cursor += 2
+	binary.BigEndian.PutUint16(blockBuffer[cursor:], uint16(metaSize))
+	cursor += 2
+	binary.BigEndian.PutUint16(blockBuffer[cursor:], uint16(0))
+	cursor += 2
 	copy(blockBuffer[cursor:], nameBuffer)
+	cursor += nameSize
+	if metaSize > 0 {
+		copy(blockBuffer[cursor:], meta)
+		cursor += metaSize
+	}
 
 	offset = d.Offset
 	size = blockSize
@@ -180,6 +258,9 @@ func (d *DataFile) Write(name string, sid int32, skey int64) (offset int64, size
 		return
 	}
 	d.Offset += int64(blockSize)
+	if d.Size < d.Offset {
+		d.Size = d.Offset
+	}
 	return
 }
 
@@ -188,18 +269,20 @@ func (d *DataFile) Delete(offset int64) (err error) {
 	return
 }
 
-func (d *DataFile) Recovery(fn func(string, byte, int32, int64, int64, int32) error) (err error) {
+func (d *DataFile) Recovery(fn func(string, byte, int32, int64, []byte, int64, int32) error) (err error) {
 	var (
-		name        string
-		flag        byte
-		sid         int32
-		skey        int64
-		paddingSize int32
-		nameSize    int32
-		bodySize    int32
-		bodyBuffer  []byte
-		cursor      int
-		blockBuffer = make([]byte, dataBlockHeadSize)
+		name          string
+		meta          []byte
+		flag          byte
+		sid           int32
+		skey          int64
+		paddingSize   int32
+		nameSize      int32
+		metaSize      int32
+		bodySize      int32
+		bodyBuffer    []byte
+		blockHeadSize = d.blockHeadSize()
+		blockBuffer   = make([]byte, blockHeadSize)
 	)
 	if _, err = d.f.Seek(dataHeadSize, os.SEEK_SET); err != nil {
 		return
@@ -208,45 +291,33 @@ func (d *DataFile) Recovery(fn func(string, byte, int32, int64, int64, int32) er
 	for {
 		if _, err = d.f.Read(blockBuffer); err != nil {
 			if err != io.EOF {
-				glog.Errorf("DataFile: \"%s\" Read (%d) error(%v)", d.File, dataBlockHeadSize, err)
+				glog.Errorf("DataFile: \"%s\" Read (%d) error(%v)", d.File, blockHeadSize, err)
 			}
 			break
 		}
 
-		cursor = 0
-		if !bytes.Equal(blockBuffer[cursor:cursor+dataBlockHeadMagicSize], dataBlockHeadMagic) {
-			err = ErrDataBlockMagic
-			glog.Errorf("DataFile: \"%s\" Block parseHead (%d) error(%v)", d.File, dataBlockHeadSize, err)
+		if flag, sid, skey, paddingSize, nameSize, metaSize, err = d.parseBlockHead(blockBuffer); err != nil {
+			glog.Errorf("DataFile: \"%s\" Block parseHead (%d) error(%v)", d.File, blockHeadSize, err)
 			break
 		}
-		cursor += dataBlockHeadMagicSize
-		sid = int32(binary.BigEndian.Uint32(blockBuffer[cursor:]))
-		cursor += 4
-		skey = int64(binary.BigEndian.Uint64(blockBuffer[cursor:]))
-		cursor += 8
-		flag = blockBuffer[cursor]
-		cursor += 1
-		paddingSize = int32(blockBuffer[cursor])
-		cursor += 1
-		nameSize = int32(binary.BigEndian.Uint16(blockBuffer[cursor:]))
-		cursor += 2
 
-		bodySize = nameSize + paddingSize
+		bodySize = nameSize + metaSize + paddingSize
 		if int32(len(bodyBuffer)) < bodySize {
 			bodyBuffer = make([]byte, bodySize)
 		}
 		if _, err = d.f.Read(bodyBuffer[:bodySize]); err != nil {
 			if err != io.EOF {
-				glog.Errorf("DataFile: \"%s\" Block readBody (%d) error(%v)", d.File, dataBlockHeadSize, err)
+				glog.Errorf("DataFile: \"%s\" Block readBody (%d) error(%v)", d.File, blockHeadSize, err)
 			}
 			break
 		}
 		name = string(bodyBuffer[:nameSize])
-		if err = fn(name, flag, sid, skey, d.Offset, dataBlockHeadSize+bodySize); err != nil {
-			glog.Errorf("DataFile: \"%s\" callback (%d,%d,%d,%d) error(%v)", d.File, name, flag, sid, skey, err)
+		meta = bodyBuffer[nameSize : nameSize+metaSize]
+		if err = fn(name, flag, sid, skey, meta, d.Offset, blockHeadSize+bodySize); err != nil {
+			glog.Errorf("DataFile: \"%s\" callback (%s,%d,%d,%d) error(%v)", d.File, name, flag, sid, skey, err)
 			break
 		}
-		d.Offset += int64(dataBlockHeadSize + bodySize)
+		d.Offset += int64(blockHeadSize + bodySize)
 	}
 	if err == io.EOF {
 		err = nil
@@ -267,6 +338,53 @@ func (d *DataFile) Recovery(fn func(string, byte, int32, int64, int64, int32) er
 	return
 }
 
+func (d *DataFile) Scan(offset int64, limit int64, count int, fn func(string, byte, int32, int64, []byte, int64, int32) error) (next int64, err error) {
+	var (
+		name          string
+		meta          []byte
+		flag          byte
+		sid           int32
+		skey          int64
+		paddingSize   int32
+		nameSize      int32
+		metaSize      int32
+		bodySize      int32
+		bodyBuffer    []byte
+		blockHeadSize = d.blockHeadSize()
+		blockBuffer   = make([]byte, blockHeadSize)
+	)
+	if offset < dataHeadSize {
+		offset = dataHeadSize
+	}
+	for next = offset; next < limit && count > 0; count-- {
+		if _, err = d.f.ReadAt(blockBuffer, next); err != nil {
+			glog.Errorf("DataFile: \"%s\" ReadAt (%d) error(%v)", d.File, next, err)
+			return
+		}
+
+		if flag, sid, skey, paddingSize, nameSize, metaSize, err = d.parseBlockHead(blockBuffer); err != nil {
+			glog.Errorf("DataFile: \"%s\" Block parseHead (%d) error(%v)", d.File, next, err)
+			return
+		}
+
+		bodySize = nameSize + metaSize + paddingSize
+		if int32(len(bodyBuffer)) < bodySize {
+			bodyBuffer = make([]byte, bodySize)
+		}
+		if _, err = d.f.ReadAt(bodyBuffer[:bodySize], next+int64(blockHeadSize)); err != nil {
+			glog.Errorf("DataFile: \"%s\" Block readBody (%d) error(%v)", d.File, next, err)
+			return
+		}
+		name = string(bodyBuffer[:nameSize])
+		meta = bodyBuffer[nameSize : nameSize+metaSize]
+		if err = fn(name, flag, sid, skey, meta, next, blockHeadSize+bodySize); err != nil {
+			return
+		}
+		next += int64(blockHeadSize + bodySize)
+	}
+	return
+}
+
 func (d *DataFile) Close() {
 	var err error
 	if d.f != nil {
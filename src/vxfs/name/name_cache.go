@@ -1,26 +1,260 @@
 package name
 
 import (
+	"container/heap"
 	"crypto/sha256"
 	"encoding/base64"
+	"sort"
+	"strings"
 	"sync"
 )
+import . "vxfs/dao/name"
 
 type NameBlock struct {
 	Nid    int32
 	Sid    int32
 	Key    int64
 	Offset int64
+	Meta   *NameMeta
+	Prev   *NameBlock
+}
+
+func (k *NameBlock) Version() int64 {
+	if k.Meta == nil {
+		return 0
+	}
+	return k.Meta.Version
+}
+
+func (k *NameBlock) Match(sid int32, key int64, version int64) bool {
+	return k.Sid == sid && k.Key == key && k.Version() == version
+}
+
+func (k *NameBlock) Expired(now int64) bool {
+	return k.Meta != nil && k.Meta.Expires > 0 && k.Meta.Expires <= now
+}
+
+func (k *NameBlock) Tagged(tags []string) bool {
+	for _, tag := range tags {
+		i := strings.Index(tag, ":")
+		if i < 0 || k.Meta == nil {
+			return false
+		}
+		if value, ok := k.Meta.Tags[tag[:i]]; !ok || value != tag[i+1:] {
+			return false
+		}
+	}
+	return true
+}
+
+type NameExpiry struct {
+	Name    string
+	Sid     int32
+	Key     int64
+	Version int64
+	Expires int64
+	Due     int64
+}
+
+type nameExpiries []*NameExpiry
+
+func (h nameExpiries) Len() int            { return len(h) }
+func (h nameExpiries) Less(i, j int) bool  { return h[i].Due < h[j].Due }
+func (h nameExpiries) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nameExpiries) Push(x interface{}) { *h = append(*h, x.(*NameExpiry)) }
+func (h *nameExpiries) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type nameRef struct {
+	sid int32
+	key int64
 }
 
 type NameCache struct {
-	rwlock sync.RWMutex
-	blocks map[string]*NameBlock
+	rwlock   sync.RWMutex
+	blocks   map[string]*NameBlock
+	refs     map[nameRef][]string
+	usages   map[string]*NameUsage
+	prefixes []string
+	index    *NameIndex
+	tags     map[string]*NameIndex
+	expiries nameExpiries
 }
 
-func NewNameCache() (c *NameCache) {
+func NewNameCache(prefixes []string) (c *NameCache) {
 	c = &NameCache{}
+	c.prefixes = prefixes
 	c.blocks = make(map[string]*NameBlock)
+	c.refs = make(map[nameRef][]string)
+	c.usages = make(map[string]*NameUsage)
+	c.index = NewNameIndex()
+	c.tags = make(map[string]*NameIndex)
+	return
+}
+
+// the names refer to the store data, a name may refer it more than once by versions
+func (c *NameCache) ref(name string, k *NameBlock, delta int) {
+	// the delete markers and bucket records had no store data
+	if k.Sid == 0 && k.Key == 0 {
+		return
+	}
+	c.use(name, k, int64(delta))
+	r := nameRef{k.Sid, k.Key}
+	if delta > 0 {
+		c.refs[r] = append(c.refs[r], name)
+		return
+	}
+	names := c.refs[r]
+	for i := range names {
+		if names[i] == name {
+			names[i] = names[len(names)-1]
+			names = names[:len(names)-1]
+			break
+		}
+	}
+	if len(names) > 0 {
+		c.refs[r] = names
+	} else {
+		delete(c.refs, r)
+	}
+}
+
+// the bucket was the first segment of the name, its usage was counted as "<bucket>/"
+func bucketOf(name string) string {
+	if i := strings.Index(name, "/"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (c *NameCache) use(name string, k *NameBlock, delta int64) {
+	if bucket := bucketOf(name); len(bucket) > 0 {
+		c.count(bucket+"/", k, delta)
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(name, prefix) && prefix != bucketOf(name)+"/" {
+			c.count(prefix, k, delta)
+		}
+	}
+}
+
+func (c *NameCache) count(prefix string, k *NameBlock, delta int64) {
+	u := c.usages[prefix]
+	if u == nil {
+		u = &NameUsage{}
+		c.usages[prefix] = u
+	}
+	u.Count += delta
+	if k.Meta != nil {
+		u.Bytes += delta * k.Meta.Size
+	}
+	if u.Count <= 0 {
+		delete(c.usages, prefix)
+	}
+}
+
+func (c *NameCache) counted(prefix string) bool {
+	if len(prefix) > 0 && strings.Index(prefix, "/") == len(prefix)-1 {
+		return true
+	}
+	for _, v := range c.prefixes {
+		if v == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *NameCache) Prefixes() (prefixes []string) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	prefixes = append(prefixes, c.prefixes...)
+	return
+}
+
+// the buckets and configured prefixes were counted, the others walk the names
+func (c *NameCache) Usage(prefix string) (u NameUsage) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	if c.counted(prefix) {
+		if v := c.usages[prefix]; v != nil {
+			u = *v
+		}
+		return
+	}
+	u = c.walkUsage(prefix)
+	return
+}
+
+// the prefix was counted as the configured ones, the names were walked once
+func (c *NameCache) Track(prefix string) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	if len(prefix) < 1 || c.counted(prefix) {
+		return
+	}
+	if u := c.walkUsage(prefix); u.Count > 0 {
+		c.usages[prefix] = &u
+	}
+	c.prefixes = append(c.prefixes[:len(c.prefixes):len(c.prefixes)], prefix)
+}
+
+func (c *NameCache) walkUsage(prefix string) (u NameUsage) {
+	c.index.Walk(prefix, func(name string) bool {
+		if !strings.HasPrefix(name, prefix) {
+			return false
+		}
+		for k := c.blocks[c.toKey(name)]; k != nil; k = k.Prev {
+			if k.Sid == 0 && k.Key == 0 {
+				continue
+			}
+			u.Count += 1
+			if k.Meta != nil {
+				u.Bytes += k.Meta.Size
+			}
+		}
+		return true
+	})
+	return
+}
+
+func (c *NameCache) Refs(sid int32, key int64) int32 {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	return int32(len(c.refs[nameRef{sid, key}]))
+}
+
+func (c *NameCache) Lookup(sid int32, key int64) (names []string) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	names = append(names, c.refs[nameRef{sid, key}]...)
+	return
+}
+
+// the keys of the sid after the marker in order
+func (c *NameCache) LookupKeys(sid int32, marker int64, count int) (keys []int64, more bool) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	for r := range c.refs {
+		if r.sid == sid && r.key > marker {
+			keys = append(keys, r.key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	if len(keys) > count {
+		keys = keys[:count]
+		more = true
+	}
 	return
 }
 
@@ -40,7 +274,7 @@ func (c *NameCache) Get(name string) (k *NameBlock) {
 	return
 }
 
-func (c *NameCache) Set(name string, nid int32, sid int32, key int64, offset int64, size int32) (k *NameBlock) {
+func (c *NameCache) Set(name string, nid int32, sid int32, key int64, meta *NameMeta, offset int64, size int32) (k *NameBlock) {
 	c.rwlock.Lock()
 	defer c.rwlock.Unlock()
 
@@ -49,8 +283,127 @@ func (c *NameCache) Set(name string, nid int32, sid int32, key int64, offset int
 		Sid:    sid,
 		Key:    key,
 		Offset: offset,
+		Meta:   meta,
+	}
+	ckey := c.toKey(name)
+	for v := c.blocks[ckey]; v != nil; v = v.Prev {
+		c.ref(name, v, -1)
+	}
+	c.retag(name, c.blocks[ckey], k)
+	c.blocks[ckey] = k
+	c.index.Insert(name)
+	c.ref(name, k, 1)
+	c.expire(name, k)
+	return
+}
+
+func (c *NameCache) Push(name string, nid int32, sid int32, key int64, meta *NameMeta, offset int64, size int32) (k *NameBlock) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	k = &NameBlock{
+		Nid:    nid,
+		Sid:    sid,
+		Key:    key,
+		Offset: offset,
+		Meta:   meta,
+	}
+	ckey := c.toKey(name)
+	k.Prev = c.blocks[ckey]
+	c.retag(name, k.Prev, k)
+	c.blocks[ckey] = k
+	c.index.Insert(name)
+	c.ref(name, k, 1)
+	c.expire(name, k)
+	return
+}
+
+// replace the block in place and keep the older versions, or push the block without the old one
+func (c *NameCache) Replace(name string, old *NameBlock, nid int32, sid int32, key int64, meta *NameMeta, offset int64, size int32) (k *NameBlock) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	k = &NameBlock{
+		Nid:    nid,
+		Sid:    sid,
+		Key:    key,
+		Offset: offset,
+		Meta:   meta,
+	}
+	var (
+		prev *NameBlock
+		v    *NameBlock
+		ckey = c.toKey(name)
+	)
+	for v = c.blocks[ckey]; v != nil && v != old; prev, v = v, v.Prev {
+	}
+	if v == nil {
+		k.Prev = c.blocks[ckey]
+		c.retag(name, k.Prev, k)
+		c.blocks[ckey] = k
+		c.index.Insert(name)
+	} else {
+		k.Prev = old.Prev
+		if prev != nil {
+			prev.Prev = k
+		} else {
+			c.retag(name, old, k)
+			c.blocks[ckey] = k
+		}
+		c.ref(name, old, -1)
+	}
+	c.ref(name, k, 1)
+	c.expire(name, k)
+	return
+}
+
+func (c *NameCache) Find(name string, version int64) (k *NameBlock) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	for k = c.blocks[c.toKey(name)]; k != nil; k = k.Prev {
+		if k.Version() == version {
+			return
+		}
+	}
+	return
+}
+
+func (c *NameCache) Versions(name string) (ks []NameBlock) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	for k := c.blocks[c.toKey(name)]; k != nil; k = k.Prev {
+		ks = append(ks, *k)
+	}
+	return
+}
+
+func (c *NameCache) Drop(name string, sid int32, key int64, version int64) (k *NameBlock) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	var (
+		prev *NameBlock
+		ckey = c.toKey(name)
+	)
+	for k = c.blocks[ckey]; k != nil; prev, k = k, k.Prev {
+		if !k.Match(sid, key, version) {
+			continue
+		}
+		if prev != nil {
+			prev.Prev = k.Prev
+		} else if k.Prev != nil {
+			c.retag(name, k, k.Prev)
+			c.blocks[ckey] = k.Prev
+		} else {
+			c.retag(name, k, nil)
+			delete(c.blocks, ckey)
+			c.index.Delete(name)
+		}
+		c.ref(name, k, -1)
+		return
 	}
-	c.blocks[c.toKey(name)] = k
 	return
 }
 
@@ -58,5 +411,166 @@ func (c *NameCache) Del(name string) {
 	c.rwlock.Lock()
 	defer c.rwlock.Unlock()
 
-	delete(c.blocks, c.toKey(name))
+	ckey := c.toKey(name)
+	for v := c.blocks[ckey]; v != nil; v = v.Prev {
+		c.ref(name, v, -1)
+	}
+	c.retag(name, c.blocks[ckey], nil)
+	delete(c.blocks, ckey)
+	c.index.Delete(name)
+}
+
+// the names after the marker with the prefix, skip the delete markers and the expired names
+func (c *NameCache) List(prefix string, marker string, count int, now int64, fn func(string, *NameBlock)) (more bool) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	from := prefix
+	if marker > from {
+		from = marker
+	}
+	c.index.Walk(from, func(name string) bool {
+		if !strings.HasPrefix(name, prefix) {
+			return false
+		}
+		if name == marker {
+			return true
+		}
+		k := c.blocks[c.toKey(name)]
+		if k == nil || (k.Meta != nil && k.Meta.Marker) || k.Expired(now) {
+			return true
+		}
+		if count < 1 {
+			more = true
+			return false
+		}
+		fn(name, k)
+		count -= 1
+		return true
+	})
+	return
+}
+
+// the tags of the latest version were indexed
+func (c *NameCache) retag(name string, old *NameBlock, k *NameBlock) {
+	if old != nil && old.Meta != nil {
+		for key, value := range old.Meta.Tags {
+			tag := key + ":" + value
+			if x := c.tags[tag]; x != nil {
+				if x.Delete(name); x.Len() < 1 {
+					delete(c.tags, tag)
+				}
+			}
+		}
+	}
+	if k != nil && k.Meta != nil {
+		for key, value := range k.Meta.Tags {
+			tag := key + ":" + value
+			x := c.tags[tag]
+			if x == nil {
+				x = NewNameIndex()
+				c.tags[tag] = x
+			}
+			x.Insert(name)
+		}
+	}
+}
+
+// the names after the marker with the prefix and all the tags, walk the smallest tag index
+func (c *NameCache) ListTagged(tags []string, prefix string, marker string, count int, now int64, fn func(string, *NameBlock)) (more bool) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	var x *NameIndex
+	for _, tag := range tags {
+		v := c.tags[tag]
+		if v == nil {
+			return
+		}
+		if x == nil || v.Len() < x.Len() {
+			x = v
+		}
+	}
+	if x == nil {
+		return
+	}
+	from := prefix
+	if marker > from {
+		from = marker
+	}
+	x.Walk(from, func(name string) bool {
+		if !strings.HasPrefix(name, prefix) {
+			return false
+		}
+		if name == marker {
+			return true
+		}
+		k := c.blocks[c.toKey(name)]
+		if k == nil || k.Expired(now) || !k.Tagged(tags) {
+			return true
+		}
+		if count < 1 {
+			more = true
+			return false
+		}
+		fn(name, k)
+		count -= 1
+		return true
+	})
+	return
+}
+
+func (c *NameCache) expire(name string, k *NameBlock) {
+	if k.Meta == nil || k.Meta.Expires <= 0 {
+		return
+	}
+	heap.Push(&c.expiries, &NameExpiry{
+		Name:    name,
+		Sid:     k.Sid,
+		Key:     k.Key,
+		Version: k.Version(),
+		Expires: k.Meta.Expires,
+		Due:     k.Meta.Expires,
+	})
+}
+
+// the expiries of the removed or replaced names were dropped here
+func (c *NameCache) Expired(now int64, count int) (es []*NameExpiry) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	for len(es) < count && len(c.expiries) > 0 && c.expiries[0].Due <= now {
+		e := heap.Pop(&c.expiries).(*NameExpiry)
+		for k := c.blocks[c.toKey(e.Name)]; k != nil; k = k.Prev {
+			if k.Match(e.Sid, e.Key, e.Version) && k.Meta.Expires == e.Expires {
+				es = append(es, e)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (c *NameCache) Delay(e *NameExpiry, due int64) {
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	e.Due = due
+	heap.Push(&c.expiries, e)
+}
+
+// walk all names with their blocks, the latest first
+func (c *NameCache) Walk(fn func(string, []NameBlock) error) (err error) {
+	c.rwlock.RLock()
+	defer c.rwlock.RUnlock()
+
+	c.index.Walk("", func(name string) bool {
+		var ks []NameBlock
+		for k := c.blocks[c.toKey(name)]; k != nil; k = k.Prev {
+			ks = append(ks, *k)
+		}
+		err = fn(name, ks)
+		return err == nil
+	})
+	return
 }
@@ -0,0 +1,43 @@
+package name
+
+import (
+	"testing"
+)
+import . "vxfs/dao/name"
+
+func TestNameCacheTrack(t *testing.T) {
+	c := NewNameCache([]string{"img/2019/"})
+	c.Set("img/a.jpg", 1, 1, 1, &NameMeta{Size: 10}, 0, 0)
+	c.Set("img/b/c.jpg", 1, 1, 2, &NameMeta{Size: 20}, 0, 0)
+	c.Set("img/2019/d.jpg", 1, 1, 3, &NameMeta{Size: 40}, 0, 0)
+	c.Track("img/b/")
+	c.Track("img/")
+	c.Track("img/2019/")
+	c.Set("img/b/e.jpg", 1, 1, 4, &NameMeta{Size: 80}, 0, 0)
+	c.Set("img/b/c.jpg", 1, 1, 5, &NameMeta{Size: 30}, 0, 0)
+	c.Del("img/a.jpg")
+	c.Track("img/x/")
+	c.Set("img/x/f.jpg", 1, 1, 6, &NameMeta{Size: 160}, 0, 0)
+
+	tests := []struct {
+		prefix string
+		usage  NameUsage
+	}{
+		{"img/", NameUsage{Count: 4, Bytes: 310}},
+		{"img/b/", NameUsage{Count: 2, Bytes: 110}},
+		{"img/2019/", NameUsage{Count: 1, Bytes: 40}},
+		{"img/x/", NameUsage{Count: 1, Bytes: 160}},
+		{"img/b/e", NameUsage{Count: 1, Bytes: 80}},
+	}
+	for _, tt := range tests {
+		if u := c.Usage(tt.prefix); u != tt.usage {
+			t.Errorf("Usage(%q) = %+v, want %+v", tt.prefix, u, tt.usage)
+		}
+		if u := c.walkUsage(tt.prefix); u != tt.usage {
+			t.Errorf("walkUsage(%q) = %+v, want %+v", tt.prefix, u, tt.usage)
+		}
+	}
+	if prefixes := c.Prefixes(); len(prefixes) != 3 {
+		t.Errorf("Prefixes() = %v, want the configured and 2 tracked", prefixes)
+	}
+}
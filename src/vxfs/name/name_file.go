@@ -1,12 +1,14 @@
 package name
 
 import (
+	"encoding/json"
 	"sync"
 )
 import . "vxfs/dao/name"
 
 type NameFile struct {
 	Nid  int32
+	Fid  int64
 	Data *DataFile
 
 	closed    bool
@@ -14,9 +16,10 @@ type NameFile struct {
 	nameCache *NameCache
 }
 
-func NewNameFile(nid int32, nameCache *NameCache, dataFile string) (n *NameFile, err error) {
+func NewNameFile(nid int32, fid int64, nameCache *NameCache, dataFile string) (n *NameFile, err error) {
 	n = &NameFile{
 		Nid:       nid,
+		Fid:       fid,
 		nameCache: nameCache,
 	}
 	if n.Data, err = NewDataFile(dataFile); err != nil {
@@ -33,9 +36,26 @@ func NewNameFile(nid int32, nameCache *NameCache, dataFile string) (n *NameFile,
 }
 
 func (n *NameFile) init() (err error) {
-	if err = n.Data.Recovery(func(name string, flag byte, sid int32, key int64, offset int64, size int32) (err error) {
+	if err = n.Data.Recovery(func(name string, flag byte, sid int32, key int64, meta []byte, offset int64, size int32) (err error) {
+		var m *NameMeta
+		if m, err = decodeMeta(meta); err != nil {
+			return
+		}
 		if flag == FlagOk {
-			n.nameCache.Set(name, n.Nid, sid, key, offset, size)
+			// the update was written before the old block was deleted
+			if m != nil && m.Version != 0 && m.Update {
+				n.nameCache.Replace(name, n.nameCache.Find(name, m.Version), n.Nid, sid, key, m, offset, size)
+			} else if m != nil && m.Version != 0 {
+				n.nameCache.Push(name, n.Nid, sid, key, m, offset, size)
+			} else {
+				n.nameCache.Set(name, n.Nid, sid, key, m, offset, size)
+			}
+		} else if flag == FlagTomb {
+			var version int64
+			if m != nil {
+				version = m.Version
+			}
+			n.nameCache.Drop(name, sid, key, version)
 		}
 		return
 	}); err != nil {
@@ -44,26 +64,82 @@ func (n *NameFile) init() (err error) {
 	return
 }
 
-func (n *NameFile) Write(req *WriteRequest) (k *NameBlock, err error) {
+func (n *NameFile) write(req *WriteRequest) (offset int64, size int32, err error) {
 	if n.closed {
 		err = ErrNameClosed
 		return
 	}
 
+	var meta []byte
+	if meta, err = json.Marshal(&req.Meta); err != nil {
+		return
+	}
+	n.wlock.Lock()
+	offset, size, err = n.Data.Write(req.Name, FlagOk, req.Sid, req.Key, meta)
+	n.wlock.Unlock()
+	return
+}
+
+func (n *NameFile) Write(req *WriteRequest) (k *NameBlock, err error) {
 	var (
 		offset int64
 		size   int32
 	)
-	n.wlock.Lock()
-	if offset, size, err = n.Data.Write(req.Name, req.Sid, req.Key); err != nil {
-		n.wlock.Unlock()
+	if offset, size, err = n.write(req); err != nil {
 		return
 	}
+	m := req.Meta
+	if m.Version != 0 {
+		k = n.nameCache.Push(req.Name, n.Nid, req.Sid, req.Key, &m, offset, size)
+	} else {
+		k = n.nameCache.Set(req.Name, n.Nid, req.Sid, req.Key, &m, offset, size)
+	}
+	return
+}
+
+// write the block to replace the old one in the cache, the old block was deleted by the caller
+func (n *NameFile) Replace(req *WriteRequest, old *NameBlock) (k *NameBlock, err error) {
+	var (
+		offset int64
+		size   int32
+	)
+	if offset, size, err = n.write(req); err != nil {
+		return
+	}
+	m := req.Meta
+	k = n.nameCache.Replace(req.Name, old, n.Nid, req.Sid, req.Key, &m, offset, size)
+	return
+}
+
+func (n *NameFile) Tomb(name string, k *NameBlock, to string) (err error) {
+	if n.closed {
+		return ErrNameClosed
+	}
+
+	var meta []byte
+	if version := k.Version(); version != 0 || len(to) > 0 {
+		if meta, err = json.Marshal(&NameMeta{Version: version, To: to}); err != nil {
+			return
+		}
+	}
+	n.wlock.Lock()
+	_, _, err = n.Data.Write(name, FlagTomb, k.Sid, k.Key, meta)
 	n.wlock.Unlock()
-	k = n.nameCache.Set(req.Name, n.Nid, req.Sid, req.Key, offset, size)
 	return
 }
 
+func (n *NameFile) Scan(offset int64, count int, fn func(string, byte, int32, int64, []byte, int64, int32) error) (next int64, err error) {
+	if n.closed {
+		err = ErrNameClosed
+		return
+	}
+
+	n.wlock.Lock()
+	limit := n.Data.Offset
+	n.wlock.Unlock()
+	return n.Data.Scan(offset, limit, count, fn)
+}
+
 func (n *NameFile) Delete(k *NameBlock) (err error) {
 	if n.closed {
 		return ErrNameClosed
@@ -89,3 +165,14 @@ func (n *NameFile) Close() {
 		n.Data = nil
 	}
 }
+
+func decodeMeta(meta []byte) (m *NameMeta, err error) {
+	if len(meta) < 1 {
+		return
+	}
+	m = &NameMeta{}
+	if err = json.Unmarshal(meta, m); err != nil {
+		m = nil
+	}
+	return
+}
@@ -3,8 +3,11 @@ package name
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -15,13 +18,16 @@ import (
 import . "vxfs/dao/name"
 
 const (
-	MaxNameSize = 8 * 1024 * 1024 * 1024
+	MaxNameSize  = 8 * 1024 * 1024 * 1024
+	MaxSyncCount = 1000
+	MaxListCount = 1000
 )
 
 type NameGroup struct {
-	DataDir    string
-	dataFreeMB uint64
-	counters   *NameCounters
+	DataDir       string
+	dataFreeMB    uint64
+	maxNameLength int
+	counters      *NameCounters
 
 	current *NameFile
 	rwlock  sync.RWMutex
@@ -33,9 +39,20 @@ type NameGroup struct {
 	nameCache *NameCache
 	nidMaker  *libs.SnowFlake
 	dataPlock *libs.ProcessLock
+
+	replica *NameReplica
+	reaper  *NameReaper
+	refLock sync.Mutex
+
+	// the term of the leader, and the highest term seen from the proxies
+	term     int64
+	seenTerm int64
+
+	journalLock sync.Mutex
+	journalWait chan bool
 }
 
-func NewNameGroup(dataDir string, dataFreeMB int, statsRefresh int) (g *NameGroup, err error) {
+func NewNameGroup(dataDir string, dataFreeMB int, statsRefresh int, usagePrefixes []string) (g *NameGroup, err error) {
 	if err = libs.TestWriteDir(dataDir); err != nil {
 		glog.Errorf("testWriteDir(\"%s\") error(%v)", dataDir, err)
 		return
@@ -48,7 +65,7 @@ func NewNameGroup(dataDir string, dataFreeMB int, statsRefresh int) (g *NameGrou
 	g.namefs = make([]*NameFile, 0, 1000)
 	g.stats = &NameStats{}
 	g.ticker = libs.NewVxTicker(g.refreshStats, time.Duration(statsRefresh)*time.Second)
-	g.nameCache = NewNameCache()
+	g.nameCache = NewNameCache(usagePrefixes)
 	g.nidMaker, _ = libs.NewSnowFlake(int64(1 + libs.Rand.Intn(libs.MaxMachineId)))
 	g.dataPlock = libs.NewProcessLock(dataDir+"/", "name data")
 
@@ -57,6 +74,12 @@ func NewNameGroup(dataDir string, dataFreeMB int, statsRefresh int) (g *NameGrou
 		g = nil
 		return
 	}
+	if err = g.loadTerm(); err != nil {
+		glog.Errorf("NameGroup: \"%s\" load term error(%v)", g.DataDir, err)
+		g.Close()
+		g = nil
+		return
+	}
 
 	g.ticker.Tick()
 	g.ticker.Start()
@@ -72,33 +95,36 @@ func (g *NameGroup) init() (err error) {
 	if err != nil {
 		return
 	}
+	fids := make([]int64, 0, len(files))
 	for _, file := range files {
 		name := file.Name()
 		if m, _ := regexp.MatchString("^ndata-[0-9]+$", name); m {
-			var (
-				nid int32
-				fid int64
-				n   *NameFile
-			)
+			var fid int64
 			if fid, err = strconv.ParseInt(name[6:], 10, 64); err != nil {
 				glog.Errorf("NameGroup: \"%s\" \"%s\" init name error(%v)", g.DataDir, name, err)
 				return
 			}
-
-			nid = int32(len(g.namefs))
-			ndFile := filepath.Join(g.DataDir, fmt.Sprintf("ndata-%d", fid))
-			if n, err = NewNameFile(nid, g.nameCache, ndFile); err != nil {
-				glog.Errorf("NameGroup: \"%s\" \"%d\" init file error(%v)", g.DataDir, fid, err)
-				return
-			}
-			g.namefs = append(g.namefs, n)
-			g.counters.FileCount += 1
+			fids = append(fids, fid)
 		}
 	}
-	for _, v := range g.namefs {
-		if v.Data.Size < MaxNameSize && (g.current == nil || v.Data.Size < g.current.Data.Size) {
-			g.current = v
+	// journal order, the tomb blocks must replay after the blocks they remove
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	for _, fid := range fids {
+		var (
+			nid int32
+			n   *NameFile
+		)
+		nid = int32(len(g.namefs))
+		ndFile := filepath.Join(g.DataDir, fmt.Sprintf("ndata-%d", fid))
+		if n, err = NewNameFile(nid, fid, g.nameCache, ndFile); err != nil {
+			glog.Errorf("NameGroup: \"%s\" \"%d\" init file error(%v)", g.DataDir, fid, err)
+			return
 		}
+		g.namefs = append(g.namefs, n)
+		g.counters.FileCount += 1
+	}
+	if count := len(g.namefs); count > 0 && g.namefs[count-1].Data.Size < MaxNameSize && !g.namefs[count-1].Data.IsV1() {
+		g.current = g.namefs[count-1]
 	}
 	return
 }
@@ -107,7 +133,7 @@ func (g *NameGroup) allocName() (n *NameFile, err error) {
 	g.rwlock.Lock()
 	defer g.rwlock.Unlock()
 
-	if g.current != nil && g.current.Data.Size < MaxNameSize {
+	if g.current != nil && g.current.Data.Size < MaxNameSize && !g.current.Data.IsV1() {
 		n = g.current
 		return
 	}
@@ -115,7 +141,7 @@ func (g *NameGroup) allocName() (n *NameFile, err error) {
 	nid := int32(len(g.namefs))
 	fid, _ := g.nidMaker.NextId()
 	ndFile := filepath.Join(g.DataDir, fmt.Sprintf("ndata-%d", fid))
-	if n, err = NewNameFile(nid, g.nameCache, ndFile); err != nil {
+	if n, err = NewNameFile(nid, fid, g.nameCache, ndFile); err != nil {
 		return
 	}
 	g.current = n
@@ -128,18 +154,90 @@ func (g *NameGroup) Read(req *ReadRequest, res *ReadResponse) (err error) {
 	var (
 		k *NameBlock
 	)
-	if k = g.nameCache.Get(req.Name); k == nil {
+	if req.Version != 0 {
+		k = g.nameCache.Find(req.Name, req.Version)
+	} else {
+		k = g.nameCache.Get(req.Name)
+	}
+	if k == nil || (k.Meta != nil && k.Meta.Marker) {
+		err = ErrNameNotExists
+		return
+	}
+	if !req.Expired && k.Expired(time.Now().Unix()) {
 		err = ErrNameNotExists
 		return
 	}
 
 	res.Sid = k.Sid
 	res.Key = k.Key
+	res.Refs = g.nameCache.Refs(k.Sid, k.Key)
+	if k.Meta != nil {
+		res.Meta = *k.Meta
+	}
 	atomic.AddUint64(&g.counters.ReadCount, uint64(1))
 	return
 }
 
-func (g *NameGroup) Write(req *WriteRequest, res *WriteResponse) (err error) {
+func (g *NameGroup) Versions(req *VersionsRequest, res *VersionsResponse) (err error) {
+	ks := g.nameCache.Versions(req.Name)
+	if len(ks) < 1 {
+		err = ErrNameNotExists
+		return
+	}
+	for _, k := range ks {
+		v := NameVersion{
+			Sid: k.Sid,
+			Key: k.Key,
+		}
+		if k.Meta != nil {
+			v.Meta = *k.Meta
+		}
+		res.Versions = append(res.Versions, v)
+	}
+	atomic.AddUint64(&g.counters.ReadCount, uint64(1))
+	return
+}
+
+// the old leader saw the higher term was read only as the follower
+func (g *NameGroup) isFollower() bool {
+	g.rwlock.RLock()
+	defer g.rwlock.RUnlock()
+
+	return g.replica != nil || g.seenTerm > g.term
+}
+
+// the higher term of the proxies and the new leader, the old leader stop writing, in the lock
+func (g *NameGroup) seeTerm(term int64) {
+	if term <= g.seenTerm {
+		return
+	}
+	if g.replica == nil && term > g.term {
+		glog.Warningf("NameGroup: \"%s\" term %d saw the higher term %d, stop writing\n", g.DataDir, g.term, term)
+	}
+	g.seenTerm = term
+	if err := g.saveTerm(); err != nil {
+		glog.Errorf("NameGroup: \"%s\" save term error(%v)", g.DataDir, err)
+	}
+}
+
+// the writes carried the term known by the proxy, the higher one fenced the leader, the lower one was stale
+func (g *NameGroup) checkLeader(term int64) (err error) {
+	g.rwlock.Lock()
+	defer g.rwlock.Unlock()
+
+	g.seeTerm(term)
+	if g.replica != nil || g.seenTerm > g.term {
+		err = ErrNameFollower
+		return
+	}
+	if term > 0 && term < g.term {
+		err = ErrNameTermStale
+		return
+	}
+	return
+}
+
+func (g *NameGroup) write(req *WriteRequest) (err error) {
 	if g.stats.DataFreeMB < g.dataFreeMB {
 		err = ErrDataNoSpace
 		return
@@ -149,42 +247,538 @@ func (g *NameGroup) Write(req *WriteRequest, res *WriteResponse) (err error) {
 		k *NameBlock
 		n *NameFile
 	)
-	if k = g.nameCache.Get(req.Name); k != nil {
+	if req.Versioned {
+		if req.Meta.Version == 0 {
+			err = ErrNameVersion
+			return
+		}
+		if k = g.nameCache.Find(req.Name, req.Meta.Version); k != nil {
+			err = ErrNameExists
+			return
+		}
+	} else if k = g.nameCache.Get(req.Name); k != nil && (!req.Replace || k.Version() != 0) {
 		err = ErrNameExists
 		return
 	}
+	if req.Link {
+		// the linked store data must be kept by another name
+		g.refLock.Lock()
+		defer g.refLock.Unlock()
+		if g.nameCache.Refs(req.Sid, req.Key) < 1 {
+			err = ErrNameNotExists
+			return
+		}
+	}
+	if req.Meta.Ctime == 0 {
+		req.Meta.Ctime = time.Now().Unix()
+	}
+	if req.Meta.Mtime == 0 {
+		req.Meta.Mtime = req.Meta.Ctime
+	}
+	if k != nil {
+		err = g.update(req, k)
+		return
+	}
 	if n, err = g.allocName(); err != nil {
-		glog.Errorf("NameGroup: \"%s\" \"%d\" allocName() error(%v)", g.DataDir, err)
+		glog.Errorf("NameGroup: \"%s\" allocName() error(%v)", g.DataDir, err)
 		return
 	}
 	if k, err = n.Write(req); err != nil {
 		return
 	}
+	g.notifyJournal()
+	return
+}
+
+func (g *NameGroup) remove(name string, k *NameBlock, to string) (err error) {
+	var (
+		n *NameFile
+		v *NameFile
+	)
+	if n, err = g.allocName(); err != nil {
+		glog.Errorf("NameGroup: \"%s\" allocName() error(%v)", g.DataDir, err)
+		return
+	}
+	if err = n.Tomb(name, k, to); err != nil {
+		return
+	}
+
+	g.rwlock.RLock()
+	v = g.namefs[k.Nid]
+	g.rwlock.RUnlock()
+
+	if err = v.Delete(k); err != nil {
+		return
+	}
+	g.nameCache.Drop(name, k.Sid, k.Key, k.Version())
+	g.notifyJournal()
+	return
+}
+
+// the names of the clients were checked, the replication and import write the names as they were
+func (g *NameGroup) Write(req *WriteRequest, res *WriteResponse) (err error) {
+	if err = g.checkLeader(req.Term); err != nil {
+		return
+	}
+	if err = CheckName(req.Name, g.maxNameLength); err != nil {
+		return
+	}
+	if err = g.write(req); err != nil {
+		return
+	}
 	atomic.AddUint64(&g.counters.WriteCount, uint64(1))
 	return
 }
 
 func (g *NameGroup) Delete(req *DeleteRequest, res *DeleteResponse) (err error) {
+	if err = g.checkLeader(req.Term); err != nil {
+		return
+	}
+
 	var (
 		k *NameBlock
+	)
+	if req.Version != 0 {
+		k = g.nameCache.Find(req.Name, req.Version)
+	} else {
+		k = g.nameCache.Get(req.Name)
+	}
+	if k == nil {
+		return
+	}
+	if req.Marker != 0 {
+		// the delete marker keep the old versions
+		if k.Meta != nil && k.Meta.Marker {
+			return
+		}
+		err = g.write(&WriteRequest{
+			Name:      req.Name,
+			Versioned: true,
+			Meta: NameMeta{
+				Version: req.Marker,
+				Marker:  true,
+			},
+		})
+	} else {
+		g.refLock.Lock()
+		if err = g.remove(req.Name, k, ""); err == nil {
+			res.Removed = true
+			res.Sid = k.Sid
+			res.Key = k.Key
+			res.Refs = g.nameCache.Refs(k.Sid, k.Key)
+			if k.Meta != nil {
+				res.Meta = *k.Meta
+			}
+		}
+		g.refLock.Unlock()
+	}
+	if err != nil {
+		return
+	}
+	atomic.AddUint64(&g.counters.DeleteCount, uint64(1))
+	return
+}
+
+func (g *NameGroup) Rename(req *RenameRequest, res *RenameResponse) (err error) {
+	if err = g.checkLeader(req.Term); err != nil {
+		return
+	}
+
+	var (
+		k    *NameBlock
+		meta NameMeta
+	)
+	if k = g.nameCache.Get(req.Name); k == nil || (k.Meta != nil && k.Meta.Marker) {
+		err = ErrNameNotExists
+		return
+	}
+	if k.Version() != 0 {
+		err = ErrNameVersion
+		return
+	}
+	if err = CheckName(req.To, g.maxNameLength); err != nil {
+		return
+	}
+	if g.nameCache.Get(req.To) != nil {
+		err = ErrNameExists
+		return
+	}
+	if k.Meta != nil {
+		meta = *k.Meta
+	}
+	meta.From = req.Name
+	meta.To = ""
+	meta.Update = false
+	meta.Mtime = time.Now().Unix()
+	if req.Expires > 0 {
+		meta.Expires = req.Expires
+	} else if req.Expires < 0 {
+		meta.Expires = 0
+	}
+	if err = g.write(&WriteRequest{
+		Name: req.To,
+		Sid:  k.Sid,
+		Key:  k.Key,
+		Meta: meta,
+	}); err != nil {
+		return
+	}
+	if err = g.remove(req.Name, k, req.To); err != nil {
+		return
+	}
+	atomic.AddUint64(&g.counters.WriteCount, uint64(1))
+	return
+}
+
+// write the new block before delete the old one, the name was kept on the failures
+func (g *NameGroup) update(req *WriteRequest, k *NameBlock) (err error) {
+	if g.stats.DataFreeMB < g.dataFreeMB {
+		err = ErrDataNoSpace
+		return
+	}
+
+	var (
+		n *NameFile
 		v *NameFile
 	)
-	if k = g.nameCache.Get(req.Name); k == nil {
+	req.Meta.Update = true
+	if n, err = g.allocName(); err != nil {
+		glog.Errorf("NameGroup: \"%s\" allocName() error(%v)", g.DataDir, err)
+		return
+	}
+	if _, err = n.Replace(req, k); err != nil {
 		return
 	}
 
+	// the old block was replaced in the cache, and the recovery replace it by the version too
 	g.rwlock.RLock()
 	v = g.namefs[k.Nid]
 	g.rwlock.RUnlock()
 
-	if err = v.Delete(k); err != nil {
+	if e := v.Delete(k); e != nil {
+		glog.Errorf("NameGroup: \"%s\" update \"%s\" Delete() error(%v)", g.DataDir, req.Name, e)
+	}
+	g.notifyJournal()
+	return
+}
+
+// rewrite the latest version with the tags, the store data was kept by the refs lock
+func (g *NameGroup) Tag(req *TagRequest, res *TagResponse) (err error) {
+	if err = g.checkLeader(req.Term); err != nil {
 		return
 	}
-	g.nameCache.Del(req.Name)
-	atomic.AddUint64(&g.counters.DeleteCount, uint64(1))
+
+	var (
+		k    *NameBlock
+		meta NameMeta
+	)
+	g.refLock.Lock()
+	defer g.refLock.Unlock()
+	if k = g.nameCache.Get(req.Name); k == nil || (k.Meta != nil && k.Meta.Marker) || k.Expired(time.Now().Unix()) {
+		err = ErrNameNotExists
+		return
+	}
+	if k.Meta != nil {
+		meta = *k.Meta
+	}
+	meta.Tags = req.Tags
+	meta.From = ""
+	meta.To = ""
+	if err = g.update(&WriteRequest{
+		Name: req.Name,
+		Sid:  k.Sid,
+		Key:  k.Key,
+		Meta: meta,
+	}, k); err != nil {
+		return
+	}
+	atomic.AddUint64(&g.counters.WriteCount, uint64(1))
+	return
+}
+
+func (g *NameGroup) List(req *ListRequest, res *ListResponse) (err error) {
+	count := req.Limit
+	if count < 1 || count > MaxListCount {
+		count = MaxListCount
+	}
+	fn := func(name string, k *NameBlock) {
+		entry := NameEntry{
+			Name: name,
+			Sid:  k.Sid,
+			Key:  k.Key,
+		}
+		if k.Meta != nil {
+			entry.Meta = *k.Meta
+		}
+		res.Entries = append(res.Entries, entry)
+	}
+	if len(req.Tags) > 0 {
+		res.More = g.nameCache.ListTagged(req.Tags, req.Prefix, req.Marker, count, time.Now().Unix(), fn)
+	} else {
+		res.More = g.nameCache.List(req.Prefix, req.Marker, count, time.Now().Unix(), fn)
+	}
+	return
+}
+
+// the names of the keys, or all keys of the sid without keys
+func (g *NameGroup) LookupByKey(req *LookupRequest, res *LookupResponse) (err error) {
+	keys := req.Keys
+	if len(keys) < 1 {
+		count := req.Limit
+		if count < 1 || count > MaxListCount {
+			count = MaxListCount
+		}
+		keys, res.More = g.nameCache.LookupKeys(req.Sid, req.Marker, count)
+	}
+	for _, key := range keys {
+		if names := g.nameCache.Lookup(req.Sid, key); len(names) > 0 {
+			res.Keys = append(res.Keys, KeyNames{Key: key, Names: names})
+		}
+	}
+	return
+}
+
+func (g *NameGroup) Usage(req *UsageRequest, res *UsageResponse) (err error) {
+	res.Prefixes = req.Prefixes
+	if len(res.Prefixes) < 1 {
+		res.Prefixes = g.nameCache.Prefixes()
+	}
+	for _, prefix := range res.Prefixes {
+		if req.Track {
+			g.nameCache.Track(prefix)
+		}
+		res.Usages = append(res.Usages, g.nameCache.Usage(prefix))
+	}
+	return
+}
+
+func (g *NameGroup) seekName(fid int64) (n *NameFile, next *NameFile) {
+	g.rwlock.RLock()
+	defer g.rwlock.RUnlock()
+
+	for i, v := range g.namefs {
+		if v.Fid >= fid {
+			n = v
+			if i+1 < len(g.namefs) {
+				next = g.namefs[i+1]
+			}
+			break
+		}
+	}
+	return
+}
+
+func (g *NameGroup) Sync(req *SyncRequest, res *SyncResponse) (err error) {
+	var (
+		n     *NameFile
+		next  *NameFile
+		count = req.Limit
+	)
+	if count < 1 || count > MaxSyncCount {
+		count = MaxSyncCount
+	}
+	g.rwlock.RLock()
+	res.Term = g.term
+	g.rwlock.RUnlock()
+	res.Fid = req.Fid
+	res.Offset = req.Offset
+	for len(res.Records) < count {
+		if n, next = g.seekName(res.Fid); n == nil {
+			break
+		}
+		if n.Fid != res.Fid {
+			res.Fid = n.Fid
+			res.Offset = 0
+		}
+		if res.Offset, err = n.Scan(res.Offset, count-len(res.Records), func(name string, flag byte, sid int32, key int64, meta []byte, offset int64, size int32) (err error) {
+			var m *NameMeta
+			if m, err = decodeMeta(meta); err != nil {
+				return
+			}
+			record := SyncRecord{
+				Name: name,
+				Flag: flag,
+				Sid:  sid,
+				Key:  key,
+			}
+			if m != nil {
+				record.Meta = *m
+			}
+			res.Records = append(res.Records, record)
+			return
+		}); err != nil {
+			return
+		}
+		// only a sealed file has the next one
+		if next == nil || len(res.Records) >= count {
+			break
+		}
+		res.Fid = next.Fid
+		res.Offset = 0
+	}
+	return
+}
+
+// the update replaced the meta of the same data, the applied one was skipped
+func isUpdate(k *NameBlock, rec *SyncRecord) bool {
+	if !rec.Meta.Update || !k.Match(rec.Sid, rec.Key, rec.Meta.Version) {
+		return false
+	}
+	return k.Meta == nil || !reflect.DeepEqual(*k.Meta, rec.Meta)
+}
+
+func (g *NameGroup) apply(rec *SyncRecord) (err error) {
+	var (
+		k *NameBlock
+	)
+	switch rec.Flag {
+	case FlagOk:
+		req := &WriteRequest{
+			Name: rec.Name,
+			Sid:  rec.Sid,
+			Key:  rec.Key,
+			Meta: rec.Meta,
+		}
+		if rec.Meta.Version != 0 {
+			if k = g.nameCache.Find(rec.Name, rec.Meta.Version); k == nil {
+				req.Versioned = true
+				err = g.write(req)
+			} else if isUpdate(k, rec) {
+				err = g.update(req, k)
+			}
+			return
+		}
+		if k = g.nameCache.Get(rec.Name); k != nil {
+			if isUpdate(k, rec) {
+				err = g.update(req, k)
+				return
+			}
+			if k.Match(rec.Sid, rec.Key, 0) {
+				return
+			}
+			if err = g.remove(rec.Name, k, ""); err != nil {
+				return
+			}
+		}
+		err = g.write(req)
+	case FlagTomb:
+		if k = g.nameCache.Find(rec.Name, rec.Meta.Version); k != nil && k.Match(rec.Sid, rec.Key, rec.Meta.Version) {
+			err = g.remove(rec.Name, k, rec.Meta.To)
+		}
+	}
+	return
+}
+
+func (g *NameGroup) Follow(leader string, syncRefresh int) (err error) {
+	var replica *NameReplica
+	if replica, err = NewNameReplica(g, leader, syncRefresh); err != nil {
+		return
+	}
+
+	g.rwlock.Lock()
+	if g.replica != nil {
+		g.rwlock.Unlock()
+		replica.Stop()
+		return
+	}
+	g.replica = replica
+	g.rwlock.Unlock()
+
+	replica.Start()
+	return
+}
+
+// the term was "<term> <seen term>" in "nterm" of the data store path
+func (g *NameGroup) loadTerm() (err error) {
+	var body string
+	if body, err = libs.ReadTextFile(filepath.Join(g.DataDir, "nterm")); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	_, err = fmt.Sscanf(body, "%d %d", &g.term, &g.seenTerm)
+	return
+}
+
+func (g *NameGroup) saveTerm() (err error) {
+	file := filepath.Join(g.DataDir, "nterm")
+	body := fmt.Sprintf("%d %d", g.term, g.seenTerm)
+	if err = ioutil.WriteFile(file+".tmp", []byte(body), libs.ModeFile); err != nil {
+		return
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// the follower take the term of its leader
+func (g *NameGroup) followTerm(term int64) {
+	g.rwlock.Lock()
+	defer g.rwlock.Unlock()
+
+	if g.replica == nil || term <= g.term {
+		return
+	}
+	g.term = term
+	if err := g.saveTerm(); err != nil {
+		glog.Errorf("NameGroup: \"%s\" save term error(%v)", g.DataDir, err)
+	}
+}
+
+func (g *NameGroup) stopReplica() (replica *NameReplica) {
+	g.rwlock.Lock()
+	replica = g.replica
+	g.replica = nil
+	g.rwlock.Unlock()
+
+	if replica != nil {
+		replica.Stop()
+	}
+	return
+}
+
+// the leader of the new term, higher than all terms it seen
+func (g *NameGroup) Promote(req *PromoteRequest, res *PromoteResponse) (err error) {
+	g.rwlock.Lock()
+	term := req.Term
+	if term == 0 {
+		term = g.term + 1
+		if g.seenTerm >= term {
+			term = g.seenTerm + 1
+		}
+	}
+	if term <= g.term || term <= g.seenTerm {
+		g.rwlock.Unlock()
+		err = ErrNameTerm
+		return
+	}
+	g.term, g.seenTerm = term, term
+	err = g.saveTerm()
+	g.rwlock.Unlock()
+	if err != nil {
+		return
+	}
+
+	if replica := g.stopReplica(); replica != nil {
+		glog.Infof("NameGroup: \"%s\" promote to leader, stop following (%s)\n", g.DataDir, replica.Leader)
+		g.fence(replica.Leader, term)
+	}
+	glog.Infof("NameGroup: \"%s\" leader of term %d\n", g.DataDir, term)
+	res.Term = term
 	return
 }
 
+// the old leader saw the new term stop writing, the gone one was fenced by the terms of the proxy writes
+func (g *NameGroup) fence(leader string, term int64) {
+	client := libs.NetRpcClient(leader)
+	defer client.Close()
+
+	if err := client.Call("NameService.Stats", &StatsRequest{Term: term}, &StatsResponse{}); err != nil {
+		glog.Warningf("NameGroup: \"%s\" fence the old leader (%s) error(%v)\n", g.DataDir, leader, err)
+		return
+	}
+	glog.Infof("NameGroup: \"%s\" fenced the old leader (%s) by term %d\n", g.DataDir, leader, term)
+}
+
 func (g *NameGroup) refreshStats() {
 	g.stats.DataFreeMB, _ = libs.GetDiskFreeSpace(g.DataDir, 2)
 	g.stats.Counters = *g.counters
@@ -195,11 +789,42 @@ func (g *NameGroup) refreshStats() {
 }
 
 func (g *NameGroup) Stats(req *StatsRequest, res *StatsResponse) (err error) {
+	g.rwlock.Lock()
+	g.seeTerm(req.Term)
 	res.Stats = *g.stats
+	res.Stats.Leader = g.replica == nil && g.seenTerm <= g.term
+	res.Stats.Term = g.term
+	g.rwlock.Unlock()
 	return
 }
 
+// the names longer than the length were rejected on write and rename
+func (g *NameGroup) SetMaxNameLength(length int) {
+	g.maxNameLength = length
+}
+
+func (g *NameGroup) Reap(proxy string, safeCode string, reapRefresh int) {
+	g.rwlock.Lock()
+	if g.reaper != nil {
+		g.rwlock.Unlock()
+		return
+	}
+	g.reaper = NewNameReaper(g, proxy, safeCode, reapRefresh)
+	g.rwlock.Unlock()
+
+	g.reaper.Start()
+}
+
 func (g *NameGroup) Close() {
+	g.stopReplica()
+
+	g.rwlock.RLock()
+	reaper := g.reaper
+	g.rwlock.RUnlock()
+	if reaper != nil {
+		reaper.Stop()
+	}
+
 	g.rwlock.Lock()
 	defer g.rwlock.Unlock()
 
@@ -0,0 +1,91 @@
+package name
+
+import "vxfs/libs"
+
+const (
+	nameIndexLevel = 24
+)
+
+type nameIndexNode struct {
+	name  string
+	nexts []*nameIndexNode
+}
+
+// the skip list of the names in order, for listing
+type NameIndex struct {
+	head  *nameIndexNode
+	level int
+	count int
+}
+
+func NewNameIndex() (x *NameIndex) {
+	x = &NameIndex{}
+	x.head = &nameIndexNode{nexts: make([]*nameIndexNode, nameIndexLevel)}
+	x.level = 1
+	return
+}
+
+func (x *NameIndex) randomLevel() (level int) {
+	for level = 1; level < nameIndexLevel && libs.Rand.Intn(4) == 0; level++ {
+	}
+	return
+}
+
+// the last nodes before the name in every level
+func (x *NameIndex) seek(name string, prevs []*nameIndexNode) (node *nameIndexNode) {
+	node = x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for node.nexts[i] != nil && node.nexts[i].name < name {
+			node = node.nexts[i]
+		}
+		if prevs != nil {
+			prevs[i] = node
+		}
+	}
+	return
+}
+
+func (x *NameIndex) Len() int {
+	return x.count
+}
+
+func (x *NameIndex) Insert(name string) {
+	prevs := make([]*nameIndexNode, nameIndexLevel)
+	if node := x.seek(name, prevs).nexts[0]; node != nil && node.name == name {
+		return
+	}
+	level := x.randomLevel()
+	for ; x.level < level; x.level++ {
+		prevs[x.level] = x.head
+	}
+	node := &nameIndexNode{name: name, nexts: make([]*nameIndexNode, level)}
+	for i := 0; i < level; i++ {
+		node.nexts[i] = prevs[i].nexts[i]
+		prevs[i].nexts[i] = node
+	}
+	x.count += 1
+}
+
+func (x *NameIndex) Delete(name string) {
+	prevs := make([]*nameIndexNode, nameIndexLevel)
+	node := x.seek(name, prevs).nexts[0]
+	if node == nil || node.name != name {
+		return
+	}
+	for i := 0; i < len(node.nexts); i++ {
+		prevs[i].nexts[i] = node.nexts[i]
+	}
+	for x.level > 1 && x.head.nexts[x.level-1] == nil {
+		x.level--
+	}
+	x.count -= 1
+}
+
+// walk the names from the name, until the fn return false
+func (x *NameIndex) Walk(from string, fn func(string) bool) {
+	for node := x.seek(from, nil).nexts[0]; node != nil; node = node.nexts[0] {
+		if !fn(node.name) {
+			return
+		}
+	}
+}
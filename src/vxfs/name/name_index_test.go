@@ -0,0 +1,70 @@
+package name
+
+import (
+	"reflect"
+	"testing"
+)
+
+func walkNames(x *NameIndex, from string, limit int) (names []string) {
+	x.Walk(from, func(name string) bool {
+		names = append(names, name)
+		return len(names) < limit
+	})
+	return
+}
+
+func TestNameIndex(t *testing.T) {
+	x := NewNameIndex()
+	for _, name := range []string{"b/2", "a/1", "c", "b/1", "a/1", "b/10", "b/2", "a"} {
+		x.Insert(name)
+	}
+	x.Delete("c")
+	x.Delete("d")
+	x.Delete("c")
+	tests := []struct {
+		from  string
+		limit int
+		names []string
+	}{
+		{"", 100, []string{"a", "a/1", "b/1", "b/10", "b/2"}},
+		{"", 2, []string{"a", "a/1"}},
+		{"b/", 100, []string{"b/1", "b/10", "b/2"}},
+		{"b/10", 1, []string{"b/10"}},
+		{"b/11", 100, []string{"b/2"}},
+		{"c", 100, nil},
+	}
+	if x.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", x.Len())
+	}
+	for _, tt := range tests {
+		if names := walkNames(x, tt.from, tt.limit); !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("Walk(%q, %d) = %v, want %v", tt.from, tt.limit, names, tt.names)
+		}
+	}
+}
+
+func TestNameIndexLarge(t *testing.T) {
+	x := NewNameIndex()
+	for i := 0; i < 10000; i++ {
+		x.Insert(string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676%26)))
+	}
+	for i := 0; i < 10000; i += 2 {
+		x.Delete(string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676%26)))
+	}
+	var (
+		prev  string
+		count int
+	)
+	x.Walk("", func(name string) bool {
+		if name <= prev {
+			t.Errorf("Walk() %q after %q", name, prev)
+			return false
+		}
+		prev = name
+		count++
+		return true
+	})
+	if count != x.Len() || count != 5000 {
+		t.Errorf("Walk() %d names, Len() %d, want 5000", count, x.Len())
+	}
+}
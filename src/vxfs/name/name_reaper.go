@@ -0,0 +1,130 @@
+package name
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+
+const (
+	MaxReapCount    = 1000
+	ReapHttpTimeout = 10 * time.Second
+)
+
+type NameReaper struct {
+	Proxy    string
+	safeCode string
+	refresh  int64
+
+	g      *NameGroup
+	client *http.Client
+	ticker *libs.VxTicker
+}
+
+func NewNameReaper(g *NameGroup, proxy string, safeCode string, reapRefresh int) (r *NameReaper) {
+	r = &NameReaper{
+		Proxy:    proxy,
+		safeCode: safeCode,
+		refresh:  int64(reapRefresh),
+		g:        g,
+	}
+	r.client = &http.Client{Timeout: ReapHttpTimeout}
+	r.ticker = libs.NewVxTicker(r.reap, time.Duration(reapRefresh)*time.Second)
+	return
+}
+
+// the proxy delete the store data with the name, and purge it without the trash,
+// the errors of the proxy were in the code, 0 for deleted and 102 for not exists
+func (r *NameReaper) remoteDelete(e *NameExpiry) (err error) {
+	var (
+		u      *url.URL
+		req    *http.Request
+		res    *http.Response
+		result struct {
+			Code  int    `json:"code"`
+			Error string `json:"error"`
+		}
+	)
+	if u, err = url.Parse(r.Proxy); err != nil {
+		return
+	}
+	u.Path = "/" + e.Name
+	if e.Version != 0 {
+		u.RawQuery = "versionId=" + strconv.FormatInt(e.Version, 10)
+	} else {
+		u.RawQuery = "purge"
+	}
+	if req, err = http.NewRequest("DELETE", u.String(), nil); err != nil {
+		return
+	}
+	if len(r.safeCode) > 0 {
+		req.Header.Set("VXFS-SAFE-CODE", r.safeCode)
+	}
+	if res, err = r.client.Do(req); err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("proxy status %s", res.Status)
+		return
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return
+	}
+	if result.Code != 0 && result.Code != 102 {
+		err = fmt.Errorf("proxy code %d error(%s)", result.Code, result.Error)
+	}
+	return
+}
+
+func (r *NameReaper) localDelete(e *NameExpiry) (err error) {
+	var k *NameBlock
+	if k = r.g.nameCache.Find(e.Name, e.Version); k == nil || !k.Match(e.Sid, e.Key, e.Version) {
+		return
+	}
+	return r.g.remove(e.Name, k, "")
+}
+
+func (r *NameReaper) reap() {
+	var (
+		err   error
+		count int
+		now   = time.Now().Unix()
+	)
+	// only the leader reap, the follower replay the deletes
+	if r.g.isFollower() {
+		return
+	}
+	for _, e := range r.g.nameCache.Expired(now, MaxReapCount) {
+		if len(r.Proxy) > 0 {
+			err = r.remoteDelete(e)
+		} else {
+			err = r.localDelete(e)
+		}
+		if err != nil {
+			glog.Warningf("NameReaper: \"%s\" reap \"%s\" error(%v)\n", r.g.DataDir, e.Name, err)
+			r.g.nameCache.Delay(e, now+r.refresh)
+			continue
+		}
+		count += 1
+	}
+	if count > 0 {
+		glog.Infof("NameReaper: \"%s\" reaped %d expired names\n", r.g.DataDir, count)
+	}
+}
+
+func (r *NameReaper) Start() {
+	r.ticker.Start()
+}
+
+func (r *NameReaper) Stop() {
+	r.ticker.Stop()
+}
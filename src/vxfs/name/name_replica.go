@@ -0,0 +1,120 @@
+package name
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+import . "vxfs/dao/name"
+
+type NameReplica struct {
+	Leader string
+	Fid    int64
+	Offset int64
+
+	g         *NameGroup
+	client    *libs.RpcClient
+	ticker    *libs.VxTicker
+	stateFile string
+}
+
+func NewNameReplica(g *NameGroup, leader string, syncRefresh int) (r *NameReplica, err error) {
+	r = &NameReplica{
+		Leader: leader,
+		g:      g,
+	}
+	r.client = libs.NetRpcClient(leader)
+	r.ticker = libs.NewVxTicker(r.sync, time.Duration(syncRefresh)*time.Second)
+	r.stateFile = filepath.Join(g.DataDir, "nsync")
+	if err = r.load(); err != nil {
+		glog.Errorf("NameReplica: \"%s\" load error(%v)", r.stateFile, err)
+		r.client.Close()
+		r = nil
+		return
+	}
+	return
+}
+
+func (r *NameReplica) load() (err error) {
+	var (
+		body   string
+		leader string
+		fid    int64
+		offset int64
+	)
+	if body, err = libs.ReadTextFile(r.stateFile); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if _, err = fmt.Sscanf(body, "%s %d %d", &leader, &fid, &offset); err != nil {
+		return
+	}
+	// the position was only meaningful on the same leader
+	if leader == r.Leader {
+		r.Fid = fid
+		r.Offset = offset
+	}
+	return
+}
+
+func (r *NameReplica) save() (err error) {
+	tmpFile := r.stateFile + ".tmp"
+	body := fmt.Sprintf("%s %d %d", r.Leader, r.Fid, r.Offset)
+	if err = ioutil.WriteFile(tmpFile, []byte(body), libs.ModeFile); err != nil {
+		return
+	}
+	return os.Rename(tmpFile, r.stateFile)
+}
+
+func (r *NameReplica) sync() {
+	var (
+		err error
+		req = &SyncRequest{}
+		res *SyncResponse
+	)
+	for {
+		req.Fid = r.Fid
+		req.Offset = r.Offset
+		req.Limit = MaxSyncCount
+		res = &SyncResponse{}
+		if err = r.client.Call("NameService.Sync", req, res); err != nil {
+			glog.Warningf("NameReplica: (%s) Sync error(%v)\n", r.Leader, err)
+			return
+		}
+		r.g.followTerm(res.Term)
+		for i := range res.Records {
+			if err = r.g.apply(&res.Records[i]); err != nil {
+				glog.Errorf("NameReplica: (%s) apply \"%s\" error(%v)", r.Leader, res.Records[i].Name, err)
+				return
+			}
+		}
+		if res.Fid == r.Fid && res.Offset == r.Offset {
+			return
+		}
+		r.Fid = res.Fid
+		r.Offset = res.Offset
+		if err = r.save(); err != nil {
+			glog.Errorf("NameReplica: \"%s\" save error(%v)", r.stateFile, err)
+			return
+		}
+		if len(res.Records) < req.Limit {
+			return
+		}
+	}
+}
+
+func (r *NameReplica) Start() {
+	r.ticker.Tick()
+	r.ticker.Start()
+}
+
+func (r *NameReplica) Stop() {
+	r.ticker.Stop()
+	r.client.Close()
+}
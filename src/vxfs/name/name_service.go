@@ -19,6 +19,26 @@ func (s *NameService) Read(req *ReadRequest, res *ReadResponse) (err error) {
 	return s.g.Read(req, res)
 }
 
+func (s *NameService) Versions(req *VersionsRequest, res *VersionsResponse) (err error) {
+	return s.g.Versions(req, res)
+}
+
+func (s *NameService) List(req *ListRequest, res *ListResponse) (err error) {
+	return s.g.List(req, res)
+}
+
+func (s *NameService) LookupByKey(req *LookupRequest, res *LookupResponse) (err error) {
+	return s.g.LookupByKey(req, res)
+}
+
+func (s *NameService) Tag(req *TagRequest, res *TagResponse) (err error) {
+	return s.g.Tag(req, res)
+}
+
+func (s *NameService) Usage(req *UsageRequest, res *UsageResponse) (err error) {
+	return s.g.Usage(req, res)
+}
+
 func (s *NameService) Delete(req *DeleteRequest, res *DeleteResponse) (err error) {
 	return s.g.Delete(req, res)
 }
@@ -26,3 +46,19 @@ func (s *NameService) Delete(req *DeleteRequest, res *DeleteResponse) (err error
 func (s *NameService) Stats(req *StatsRequest, res *StatsResponse) (err error) {
 	return s.g.Stats(req, res)
 }
+
+func (s *NameService) Sync(req *SyncRequest, res *SyncResponse) (err error) {
+	return s.g.Sync(req, res)
+}
+
+func (s *NameService) Promote(req *PromoteRequest, res *PromoteResponse) (err error) {
+	return s.g.Promote(req, res)
+}
+
+func (s *NameService) Rename(req *RenameRequest, res *RenameResponse) (err error) {
+	return s.g.Rename(req, res)
+}
+
+func (s *NameService) Watch(req *WatchRequest, res *WatchResponse) (err error) {
+	return s.g.Watch(req, res)
+}
@@ -0,0 +1,70 @@
+package name
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+import . "vxfs/dao/name"
+
+// the snapshot was JSON lines of NameEntry, the old versions before the new ones
+func (g *NameGroup) Export(w io.Writer) (count int, err error) {
+	var (
+		buffer  = bufio.NewWriter(w)
+		encoder = json.NewEncoder(buffer)
+		now     = time.Now().Unix()
+	)
+	if err = g.nameCache.Walk(func(name string, ks []NameBlock) (err error) {
+		for i := len(ks) - 1; i >= 0; i-- {
+			if ks[i].Expired(now) {
+				continue
+			}
+			entry := &NameEntry{
+				Name: name,
+				Sid:  ks[i].Sid,
+				Key:  ks[i].Key,
+			}
+			if ks[i].Meta != nil {
+				entry.Meta = *ks[i].Meta
+			}
+			if err = encoder.Encode(entry); err != nil {
+				return
+			}
+			count += 1
+		}
+		return
+	}); err != nil {
+		return
+	}
+	err = buffer.Flush()
+	return
+}
+
+// only import into the empty name data
+func (g *NameGroup) Import(r io.Reader) (count int, err error) {
+	if g.counters.FileCount > 0 {
+		err = ErrNameNotEmpty
+		return
+	}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		entry := &NameEntry{}
+		if err = decoder.Decode(entry); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if err = g.write(&WriteRequest{
+			Name:      entry.Name,
+			Sid:       entry.Sid,
+			Key:       entry.Key,
+			Meta:      entry.Meta,
+			Versioned: entry.Meta.Version != 0,
+		}); err != nil {
+			return
+		}
+		count += 1
+	}
+}
@@ -0,0 +1,180 @@
+package name
+
+import (
+	"strings"
+	"time"
+)
+import . "vxfs/dao/name"
+
+// sequence
+// -----------------
+// | nid + 1       | --- 27 bits
+// | offset        | --- 36 bits
+// -----------------
+
+const (
+	MaxWatchWait = 60
+	MaxWatchScan = 100000
+
+	sequenceOffsetBits = 36
+	sequenceOffsetMask = -1 ^ (-1 << sequenceOffsetBits)
+
+	EventCreate = "create"
+	EventDelete = "delete"
+	EventRename = "rename"
+	EventUpdate = "update"
+)
+
+func toSequence(nid int32, offset int64) int64 {
+	return int64(nid+1)<<sequenceOffsetBits | offset
+}
+
+func fromSequence(sequence int64) (nid int32, offset int64) {
+	if sequence <= 0 {
+		return
+	}
+	nid = int32(sequence>>sequenceOffsetBits) - 1
+	offset = sequence & sequenceOffsetMask
+	return
+}
+
+func toEvent(name string, flag byte, sid int32, key int64, m *NameMeta) (e *NameEvent) {
+	e = &NameEvent{
+		Type: EventCreate,
+		Name: name,
+		Sid:  sid,
+		Key:  key,
+	}
+	if m != nil {
+		e.Meta = *m
+	}
+	if flag == FlagTomb {
+		// the rename was reported by its create block
+		if len(e.Meta.To) > 0 {
+			e = nil
+			return
+		}
+		e.Type = EventDelete
+	} else if e.Meta.Marker {
+		e.Type = EventDelete
+	} else if len(e.Meta.From) > 0 {
+		e.Type = EventRename
+		e.From = e.Meta.From
+	} else if e.Meta.Update {
+		e.Type = EventUpdate
+	}
+	return
+}
+
+func (g *NameGroup) notifyJournal() {
+	g.journalLock.Lock()
+	defer g.journalLock.Unlock()
+
+	if g.journalWait != nil {
+		close(g.journalWait)
+		g.journalWait = nil
+	}
+}
+
+func (g *NameGroup) waitJournal() <-chan bool {
+	g.journalLock.Lock()
+	defer g.journalLock.Unlock()
+
+	if g.journalWait == nil {
+		g.journalWait = make(chan bool)
+	}
+	return g.journalWait
+}
+
+func (g *NameGroup) nameAt(nid int32) (n *NameFile, next *NameFile) {
+	g.rwlock.RLock()
+	defer g.rwlock.RUnlock()
+
+	if nid >= 0 && int(nid) < len(g.namefs) {
+		n = g.namefs[nid]
+		if int(nid)+1 < len(g.namefs) {
+			next = g.namefs[nid+1]
+		}
+	}
+	return
+}
+
+func (g *NameGroup) watch(req *WatchRequest, res *WatchResponse, count int) (err error) {
+	var (
+		n       *NameFile
+		next    *NameFile
+		scanned int
+	)
+	nid, offset := fromSequence(res.Sequence)
+	for len(res.Events) < count && scanned < MaxWatchScan {
+		if n, next = g.nameAt(nid); n == nil {
+			break
+		}
+		if offset, err = n.Scan(offset, count-len(res.Events), func(name string, flag byte, sid int32, key int64, meta []byte, offset int64, size int32) (err error) {
+			var (
+				m *NameMeta
+				e *NameEvent
+			)
+			scanned += 1
+			if m, err = decodeMeta(meta); err != nil {
+				return
+			}
+			if e = toEvent(name, flag, sid, key, m); e == nil {
+				return
+			}
+			if !strings.HasPrefix(e.Name, req.Prefix) && !strings.HasPrefix(e.From, req.Prefix) {
+				return
+			}
+			e.Sequence = toSequence(nid, offset+int64(size))
+			res.Events = append(res.Events, *e)
+			return
+		}); err != nil {
+			return
+		}
+		res.Sequence = toSequence(nid, offset)
+		if len(res.Events) >= count {
+			break
+		}
+		// only a sealed file has the next one
+		if next == nil {
+			break
+		}
+		if offset < n.Data.Offset {
+			continue
+		}
+		nid += 1
+		offset = 0
+	}
+	return
+}
+
+func (g *NameGroup) Watch(req *WatchRequest, res *WatchResponse) (err error) {
+	var (
+		count    = req.Limit
+		wait     = req.Wait
+		deadline time.Time
+	)
+	if count < 1 || count > MaxSyncCount {
+		count = MaxSyncCount
+	}
+	if wait > MaxWatchWait {
+		wait = MaxWatchWait
+	}
+	deadline = time.Now().Add(time.Duration(wait) * time.Second)
+	res.Sequence = req.Sequence
+	for {
+		waiter := g.waitJournal()
+		if err = g.watch(req, res, count); err != nil {
+			return
+		}
+		remain := deadline.Sub(time.Now())
+		if len(res.Events) > 0 || remain <= 0 {
+			return
+		}
+		select {
+		case <-waiter:
+		case <-time.After(remain):
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"vxfs/dao/name"
+	"vxfs/libs/glog"
+)
+
+// bucket record: .bucket/<bucket>
+const (
+	BucketPrefix = ".bucket/"
+)
+
+var (
+	bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,62}$`)
+)
+
+func isBucketRecord(path string) bool {
+	return strings.HasPrefix(path, BucketPrefix)
+}
+
+func bucketOf(path string) string {
+	if i := strings.Index(path, "/"); i > 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func (s *ServiceManager) EnableBuckets() {
+	s.bucketLock.Lock()
+	s.bucketMode = true
+	s.buckets = make(map[string]*name.Bucket)
+	s.bucketUsages = make(map[string]name.NameUsage)
+	s.bucketLock.Unlock()
+
+	s.refreshBuckets()
+}
+
+func (s *ServiceManager) refreshBuckets() {
+	var (
+		err     error
+		names   []string
+		usages  map[string]name.NameUsage
+		buckets = make(map[string]*name.Bucket)
+		nlreq   = &name.ListRequest{Prefix: BucketPrefix}
+	)
+	for {
+		nlres := &name.ListResponse{}
+		if err = s.ListName(nlreq, nlres, false); err != nil {
+			glog.Warningf("Buckets refresh error(%v)\n", err)
+			return
+		}
+		for _, v := range nlres.Entries {
+			if v.Meta.Bucket != nil {
+				buckets[v.Meta.Bucket.Name] = v.Meta.Bucket
+				names = append(names, v.Meta.Bucket.Name+"/")
+			}
+		}
+		if !nlres.More || len(nlres.Entries) < 1 {
+			break
+		}
+		nlreq.Marker = nlres.Entries[len(nlres.Entries)-1].Name
+	}
+	if len(names) > 0 {
+		if usages, err = s.UsageName(names, false); err != nil {
+			glog.Warningf("Buckets usage refresh error(%v)\n", err)
+		}
+	}
+
+	s.bucketLock.Lock()
+	s.buckets = buckets
+	if err == nil {
+		s.bucketUsages = make(map[string]name.NameUsage)
+		for k, u := range usages {
+			s.bucketUsages[strings.TrimSuffix(k, "/")] = u
+		}
+	}
+	s.bucketLock.Unlock()
+}
+
+func (s *ServiceManager) GetBucket(bucket string) (b *name.Bucket, u name.NameUsage) {
+	s.bucketLock.RLock()
+	defer s.bucketLock.RUnlock()
+
+	b = s.buckets[bucket]
+	u = s.bucketUsages[bucket]
+	return
+}
+
+func (s *ServiceManager) Buckets() (bs []*name.Bucket, us []name.NameUsage) {
+	s.bucketLock.RLock()
+	defer s.bucketLock.RUnlock()
+
+	for _, b := range s.buckets {
+		bs = append(bs, b)
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].Name < bs[j].Name })
+	for _, b := range bs {
+		us = append(us, s.bucketUsages[b.Name])
+	}
+	return
+}
+
+// replace the bucket record on the name server, the old record was kept on the failures
+func (s *ServiceManager) PutBucket(b *name.Bucket) (err error) {
+	path := BucketPrefix + b.Name
+	if err = s.WriteName(&name.WriteRequest{Name: path, Meta: name.NameMeta{Bucket: b}, Replace: true}, &name.WriteResponse{}); err != nil {
+		return
+	}
+
+	s.bucketLock.Lock()
+	s.buckets[b.Name] = b
+	s.bucketLock.Unlock()
+	return
+}
+
+func (s *ServiceManager) DeleteBucket(bucket string) (err error) {
+	if err = s.DeleteName(&name.DeleteRequest{Name: BucketPrefix + bucket}, &name.DeleteResponse{}); err != nil {
+		return
+	}
+
+	s.bucketLock.Lock()
+	delete(s.buckets, bucket)
+	s.bucketLock.Unlock()
+	return
+}
+
+// the bucket mode, and the domain for the virtual host buckets
+func (s *ProxyServer) SetBuckets(host string) {
+	s.bucketMode = true
+	s.bucketHost = strings.TrimPrefix(host, ".")
+	s.serviceManager.EnableBuckets()
+}
+
+func (s *ProxyServer) hostBucket(req *http.Request) string {
+	return hostBucket(req.Host, s.bucketHost)
+}
+
+// the bucket of "<bucket>.domain", the port was ignored
+func hostBucket(host string, domain string) string {
+	if len(domain) < 1 {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	suffix := "." + strings.TrimPrefix(domain, ".")
+	if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+		return host[:len(host)-len(suffix)]
+	}
+	return ""
+}
+
+// the virtual host bucket was rewritten into the path, the dot names and the root were not in a bucket
+func (s *ProxyServer) routeBucket(req *http.Request) (b *name.Bucket, system bool, err error) {
+	if bucket := s.hostBucket(req); len(bucket) > 0 {
+		req.URL.Path = "/" + bucket + req.URL.Path
+	}
+	path := req.URL.Path[1:]
+	if len(path) < 1 || path[0] == '.' {
+		system = isReservedName(path)
+		return
+	}
+	if b, _ = s.serviceManager.GetBucket(bucketOf(path)); b == nil {
+		err = ErrBucketNotExists
+		return
+	}
+	return
+}
+
+func (s *ProxyServer) getBucket(path string) (b *name.Bucket, u name.NameUsage) {
+	if !s.bucketMode {
+		return
+	}
+	return s.serviceManager.GetBucket(bucketOf(path))
+}
+
+func bucketSids(b *name.Bucket) []int32 {
+	if b == nil {
+		return nil
+	}
+	return b.Sids
+}
+
+// the quota was checked with the usage of last refresh
+func checkBucket(b *name.Bucket, u name.NameUsage, size int64) error {
+	if b.MaxSize > 0 && size > b.MaxSize {
+		return ErrBucketMaxSize
+	}
+	if b.Quota > 0 && u.Bytes+size > b.Quota {
+		return ErrBucketQuota
+	}
+	return nil
+}
+
+func bucketData(b *name.Bucket, u name.NameUsage) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     b.Name,
+		"sids":     b.Sids,
+		"max_size": b.MaxSize,
+		"ttl":      b.TTL,
+		"quota":    b.Quota,
+		"count":    u.Count,
+		"bytes":    u.Bytes,
+	}
+}
+
+func (s *ProxyServer) handleBuckets(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	bs, us := s.serviceManager.Buckets()
+	buckets := make([]map[string]interface{}, 0, len(bs))
+	for i := range bs {
+		buckets = append(buckets, bucketData(bs[i], us[i]))
+	}
+	xdata["buckets"] = buckets
+}
+
+func (s *ProxyServer) handlePutBucket(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		body  []byte
+		b     = &name.Bucket{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if body, err = ioutil.ReadAll(req.Body); err != nil {
+		err = ErrHttpBucket
+		return
+	}
+	req.Body.Close()
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, b); err != nil {
+			err = ErrHttpBucket
+			return
+		}
+	}
+	if b.Name = req.URL.Query().Get("bucket"); !bucketNameRegexp.MatchString(b.Name) {
+		err = ErrHttpBucket
+		return
+	}
+	if b.MaxSize < 0 || b.TTL < 0 || b.Quota < 0 {
+		err = ErrHttpBucket
+		return
+	}
+	if err = s.serviceManager.PutBucket(b); err != nil {
+		return
+	}
+	xdata["bucket"] = bucketData(b, name.NameUsage{})
+}
+
+func (s *ProxyServer) handleDeleteBucket(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		xdata  = map[string]interface{}{}
+		bucket = req.URL.Query().Get("bucket")
+		usages map[string]name.NameUsage
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if b, _ := s.serviceManager.GetBucket(bucket); b == nil {
+		err = ErrBucketNotExists
+		return
+	}
+	if usages, err = s.serviceManager.UsageName([]string{bucket + "/"}, false); err != nil {
+		return
+	}
+	if usages[bucket+"/"].Count > 0 {
+		err = ErrBucketNotEmpty
+		return
+	}
+	if err = s.serviceManager.DeleteBucket(bucket); err != nil {
+		return
+	}
+}
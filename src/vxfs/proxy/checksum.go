@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// the hashes of the upload body, the sha1 was the etag
+type bodyHash struct {
+	sha1   hash.Hash
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newBodyHash() *bodyHash {
+	return &bodyHash{sha1: sha1.New(), md5: md5.New(), sha256: sha256.New()}
+}
+
+func (h *bodyHash) Write(data []byte) (int, error) {
+	h.sha1.Write(data)
+	h.md5.Write(data)
+	h.sha256.Write(data)
+	return len(data), nil
+}
+
+func (h *bodyHash) fill(meta *FileMeta) {
+	meta.ETag = hex.EncodeToString(h.sha1.Sum(nil))
+	meta.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	meta.SHA256 = hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// the base64 checksum was compared with the hex one
+func checksumEqual(value string, sum string) (bool, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return false, ErrHttpChecksum
+	}
+	return hex.EncodeToString(data) == sum, nil
+}
+
+// the Content-MD5 and Digest were base64, the X-VXFS-Checksum-SHA256 was hex, the unknown digests were ignored
+func verifyChecksums(req *http.Request, meta *FileMeta) (err error) {
+	var ok bool
+	if value := req.Header.Get("Content-MD5"); len(value) > 0 {
+		if ok, err = checksumEqual(value, meta.MD5); err != nil {
+			return
+		}
+		if !ok {
+			err = ErrChecksumMismatch
+			return
+		}
+	}
+	if value := req.Header.Get("X-VXFS-Checksum-SHA256"); len(value) > 0 {
+		if strings.ToLower(strings.TrimSpace(value)) != meta.SHA256 {
+			err = ErrChecksumMismatch
+			return
+		}
+	}
+	for _, digest := range strings.Split(req.Header.Get("Digest"), ",") {
+		var sum string
+		fields := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "md5":
+			sum = meta.MD5
+		case "sha-256":
+			sum = meta.SHA256
+		case "sha":
+			sum = meta.ETag
+		default:
+			continue
+		}
+		if ok, err = checksumEqual(fields[1], sum); err != nil {
+			return
+		}
+		if !ok {
+			err = ErrChecksumMismatch
+			return
+		}
+	}
+	return
+}
+
+// the files uploaded before the checksums have only the etag
+func setChecksumHeader(header http.Header, meta *FileMeta) {
+	var digests []string
+	if data, err := hex.DecodeString(meta.MD5); err == nil && len(data) > 0 {
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(data))
+		digests = append(digests, "md5="+base64.StdEncoding.EncodeToString(data))
+	}
+	if data, err := hex.DecodeString(meta.SHA256); err == nil && len(data) > 0 {
+		header.Set("X-VXFS-Checksum-SHA256", meta.SHA256)
+		digests = append(digests, "sha-256="+base64.StdEncoding.EncodeToString(data))
+	}
+	if len(digests) > 0 {
+		header.Set("Digest", strings.Join(digests, ","))
+	}
+}
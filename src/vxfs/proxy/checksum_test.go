@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	// the hashes of "hello"
+	meta := &FileMeta{}
+	hash := newBodyHash()
+	hash.Write([]byte("hello"))
+	hash.fill(meta)
+	tests := []struct {
+		headers map[string]string
+		err     error
+	}{
+		{nil, nil},
+		{map[string]string{"Content-MD5": "XUFAKrxLKna5cZ2REBfFkg=="}, nil},
+		{map[string]string{"Content-MD5": "AAAAAAAAAAAAAAAAAAAAAA=="}, ErrChecksumMismatch},
+		{map[string]string{"Content-MD5": "not base64"}, ErrHttpChecksum},
+		{map[string]string{"X-VXFS-Checksum-SHA256": "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"}, nil},
+		{map[string]string{"X-VXFS-Checksum-SHA256": "00"}, ErrChecksumMismatch},
+		{map[string]string{"Digest": "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=, unknown=abc"}, nil},
+		{map[string]string{"Digest": "md5=XUFAKrxLKna5cZ2REBfFkg==,sha=qvTGHdzF6KLavt4PO0gs2a6pQ00="}, nil},
+		{map[string]string{"Digest": "sha=AAAAAAAAAAAAAAAAAAAAAAAAAAA="}, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("PUT", "http://vxfs/a.txt", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if err := verifyChecksums(req, meta); err != tt.err {
+			t.Errorf("verifyChecksums(%v) = %v, want %v", tt.headers, err, tt.err)
+		}
+	}
+}
+
+func TestSetChecksumHeader(t *testing.T) {
+	meta := &FileMeta{}
+	hash := newBodyHash()
+	hash.Write([]byte("hello"))
+	hash.fill(meta)
+	header := http.Header{}
+	setChecksumHeader(header, meta)
+	want := map[string]string{
+		"Content-MD5":            "XUFAKrxLKna5cZ2REBfFkg==",
+		"X-VXFS-Checksum-SHA256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"Digest":                 "md5=XUFAKrxLKna5cZ2REBfFkg==,sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=",
+	}
+	for k, v := range want {
+		if header.Get(k) != v {
+			t.Errorf("header %s = %q, want %q", k, header.Get(k), v)
+		}
+	}
+	header = http.Header{}
+	setChecksumHeader(header, &FileMeta{ETag: meta.ETag})
+	if len(header) > 0 {
+		t.Errorf("header of the etag only = %v, want empty", header)
+	}
+}
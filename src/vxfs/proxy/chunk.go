@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"vxfs/dao/store"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+
+const (
+	DefaultChunkSize = 8 * 1024 * 1024
+)
+
+// the store data of a large file was the manifest of its chunks in order
+type Chunk struct {
+	Sid  int32 `json:"sid"`
+	Key  int64 `json:"key"`
+	Size int64 `json:"size"`
+}
+
+type Manifest struct {
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// the uploads larger than the size were written by chunks, 0 for disable
+func (s *ProxyServer) SetChunkSize(size int64) {
+	s.chunkSize = size
+}
+
+func (s *ProxyServer) isChunked(req *http.Request) bool {
+	return s.chunkSize > 0 && (req.ContentLength < 0 || req.ContentLength > s.chunkSize)
+}
+
+// the body was read by chunks, the chunks were deleted on error, the empty body got no chunks
+func (s *ProxyServer) writeChunks(req *http.Request, maxSize int64, pool []int32, meta *FileMeta) (m *Manifest, err error) {
+	var (
+		n      int
+		hash   = newBodyHash()
+		size   = s.chunkSize
+		buffer []byte
+	)
+	if size < 1 {
+		size = DefaultChunkSize
+	}
+	// the buffer was not larger than the known body
+	if req.ContentLength >= 0 && req.ContentLength < size {
+		size = req.ContentLength + 1
+	}
+	buffer = make([]byte, size)
+	m = &Manifest{}
+	defer func() {
+		if err != nil {
+			s.deleteChunks(m)
+			m = nil
+		}
+	}()
+
+	for {
+		if n, err = io.ReadFull(req.Body, buffer); err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		} else if err != nil {
+			err = ErrHttpUploadBody
+			return
+		}
+		if n < 1 {
+			break
+		}
+		if maxSize > 0 && m.Size+int64(n) > maxSize {
+			err = ErrBucketMaxSize
+			return
+		}
+		if len(m.Chunks) < 1 {
+			meta.Mime = s.detectMime(req, buffer[:n])
+		}
+		hash.Write(buffer[:n])
+
+		c := Chunk{Size: int64(n)}
+		if c.Key, err = s.keyMaker.NextId(); err != nil {
+			return
+		}
+		if c.Sid, err = s.serviceManager.GetSid(c.Size, pool); err != nil {
+			return
+		}
+		if err = s.serviceManager.WriteStore(c.Sid, &store.WriteRequest{Key: c.Key, Data: buffer[:n]}, &store.WriteResponse{}); err != nil {
+			return
+		}
+		m.Chunks = append(m.Chunks, c)
+		m.Size += c.Size
+		if n < len(buffer) {
+			break
+		}
+	}
+	if len(m.Chunks) < 1 {
+		meta.Mime = s.detectMime(req, nil)
+	}
+	hash.fill(meta)
+	return
+}
+
+// copy the chunks to the new keys, for the copy across the shards
+func (s *ProxyServer) copyChunks(m *Manifest, pool []int32) (to *Manifest, err error) {
+	to = &Manifest{Size: m.Size}
+	defer func() {
+		if err != nil {
+			s.deleteChunks(to)
+			to = nil
+		}
+	}()
+
+	for _, c := range m.Chunks {
+		var (
+			sres = &store.ReadResponse{}
+			copy = Chunk{Size: c.Size}
+		)
+		if err = s.serviceManager.ReadStore(c.Sid, &store.ReadRequest{Key: c.Key}, sres); err != nil {
+			return
+		}
+		if copy.Key, err = s.keyMaker.NextId(); err != nil {
+			return
+		}
+		if copy.Sid, err = s.serviceManager.GetSid(c.Size, pool); err != nil {
+			return
+		}
+		if err = s.serviceManager.WriteStore(copy.Sid, &store.WriteRequest{Key: copy.Key, Data: sres.Data}, &store.WriteResponse{}); err != nil {
+			return
+		}
+		to.Chunks = append(to.Chunks, copy)
+	}
+	return
+}
+
+func (s *ProxyServer) deleteChunks(m *Manifest) {
+	for _, c := range m.Chunks {
+		if err := s.serviceManager.DeleteStore(c.Sid, &store.DeleteRequest{Key: c.Key}, &store.DeleteResponse{}); err != nil && !libs.IsErrorSame(err, store.ErrStoreNotExists) {
+			glog.Warningf("Delete chunk (%d/%d) error(%v)\n", c.Sid, c.Key, err)
+		}
+	}
+}
+
+func (s *ProxyServer) readManifest(sid int32, key int64) (m *Manifest, err error) {
+	sres := &store.ReadResponse{}
+	if err = s.serviceManager.ReadStore(sid, &store.ReadRequest{Key: key}, sres); err != nil {
+		return
+	}
+	m = &Manifest{}
+	err = json.Unmarshal(sres.Data, m)
+	return
+}
+
+// delete the store data, and the chunks of a manifest
+func (s *ProxyServer) deleteData(sid int32, key int64, chunked bool) (err error) {
+	if chunked {
+		var m *Manifest
+		if m, err = s.readManifest(sid, key); err != nil {
+			if libs.IsErrorSame(err, store.ErrStoreNotExists) {
+				err = nil
+			}
+			return
+		}
+		s.deleteChunks(m)
+	}
+	if err = s.serviceManager.DeleteStore(sid, &store.DeleteRequest{Key: key}, &store.DeleteResponse{}); err != nil {
+		if libs.IsErrorSame(err, store.ErrStoreNotExists) {
+			err = nil
+		}
+	}
+	return
+}
+
+func (s *ProxyServer) undeleteData(sid int32, key int64, chunked bool) (err error) {
+	if err = s.serviceManager.UndeleteStore(sid, &store.UndeleteRequest{Key: key}, &store.UndeleteResponse{}); err != nil {
+		return
+	}
+	if !chunked {
+		return
+	}
+	var m *Manifest
+	if m, err = s.readManifest(sid, key); err != nil {
+		return
+	}
+	for _, c := range m.Chunks {
+		if err = s.serviceManager.UndeleteStore(c.Sid, &store.UndeleteRequest{Key: c.Key}, &store.UndeleteResponse{}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the store read of a chunk in the range
+type chunkRead struct {
+	sid  int32
+	sreq store.ReadRequest
+}
+
+// the reads of the chunks in the range, the whole chunk was read without the offset and length
+func chunkReads(m *Manifest, start int64, length int64) (reads []chunkRead) {
+	for _, c := range m.Chunks {
+		if length < 1 {
+			break
+		}
+		if start >= c.Size {
+			start -= c.Size
+			continue
+		}
+		var (
+			n = c.Size - start
+			r = chunkRead{sid: c.Sid, sreq: store.ReadRequest{Key: c.Key}}
+		)
+		if n > length {
+			n = length
+		}
+		if n < c.Size {
+			r.sreq.Offset = start
+			r.sreq.Length = n
+		}
+		reads = append(reads, r)
+		length -= n
+		start = 0
+	}
+	return
+}
+
+// write the data in the range chunk by chunk
+func (s *ProxyServer) readChunks(w io.Writer, m *Manifest, start int64, length int64) (err error) {
+	for _, r := range chunkReads(m, start, length) {
+		sres := &store.ReadResponse{}
+		if err = s.serviceManager.ReadStore(r.sid, &r.sreq, sres); err != nil {
+			return
+		}
+		if _, err = w.Write(sres.Data); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the status and headers were sent, the errors only break the body
+func (s *ProxyServer) streamChunks(res http.ResponseWriter, m *Manifest, ranges []httpRange, mime string, size int64) {
+	var (
+		err    error
+		header = res.Header()
+	)
+	if len(mime) < 1 {
+		mime = "application/octet-stream"
+	}
+	switch len(ranges) {
+	case 0:
+		header.Set("Content-Type", mime)
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+		res.WriteHeader(http.StatusOK)
+		err = s.readChunks(res, m, 0, size)
+	case 1:
+		header.Set("Content-Type", mime)
+		header.Set("Content-Range", ranges[0].contentRange(size))
+		header.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		res.WriteHeader(http.StatusPartialContent)
+		err = s.readChunks(res, m, ranges[0].start, ranges[0].length)
+	default:
+		writer := multipart.NewWriter(res)
+		header.Set("Content-Type", "multipart/byteranges; boundary="+writer.Boundary())
+		res.WriteHeader(http.StatusPartialContent)
+		for _, r := range ranges {
+			var part io.Writer
+			if part, err = writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":  {mime},
+				"Content-Range": {r.contentRange(size)},
+			}); err != nil {
+				break
+			}
+			if err = s.readChunks(part, m, r.start, r.length); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			writer.Close()
+		}
+	}
+	if err != nil {
+		glog.Warningf("Stream chunks error(%v)\n", err)
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+	"vxfs/dao/store"
+)
+
+func TestChunkReads(t *testing.T) {
+	m := &Manifest{Size: 25, Chunks: []Chunk{{1, 11, 10}, {2, 12, 10}, {1, 13, 5}}}
+	whole := func(sid int32, key int64) chunkRead {
+		return chunkRead{sid: sid, sreq: store.ReadRequest{Key: key}}
+	}
+	part := func(sid int32, key int64, offset int64, length int64) chunkRead {
+		return chunkRead{sid: sid, sreq: store.ReadRequest{Key: key, Offset: offset, Length: length}}
+	}
+	tests := []struct {
+		start  int64
+		length int64
+		reads  []chunkRead
+	}{
+		{0, 25, []chunkRead{whole(1, 11), whole(2, 12), whole(1, 13)}},
+		{0, 10, []chunkRead{whole(1, 11)}},
+		{10, 10, []chunkRead{whole(2, 12)}},
+		{0, 5, []chunkRead{part(1, 11, 0, 5)}},
+		{5, 10, []chunkRead{part(1, 11, 5, 5), part(2, 12, 0, 5)}},
+		{9, 12, []chunkRead{part(1, 11, 9, 1), whole(2, 12), part(1, 13, 0, 1)}},
+		{24, 1, []chunkRead{part(1, 13, 4, 1)}},
+		{20, 100, []chunkRead{whole(1, 13)}},
+		{25, 1, nil},
+		{0, 0, nil},
+	}
+	for _, tt := range tests {
+		if reads := chunkReads(m, tt.start, tt.length); !reflect.DeepEqual(reads, tt.reads) {
+			t.Errorf("chunkReads(%d, %d) = %+v, want %+v", tt.start, tt.length, reads, tt.reads)
+		}
+	}
+}
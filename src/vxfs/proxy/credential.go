@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+
+// the permissions of the prefixes, "r" read, "w" write and "d" delete
+const (
+	PermRead   = 'r'
+	PermWrite  = 'w'
+	PermDelete = 'd'
+	PermAll    = "rwd"
+
+	CredentialRefresh = 5 * time.Second
+)
+
+// the grants of the prefixes were added up
+type Credential struct {
+	Name   string            `json:"name"`
+	Grants map[string]string `json:"grants"`
+}
+
+// the credential file, the anonymous read all without it
+type CredentialFile struct {
+	Anonymous map[string]string      `json:"anonymous"`
+	Keys      map[string]*Credential `json:"keys"`
+}
+
+type CredentialStore struct {
+	lock      sync.RWMutex
+	safeCode  string
+	file      string
+	mtime     time.Time
+	anonymous *Credential
+	keys      map[string]*Credential
+	ticker    *libs.VxTicker
+}
+
+func (c *Credential) Allow(path string, perm byte) bool {
+	for prefix, perms := range c.Grants {
+		if strings.HasPrefix(path, prefix) && strings.IndexByte(perms, perm) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func checkGrants(grants map[string]string) error {
+	for _, perms := range grants {
+		if strings.Trim(perms, PermAll) != "" {
+			return ErrCredentialFormat
+		}
+	}
+	return nil
+}
+
+func LoadCredentialFile(file string) (f *CredentialFile, err error) {
+	var body string
+	if body, err = libs.ReadTextFile(file); err != nil {
+		return
+	}
+	f = &CredentialFile{}
+	if err = json.Unmarshal([]byte(body), f); err != nil {
+		f = nil
+		return
+	}
+	if err = checkGrants(f.Anonymous); err != nil {
+		f = nil
+		return
+	}
+	for key, c := range f.Keys {
+		if len(key) < 1 || c == nil {
+			f = nil
+			err = ErrCredentialFormat
+			return
+		}
+		if err = checkGrants(c.Grants); err != nil {
+			f = nil
+			return
+		}
+	}
+	return
+}
+
+// the safe code was the key of all permissions, the anonymous have all permissions without any key
+func NewCredentialStore(safeCode string) (s *CredentialStore) {
+	s = &CredentialStore{}
+	s.safeCode = safeCode
+	s.ticker = libs.NewVxTicker(s.reload, CredentialRefresh)
+	s.apply(&CredentialFile{})
+	return
+}
+
+func (s *CredentialStore) apply(f *CredentialFile) {
+	keys := make(map[string]*Credential, len(f.Keys)+1)
+	for key, c := range f.Keys {
+		keys[key] = c
+	}
+	if len(s.safeCode) > 0 {
+		keys[s.safeCode] = &Credential{Name: "safe code", Grants: map[string]string{"": PermAll}}
+	}
+	anonymous := &Credential{Name: "anonymous", Grants: f.Anonymous}
+	if anonymous.Grants == nil {
+		anonymous.Grants = map[string]string{"": string(PermRead)}
+		if len(keys) < 1 {
+			anonymous.Grants[""] = PermAll
+		}
+	}
+
+	s.lock.Lock()
+	s.keys = keys
+	s.anonymous = anonymous
+	s.lock.Unlock()
+}
+
+// the file was reloaded when it was modified
+func (s *CredentialStore) Load(file string) (err error) {
+	var (
+		f    *CredentialFile
+		stat os.FileInfo
+	)
+	if stat, err = os.Stat(file); err != nil {
+		return
+	}
+	if f, err = LoadCredentialFile(file); err != nil {
+		return
+	}
+	s.apply(f)
+	s.file = file
+	s.mtime = stat.ModTime()
+	s.ticker.Start()
+	return
+}
+
+func (s *CredentialStore) reload() {
+	var (
+		err  error
+		f    *CredentialFile
+		stat os.FileInfo
+	)
+	if stat, err = os.Stat(s.file); err != nil || stat.ModTime().Equal(s.mtime) {
+		return
+	}
+	if f, err = LoadCredentialFile(s.file); err != nil {
+		glog.Warningf("Credentials(%s) reload error(%v)\n", s.file, err)
+		return
+	}
+	s.apply(f)
+	s.mtime = stat.ModTime()
+	glog.Infof("Credentials(%s) %d keys reloaded\n", s.file, len(f.Keys))
+}
+
+func (s *CredentialStore) Close() {
+	s.ticker.Stop()
+}
+
+// the empty key was the anonymous
+func (s *CredentialStore) Lookup(key string) (c *Credential, ok bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(key) < 1 {
+		return s.anonymous, true
+	}
+	c, ok = s.keys[key]
+	return
+}
+
+func (s *CredentialStore) Secured() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.keys) > 0
+}
+
+// the only check of the rest, webdav, s3 and bucket requests, the bucket safe code has all permissions in the bucket,
+// the anonymous can't access the system names, the names require the signature, and write the buckets of safe code
+func (s *ProxyServer) authorize(key string, path string, perm byte, bucket *name.Bucket, system bool) (err error) {
+	if bucket != nil && !system && len(bucket.SafeCode) > 0 && key == bucket.SafeCode {
+		return
+	}
+	c, ok := s.credentials.Lookup(key)
+	if !ok {
+		err = ErrAccessDenied
+		return
+	}
+	if len(key) < 1 {
+		if (system && s.credentials.Secured()) || (perm == PermRead && s.signRequired(path)) ||
+			(perm != PermRead && bucket != nil && len(bucket.SafeCode) > 0) {
+			err = ErrAccessDenied
+			return
+		}
+	}
+	if !c.Allow(path, perm) {
+		err = ErrAccessDenied
+		return
+	}
+	return
+}
@@ -6,16 +6,84 @@ import (
 )
 
 var (
-	ErrHttpPathFormat = errors.New("http bad path format")
-	ErrHttpUploadBody = errors.New("http bad body in upload")
+	ErrHttpPathFormat  = errors.New("http bad path format")
+	ErrHttpUploadBody  = errors.New("http bad body in upload")
+	ErrHttpVersionId   = errors.New("http bad version id")
+	ErrHttpDestination = errors.New("http bad destination")
+	ErrHttpWatchParam  = errors.New("http bad watch parameter")
+	ErrHttpExpires     = errors.New("http bad expires")
+	ErrHttpListParam   = errors.New("http bad list parameter")
+	ErrHttpTrashId     = errors.New("http bad trash id")
+	ErrHttpBucket      = errors.New("http bad bucket")
+	ErrHttpTags        = errors.New("http bad tags")
+	ErrHttpRange       = errors.New("http bad range")
+	ErrHttpUploadPart  = errors.New("http bad upload part")
+	ErrHttpUploadId    = errors.New("http bad upload id")
+	ErrHttpDavBody     = errors.New("http bad dav body")
+	ErrHttpChecksum    = errors.New("http bad checksum")
+	ErrHttpMetaSize    = errors.New("http bad meta size")
+	ErrHttpThumbParam  = errors.New("http bad thumbnail parameter")
+
+	ErrBucketNotExists  = errors.New("bucket not exists")
+	ErrBucketNotEmpty   = errors.New("bucket not empty")
+	ErrBucketMaxSize    = errors.New("bucket max size exceeded")
+	ErrBucketQuota      = errors.New("bucket quota exceeded")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrUrlSignature     = errors.New("url signature invalid")
+	ErrUrlExpired       = errors.New("url signature expired")
+	ErrAccessDenied     = errors.New("access denied")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrThumbFormat      = errors.New("thumbnail format unsupported")
+	ErrThumbTooLarge    = errors.New("thumbnail source too large")
+	ErrDavLocked        = errors.New("dav resource locked")
+	ErrDavLockToken     = errors.New("dav lock token not exists")
 
 	ErrInvalidatePrameter  = errors.New("invalidate parameter")
+	ErrShardMapFormat      = errors.New("shard map bad format")
+	ErrKeyringFormat       = errors.New("keyring bad format")
+	ErrCredentialFormat    = errors.New("credential bad format")
+	ErrS3KeysRequired      = errors.New("s3 keys required")
 	ErrNameServiceNoLive   = errors.New("name service no living")
 	ErrNameServiceNoSpace  = errors.New("name service no space")
 	ErrStoreServiceNoLive  = errors.New("store service no living")
 	ErrStoreServiceNoSpace = errors.New("store service no space")
 )
 
+// the error code and http status of the s3 api
+type S3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *S3Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrS3AccessDenied     = &S3Error{403, "AccessDenied", "Access Denied"}
+	ErrS3AccessKey        = &S3Error{403, "InvalidAccessKeyId", "The access key Id you provided does not exist in our records."}
+	ErrS3Signature        = &S3Error{403, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	ErrS3TimeSkewed       = &S3Error{403, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	ErrS3AuthHeader       = &S3Error{400, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	ErrS3AuthQuery        = &S3Error{400, "AuthorizationQueryParametersError", "The authorization query parameters are malformed."}
+	ErrS3Expired          = &S3Error{403, "AccessDenied", "Request has expired"}
+	ErrS3ContentSHA256    = &S3Error{400, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	ErrS3IncompleteBody   = &S3Error{400, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	ErrS3InvalidRequest   = &S3Error{400, "InvalidRequest", "Invalid Request"}
+	ErrS3InvalidArgument  = &S3Error{400, "InvalidArgument", "Invalid Argument"}
+	ErrS3InvalidBucket    = &S3Error{400, "InvalidBucketName", "The specified bucket is not valid."}
+	ErrS3MalformedXML     = &S3Error{400, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."}
+	ErrS3InvalidPart      = &S3Error{400, "InvalidPart", "One or more of the specified parts could not be found."}
+	ErrS3InvalidPartOrder = &S3Error{400, "InvalidPartOrder", "The list of parts was not in ascending order."}
+	ErrS3NoSuchUpload     = &S3Error{404, "NoSuchUpload", "The specified multipart upload does not exist."}
+	ErrS3NoSuchKey        = &S3Error{404, "NoSuchKey", "The specified key does not exist."}
+	ErrS3NoSuchBucket     = &S3Error{404, "NoSuchBucket", "The specified bucket does not exist."}
+	ErrS3NotImplemented   = &S3Error{501, "NotImplemented", "A header you provided implies functionality that is not implemented."}
+	ErrS3MethodNotAllowed = &S3Error{405, "MethodNotAllowed", "The specified method is not allowed against this resource."}
+	ErrS3InternalError    = &S3Error{500, "InternalError", "We encountered an internal error. Please try again."}
+)
+
 func isHttpBadRequest(err error) bool {
 	return strings.HasPrefix(err.Error(), "http bad")
 }
@@ -1,9 +1,15 @@
 package proxy
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strconv"
+	"strings"
+	"time"
 	"vxfs/dao/name"
 	"vxfs/dao/store"
 	"vxfs/libs"
@@ -16,6 +22,28 @@ func errorToHttpStatus(err error) int {
 		return 404
 	} else if libs.IsErrorSame(err, store.ErrStoreNotExists) {
 		return 404
+	} else if err == ErrBucketNotExists {
+		return 404
+	} else if err == ErrBucketNotEmpty {
+		return 409
+	} else if err == ErrBucketMaxSize {
+		return 413
+	} else if err == ErrBucketQuota {
+		return 403
+	} else if err == ErrQuotaExceeded {
+		return 403
+	} else if err == ErrAccessDenied {
+		return 403
+	} else if err == ErrChecksumMismatch {
+		return 400
+	} else if err == ErrThumbFormat {
+		return 415
+	} else if err == ErrThumbTooLarge {
+		return 413
+	} else if libs.IsErrorSame(err, name.ErrNameInvalid) {
+		return 400
+	} else if libs.IsErrorSame(err, name.ErrNameTooLong) {
+		return 414
 	}
 	return 500
 }
@@ -31,6 +59,28 @@ func errorToErrorCode(err error) int {
 		return 104
 	} else if libs.IsErrorSame(err, store.ErrStoreExists) {
 		return 105
+	} else if err == ErrBucketNotExists {
+		return 106
+	} else if err == ErrBucketNotEmpty {
+		return 107
+	} else if err == ErrBucketMaxSize {
+		return 108
+	} else if err == ErrBucketQuota {
+		return 109
+	} else if err == ErrQuotaExceeded {
+		return 110
+	} else if libs.IsErrorSame(err, name.ErrNameInvalid) {
+		return 111
+	} else if libs.IsErrorSame(err, name.ErrNameTooLong) {
+		return 112
+	} else if err == ErrAccessDenied {
+		return 113
+	} else if err == ErrChecksumMismatch {
+		return 114
+	} else if err == ErrThumbFormat {
+		return 115
+	} else if err == ErrThumbTooLarge {
+		return 116
 	}
 	return 100
 }
@@ -58,6 +108,10 @@ func httpSendByteData(res http.ResponseWriter, err *error, mime *string, data *[
 
 func httpSendJsonData(res http.ResponseWriter, err *error, data map[string]interface{}) {
 	res.Header().Set("Content-Type", "application/json;charset=utf-8")
+	// the denied, corrupted and oversized requests got the status, the other errors were only in the code
+	if *err == ErrAccessDenied || *err == ErrChecksumMismatch || *err == ErrHttpMetaSize {
+		res.WriteHeader(errorToHttpStatus(*err))
+	}
 	result := map[string]interface{}{}
 	if *err == nil {
 		result["code"] = 0
@@ -68,3 +122,150 @@ func httpSendJsonData(res http.ResponseWriter, err *error, data map[string]inter
 	}
 	json.NewEncoder(res).Encode(result)
 }
+
+func httpSetFileHeader(res http.ResponseWriter, etag string, mtime int64) {
+	header := res.Header()
+	header.Set("ETag", etag)
+	if mtime > 0 {
+		header.Set("Last-Modified", time.Unix(mtime, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+func httpEtagMatch(value string, etag string) bool {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		v = strings.TrimPrefix(v, "W/")
+		if strings.Trim(v, "\"") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func httpNotModified(req *http.Request, etag string, mtime int64) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+	if value := req.Header.Get("If-None-Match"); len(value) > 0 {
+		return httpEtagMatch(value, etag)
+	}
+	if value := req.Header.Get("If-Modified-Since"); len(value) > 0 && mtime > 0 {
+		if t, err := http.ParseTime(value); err == nil && mtime <= t.Unix() {
+			return true
+		}
+	}
+	return false
+}
+
+// If-Match, or If-Unmodified-Since without it
+func httpPreconditionFailed(req *http.Request, etag string, mtime int64) bool {
+	if value := req.Header.Get("If-Match"); len(value) > 0 {
+		return !httpEtagMatch(value, etag)
+	}
+	if value := req.Header.Get("If-Unmodified-Since"); len(value) > 0 && mtime > 0 {
+		if t, err := http.ParseTime(value); err == nil && mtime > t.Unix() {
+			return true
+		}
+	}
+	return false
+}
+
+// the Range was ignored if the If-Range not match
+func httpIfRange(req *http.Request, etag string, mtime int64) bool {
+	value := req.Header.Get("If-Range")
+	if len(value) < 1 {
+		return true
+	}
+	if strings.HasPrefix(value, "\"") {
+		return strings.Trim(value, "\"") == etag
+	}
+	if t, err := http.ParseTime(value); err == nil && mtime == t.Unix() {
+		return true
+	}
+	return false
+}
+
+type httpRange struct {
+	start  int64
+	length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// no ranges for a bad or too many ranges, the whole data was sent then
+func httpParseRange(value string, size int64) (ranges []httpRange, err error) {
+	if !strings.HasPrefix(value, "bytes=") {
+		return
+	}
+	specs := strings.Split(value[6:], ",")
+	if len(specs) > MaxHttpRanges {
+		return
+	}
+	for _, spec := range specs {
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, nil
+		}
+		var (
+			r     httpRange
+			first = strings.TrimSpace(spec[:i])
+			last  = strings.TrimSpace(spec[i+1:])
+		)
+		if len(first) < 1 {
+			// the last bytes
+			n, err1 := strconv.ParseInt(last, 10, 64)
+			if err1 != nil || n < 0 {
+				return nil, nil
+			}
+			if n > size {
+				n = size
+			}
+			r = httpRange{size - n, n}
+		} else {
+			start, err1 := strconv.ParseInt(first, 10, 64)
+			if err1 != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if len(last) > 0 {
+				n, err1 := strconv.ParseInt(last, 10, 64)
+				if err1 != nil || n < start {
+					return nil, nil
+				}
+				if n < end {
+					end = n
+				}
+			}
+			r = httpRange{start, end - start + 1}
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) < 1 {
+		err = ErrHttpRange
+	}
+	return
+}
+
+// the multipart/byteranges body of the parts
+func httpRangeBody(ranges []httpRange, parts [][]byte, mime string, size int64) (contentType string, body []byte) {
+	var (
+		buffer bytes.Buffer
+		writer = multipart.NewWriter(&buffer)
+	)
+	for i, r := range ranges {
+		part, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {mime},
+			"Content-Range": {r.contentRange(size)},
+		})
+		part.Write(parts[i])
+	}
+	writer.Close()
+	return "multipart/byteranges; boundary=" + writer.Boundary(), buffer.Bytes()
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHttpParseRange(t *testing.T) {
+	tests := []struct {
+		value  string
+		size   int64
+		ranges []httpRange
+		err    error
+	}{
+		{"bytes=0-9", 100, []httpRange{{0, 10}}, nil},
+		{"bytes=90-", 100, []httpRange{{90, 10}}, nil},
+		{"bytes=90-200", 100, []httpRange{{90, 10}}, nil},
+		{"bytes=-10", 100, []httpRange{{90, 10}}, nil},
+		{"bytes=-200", 100, []httpRange{{0, 100}}, nil},
+		{"bytes=0-0, -1", 100, []httpRange{{0, 1}, {99, 1}}, nil},
+		{"bytes=0-9,200-300", 100, []httpRange{{0, 10}}, nil},
+		{"bytes=100-", 100, nil, ErrHttpRange},
+		{"bytes=-0", 100, nil, ErrHttpRange},
+		{"bytes=0-", 0, nil, ErrHttpRange},
+		{"bytes=9-0", 100, nil, nil},
+		{"bytes=a-9", 100, nil, nil},
+		{"bytes=10", 100, nil, nil},
+		{"items=0-9", 100, nil, nil},
+		{"", 100, nil, nil},
+		{"bytes=" + strings.Repeat("0-0,", MaxHttpRanges) + "0-0", 100, nil, nil},
+	}
+	for _, tt := range tests {
+		ranges, err := httpParseRange(tt.value, tt.size)
+		if err != tt.err || !reflect.DeepEqual(ranges, tt.ranges) {
+			t.Errorf("httpParseRange(%q, %d) = %v, %v, want %v, %v", tt.value, tt.size, ranges, err, tt.ranges, tt.err)
+		}
+	}
+}
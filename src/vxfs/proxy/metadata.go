@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+)
+
+// the user metadata headers were kept in the name record with the standard headers for the browsers
+const (
+	MetaHeaderPrefix = "X-Vxfs-Meta-"
+)
+
+var storedHeaders = []string{"Content-Disposition", "Cache-Control", "Content-Encoding", "Content-Language"}
+
+// the header names were canonical, the repeated values were joined
+func parseMetaHeaders(req *http.Request) (headers map[string]string) {
+	for key, values := range req.Header {
+		if !strings.HasPrefix(key, MetaHeaderPrefix) || len(key) == len(MetaHeaderPrefix) {
+			continue
+		}
+		if value := strings.Join(values, ", "); len(value) > 0 {
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers[key] = value
+		}
+	}
+	for _, key := range storedHeaders {
+		if value := req.Header.Get(key); len(value) > 0 {
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers[key] = value
+		}
+	}
+	return
+}
+
+func setMetaHeader(header http.Header, meta *FileMeta) {
+	for key, value := range meta.Headers {
+		header.Set(key, value)
+	}
+}
+
+// the name meta was checked before the writes, the times were set by the name server
+func checkNameMeta(meta *name.NameMeta) (err error) {
+	var data []byte
+	m := *meta
+	if m.Ctime == 0 {
+		m.Ctime = time.Now().Unix()
+	}
+	if m.Mtime == 0 {
+		m.Mtime = m.Ctime
+	}
+	if data, err = json.Marshal(&m); err != nil {
+		return
+	}
+	if len(data) > name.MaxNameLength {
+		err = ErrHttpMetaSize
+	}
+	return
+}
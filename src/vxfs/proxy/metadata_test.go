@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"vxfs/dao/name"
+)
+
+func TestParseMetaHeaders(t *testing.T) {
+	tests := []struct {
+		headers http.Header
+		want    map[string]string
+	}{
+		{http.Header{}, nil},
+		{http.Header{"X-Vxfs-Meta-Owner": {"team-a"}}, map[string]string{"X-Vxfs-Meta-Owner": "team-a"}},
+		{http.Header{"X-Vxfs-Meta-Owner": {"team-a", "team-b"}}, map[string]string{"X-Vxfs-Meta-Owner": "team-a, team-b"}},
+		{http.Header{"X-Vxfs-Meta-": {"empty"}, "X-Vxfs-Meta-Owner": {""}}, nil},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Content-Type": {"text/plain"}}, map[string]string{"Cache-Control": "max-age=60"}},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("PUT", "http://vxfs/a.txt", nil)
+		req.Header = tt.headers
+		if headers := parseMetaHeaders(req); !reflect.DeepEqual(headers, tt.want) {
+			t.Errorf("parseMetaHeaders(%v) = %v, want %v", tt.headers, headers, tt.want)
+		}
+	}
+}
+
+func TestCheckNameMeta(t *testing.T) {
+	large := strings.Repeat("a", name.MaxNameLength/2)
+	tests := []struct {
+		meta name.NameMeta
+		err  error
+	}{
+		{name.NameMeta{Size: 1, Mime: "text/plain"}, nil},
+		{name.NameMeta{Headers: map[string]string{"X-Vxfs-Meta-A": large}}, nil},
+		{name.NameMeta{Headers: map[string]string{"X-Vxfs-Meta-A": large, "X-Vxfs-Meta-B": large}}, ErrHttpMetaSize},
+		{name.NameMeta{Headers: map[string]string{"X-Vxfs-Meta-A": large}, Tags: map[string]string{"a": large}}, ErrHttpMetaSize},
+		{name.NameMeta{Mime: strings.Repeat("a", name.MaxNameLength)}, ErrHttpMetaSize},
+	}
+	for i, tt := range tests {
+		if err := checkNameMeta(&tt.meta); err != tt.err {
+			t.Errorf("%d: checkNameMeta error(%v), want (%v)", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/dao/store"
+	"vxfs/libs"
+)
+
+// upload record: .upload/<upload id>/<name>, part: .part/<upload id>/<part number>
+const (
+	UploadPrefix        = ".upload/"
+	PartPrefix          = ".part/"
+	MaxPartNumber       = 10000
+	MaxCompleteBody     = 1024 * 1024
+	DefaultUploadExpire = 7 * 24 * 3600
+)
+
+type uploadPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+type uploadComplete struct {
+	Parts []uploadPart `json:"parts"`
+}
+
+func isUploadRecord(path string) bool {
+	return strings.HasPrefix(path, UploadPrefix) || strings.HasPrefix(path, PartPrefix)
+}
+
+func toUploadName(id int64, path string) string {
+	return fmt.Sprintf("%s%d/%s", UploadPrefix, id, path)
+}
+
+func toPartName(id int64, number int) string {
+	return fmt.Sprintf("%s%d/%05d", PartPrefix, id, number)
+}
+
+func partNumberOf(part string) (number int) {
+	if i := strings.LastIndex(part, "/"); i >= 0 {
+		number, _ = strconv.Atoi(part[i+1:])
+	}
+	return
+}
+
+// the unfinished uploads expire after the seconds, and were reaped with their parts, 0 for never
+func (s *ProxyServer) SetUploadExpire(expire int64) {
+	s.uploadExpire = expire
+}
+
+// the mime and tags of the upload were kept in the record
+func (s *ProxyServer) createUpload(path string, meta name.NameMeta) (id int64, err error) {
+	if id, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	meta.Size = 0
+	meta.Expires = 0
+	if s.uploadExpire > 0 {
+		meta.Expires = time.Now().Unix() + s.uploadExpire
+	}
+	err = s.serviceManager.WriteName(&name.WriteRequest{Name: toUploadName(id, path), Meta: meta}, &name.WriteResponse{})
+	return
+}
+
+func (s *ProxyServer) readUpload(id int64, path string) (nres *name.ReadResponse, err error) {
+	nres = &name.ReadResponse{}
+	err = s.serviceManager.ReadName(&name.ReadRequest{Name: toUploadName(id, path)}, nres)
+	return
+}
+
+// the parts after the marker in order
+func (s *ProxyServer) listParts(id int64, marker int, limit int) (parts []name.NameEntry, more bool, err error) {
+	nlreq := &name.ListRequest{Prefix: fmt.Sprintf("%s%d/", PartPrefix, id)}
+	if marker > 0 {
+		nlreq.Marker = toPartName(id, marker)
+	}
+	for {
+		nlres := &name.ListResponse{}
+		if err = s.serviceManager.ListName(nlreq, nlres, false); err != nil {
+			return
+		}
+		for _, v := range nlres.Entries {
+			if limit > 0 && len(parts) >= limit {
+				more = true
+				return
+			}
+			parts = append(parts, v)
+		}
+		if !nlres.More || len(nlres.Entries) < 1 {
+			return
+		}
+		nlreq.Marker = nlres.Entries[len(nlres.Entries)-1].Name
+	}
+}
+
+// the part was written by chunks with a manifest, replace the part of the same number, it expire with the upload
+func (s *ProxyServer) writePart(req *http.Request, id int64, path string, number int) (etag string, err error) {
+	var (
+		m    *Manifest
+		meta = &FileMeta{Manifest: true}
+		nres *name.ReadResponse
+
+		nwreq = &name.WriteRequest{Name: toPartName(id, number)}
+		swreq = &store.WriteRequest{}
+	)
+	if nres, err = s.readUpload(id, path); err != nil {
+		return
+	}
+	bucket, _ := s.getBucket(path)
+	var maxSize int64
+	if bucket != nil {
+		maxSize = bucket.MaxSize
+	}
+	if m, err = s.writeChunks(req, maxSize, bucketSids(bucket), meta); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.deleteChunks(m)
+		}
+	}()
+	if err = verifyChecksums(req, meta); err != nil {
+		return
+	}
+	if swreq.Data, err = json.Marshal(m); err != nil {
+		return
+	}
+	if swreq.Meta, err = json.Marshal(meta); err != nil {
+		return
+	}
+	if swreq.Key, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	if nwreq.Sid, err = s.serviceManager.GetSid(int64(len(swreq.Data)), bucketSids(bucket)); err != nil {
+		return
+	}
+	if err = s.serviceManager.WriteStore(nwreq.Sid, swreq, &store.WriteResponse{}); err != nil {
+		return
+	}
+
+	nwreq.Key = swreq.Key
+	nwreq.Meta.Size = m.Size
+	nwreq.Meta.ETag = meta.ETag
+	nwreq.Meta.Chunked = true
+	nwreq.Meta.Expires = nres.Meta.Expires
+	if err = s.deleteName(&name.DeleteRequest{Name: nwreq.Name}); err != nil && !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		s.serviceManager.DeleteStore(nwreq.Sid, &store.DeleteRequest{Key: nwreq.Key}, &store.DeleteResponse{})
+		return
+	}
+	if err = s.serviceManager.WriteName(nwreq, &name.WriteResponse{}); err != nil {
+		s.serviceManager.DeleteStore(nwreq.Sid, &store.DeleteRequest{Key: nwreq.Key}, &store.DeleteResponse{})
+		return
+	}
+	etag = meta.ETag
+	return
+}
+
+// the etag of the parts was the sha1 of their etags with the count of parts
+func multipartETag(etags []string) string {
+	hash := sha1.New()
+	for _, etag := range etags {
+		data, _ := hex.DecodeString(etag)
+		hash.Write(data)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(etags))
+}
+
+// join the chunks of the parts in order into the file, the parts not listed were deleted
+func (s *ProxyServer) completeUpload(id int64, path string, numbers []int, etags []string, replace bool) (nwreq *name.WriteRequest, err error) {
+	var (
+		nres  *name.ReadResponse
+		parts []name.NameEntry
+		m     = &Manifest{}
+		used  = make(map[int]bool)
+		swreq = &store.WriteRequest{}
+
+		reserve *quotaReserve
+	)
+	if nres, err = s.readUpload(id, path); err != nil {
+		return
+	}
+	if parts, _, err = s.listParts(id, 0, 0); err != nil {
+		return
+	}
+	entries := make(map[int]name.NameEntry, len(parts))
+	for _, v := range parts {
+		entries[partNumberOf(v.Name)] = v
+	}
+	for i, number := range numbers {
+		v, ok := entries[number]
+		if !ok || v.Meta.ETag != etags[i] {
+			err = ErrHttpUploadPart
+			return
+		}
+		var pm *Manifest
+		if pm, err = s.readManifest(v.Sid, v.Key); err != nil {
+			return
+		}
+		m.Chunks = append(m.Chunks, pm.Chunks...)
+		m.Size += pm.Size
+		used[number] = true
+	}
+
+	bucket, usage := s.getBucket(path)
+	if bucket != nil {
+		if err = checkBucket(bucket, usage, m.Size); err != nil {
+			return
+		}
+	}
+	if reserve, err = s.serviceManager.ReserveQuota(path, m.Size); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.serviceManager.ReleaseQuota(reserve)
+		}
+	}()
+
+	nwreq = &name.WriteRequest{Name: path}
+	nwreq.Meta.Size = m.Size
+	nwreq.Meta.Mime = nres.Meta.Mime
+	nwreq.Meta.Tags = nres.Meta.Tags
+	nwreq.Meta.ETag = multipartETag(etags)
+	nwreq.Meta.Chunked = true
+	if bucket != nil && bucket.TTL > 0 {
+		nwreq.Meta.Expires = time.Now().Unix() + bucket.TTL
+	}
+	if len(nwreq.Meta.Mime) < 1 {
+		nwreq.Meta.Mime = "application/octet-stream"
+	}
+	if err = checkNameMeta(&nwreq.Meta); err != nil {
+		return
+	}
+	if swreq.Data, err = json.Marshal(m); err != nil {
+		return
+	}
+	if swreq.Meta, err = json.Marshal(&FileMeta{Mime: nwreq.Meta.Mime, ETag: nwreq.Meta.ETag, Manifest: true}); err != nil {
+		return
+	}
+	if nwreq.Key, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	if s.isVersioned(path) {
+		nwreq.Versioned = true
+		nwreq.Meta.Version = nwreq.Key
+	}
+	if nwreq.Sid, err = s.serviceManager.GetSid(int64(len(swreq.Data)), bucketSids(bucket)); err != nil {
+		return
+	}
+	swreq.Key = nwreq.Key
+	if err = s.serviceManager.WriteStore(nwreq.Sid, swreq, &store.WriteResponse{}); err != nil {
+		return
+	}
+	if replace {
+		err = s.replaceName(nwreq, &name.WriteResponse{})
+	} else {
+		err = s.writeName(nwreq, &name.WriteResponse{})
+	}
+	if err != nil {
+		s.serviceManager.DeleteStore(nwreq.Sid, &store.DeleteRequest{Key: nwreq.Key}, &store.DeleteResponse{})
+		return
+	}
+
+	// the chunks of the used parts were referred by the file now
+	for _, v := range parts {
+		if !used[partNumberOf(v.Name)] {
+			s.deleteName(&name.DeleteRequest{Name: v.Name})
+			continue
+		}
+		s.serviceManager.DeleteName(&name.DeleteRequest{Name: v.Name}, &name.DeleteResponse{})
+		s.serviceManager.DeleteStore(v.Sid, &store.DeleteRequest{Key: v.Key}, &store.DeleteResponse{})
+	}
+	s.serviceManager.DeleteName(&name.DeleteRequest{Name: toUploadName(id, path)}, &name.DeleteResponse{})
+	return
+}
+
+// delete the parts with their chunks, and the upload record
+func (s *ProxyServer) abortUpload(id int64, path string) (err error) {
+	var parts []name.NameEntry
+	if parts, _, err = s.listParts(id, 0, 0); err != nil {
+		return
+	}
+	for _, v := range parts {
+		if err = s.deleteName(&name.DeleteRequest{Name: v.Name}); err != nil && !libs.IsErrorSame(err, name.ErrNameNotExists) {
+			return
+		}
+	}
+	err = s.serviceManager.DeleteName(&name.DeleteRequest{Name: toUploadName(id, path)}, &name.DeleteResponse{})
+	return
+}
+
+func (s *ProxyServer) parseUploadId(req *http.Request) (id int64, err error) {
+	if id, err = strconv.ParseInt(req.URL.Query().Get("uploadId"), 10, 64); err != nil || id <= 0 {
+		err = ErrHttpUploadId
+		return
+	}
+	return
+}
+
+func (s *ProxyServer) handleCreateUpload(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		path  string
+		id    int64
+		meta  = name.NameMeta{Mime: req.Header.Get("Content-Type")}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if isReservedName(path) {
+		err = ErrHttpPathFormat
+		return
+	}
+	if value := req.Header.Get("X-VXFS-Tags"); len(value) > 0 {
+		if meta.Tags, err = parseTags(value); err != nil {
+			return
+		}
+	}
+	if err = checkNameMeta(&meta); err != nil {
+		return
+	}
+	if id, err = s.createUpload(path, meta); err != nil {
+		return
+	}
+	xdata["upload_id"] = strconv.FormatInt(id, 10)
+	if s.uploadExpire > 0 {
+		xdata["expires"] = time.Now().Unix() + s.uploadExpire
+	}
+}
+
+func (s *ProxyServer) handleUploadPart(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		xdata  = map[string]interface{}{}
+		path   string
+		id     int64
+		number int
+		etag   string
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if id, err = s.parseUploadId(req); err != nil {
+		return
+	}
+	if number, err = strconv.Atoi(req.URL.Query().Get("partNumber")); err != nil || number < 1 || number > MaxPartNumber {
+		err = ErrHttpUploadPart
+		return
+	}
+	if etag, err = s.writePart(req, id, path, number); err != nil {
+		return
+	}
+	xdata["part_number"] = number
+	xdata["etag"] = etag
+}
+
+func (s *ProxyServer) handleListParts(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		xdata  = map[string]interface{}{}
+		query  = req.URL.Query()
+		path   string
+		id     int64
+		marker int
+		limit  int
+		more   bool
+		parts  []name.NameEntry
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if id, err = s.parseUploadId(req); err != nil {
+		return
+	}
+	if value := query.Get("marker"); len(value) > 0 {
+		if marker, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpListParam
+			return
+		}
+	}
+	if value := query.Get("limit"); len(value) > 0 {
+		if limit, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpListParam
+			return
+		}
+	}
+	if _, err = s.readUpload(id, path); err != nil {
+		return
+	}
+	if parts, more, err = s.listParts(id, marker, limit); err != nil {
+		return
+	}
+
+	list := make([]map[string]interface{}, 0, len(parts))
+	for _, v := range parts {
+		list = append(list, map[string]interface{}{
+			"part_number": partNumberOf(v.Name),
+			"etag":        v.Meta.ETag,
+			"size":        v.Meta.Size,
+			"mtime":       v.Meta.Mtime,
+		})
+	}
+	xdata["parts"] = list
+	if more && len(parts) > 0 {
+		xdata["marker"] = partNumberOf(parts[len(parts)-1].Name)
+	}
+}
+
+// all the parts in order without the part list
+func (s *ProxyServer) handleCompleteUpload(res http.ResponseWriter, req *http.Request) {
+	var (
+		err      error
+		xdata    = map[string]interface{}{}
+		path     string
+		id       int64
+		body     []byte
+		complete = &uploadComplete{}
+		numbers  []int
+		etags    []string
+		nwreq    *name.WriteRequest
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if id, err = s.parseUploadId(req); err != nil {
+		return
+	}
+	if body, err = ioutil.ReadAll(io.LimitReader(req.Body, MaxCompleteBody)); err != nil {
+		err = ErrHttpUploadPart
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err = json.Unmarshal(body, complete); err != nil {
+			err = ErrHttpUploadPart
+			return
+		}
+	} else {
+		var parts []name.NameEntry
+		if parts, _, err = s.listParts(id, 0, 0); err != nil {
+			return
+		}
+		for _, v := range parts {
+			complete.Parts = append(complete.Parts, uploadPart{PartNumber: partNumberOf(v.Name), ETag: v.Meta.ETag})
+		}
+	}
+	if len(complete.Parts) < 1 {
+		err = ErrHttpUploadPart
+		return
+	}
+	for i, v := range complete.Parts {
+		if i > 0 && v.PartNumber <= complete.Parts[i-1].PartNumber {
+			err = ErrHttpUploadPart
+			return
+		}
+		numbers = append(numbers, v.PartNumber)
+		etags = append(etags, v.ETag)
+	}
+	if nwreq, err = s.completeUpload(id, path, numbers, etags, false); err != nil {
+		return
+	}
+	xdata["etag"] = nwreq.Meta.ETag
+	xdata["size"] = nwreq.Meta.Size
+	if nwreq.Versioned {
+		xdata["version_id"] = strconv.FormatInt(nwreq.Meta.Version, 10)
+	}
+}
+
+func (s *ProxyServer) handleAbortUpload(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		path  string
+		id    int64
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if id, err = s.parseUploadId(req); err != nil {
+		return
+	}
+	if _, err = s.readUpload(id, path); err != nil {
+		return
+	}
+	err = s.abortUpload(id, path)
+}
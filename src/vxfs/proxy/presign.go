@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/libs"
+)
+
+// the presigned url: ?keyId=&expires=&signature=, the hmac-sha256 of "<method>\n<path>\n<expires>",
+// the path of the virtual host bucket was signed with the bucket, "/<bucket>/<name>"
+const (
+	DefaultSignTTL = 3600
+)
+
+// the keyring file was a json object of the key id and secret, {"key1": "secret1"}
+func LoadKeyring(file string) (keys map[string]string, err error) {
+	var body string
+	if body, err = libs.ReadTextFile(file); err != nil {
+		return
+	}
+	if err = json.Unmarshal([]byte(body), &keys); err != nil {
+		keys = nil
+		return
+	}
+	for id, secret := range keys {
+		if len(id) < 1 || len(secret) < 1 {
+			keys = nil
+			err = ErrKeyringFormat
+			return
+		}
+	}
+	return
+}
+
+// the HEAD was signed as the GET
+func signUrl(method string, path string, expires int64, secret string) string {
+	if method == "HEAD" {
+		method = "GET"
+	}
+	return hex.EncodeToString(hmacSHA256([]byte(secret), method+"\n"+path+"\n"+strconv.FormatInt(expires, 10)))
+}
+
+// the url with the signature of the method, expire at the unix time, the bucket host was the domain of the virtual host buckets
+func PresignUrl(rawurl string, method string, id string, secret string, expires int64, bucketHost string) (signed string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(rawurl); err != nil {
+		return
+	}
+	if len(u.Path) < 1 {
+		u.Path = "/"
+	}
+	path := u.Path
+	if bucket := hostBucket(u.Host, bucketHost); len(bucket) > 0 {
+		path = "/" + bucket + path
+	}
+	query := u.Query()
+	query.Set("keyId", id)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signUrl(strings.ToUpper(method), path, expires, secret))
+	u.RawQuery = query.Encode()
+	signed = u.String()
+	return
+}
+
+// the keys sign the urls, the GET of the names under the prefixes require the signature
+func (s *ProxyServer) SetPresign(keys map[string]string, prefixes []string) {
+	s.signKeys = keys
+	s.signPrefixes = prefixes
+}
+
+func (s *ProxyServer) signRequired(path string) bool {
+	for _, prefix := range s.signPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// the request without signature was not signed, the bad one was an error, the path was routed to the bucket
+func (s *ProxyServer) verifySignature(req *http.Request) (signed bool, err error) {
+	var (
+		expires int64
+		query   = req.URL.Query()
+	)
+	if _, ok := query["signature"]; !ok {
+		return
+	}
+	secret, ok := s.signKeys[query.Get("keyId")]
+	if !ok {
+		err = ErrUrlSignature
+		return
+	}
+	if expires, err = strconv.ParseInt(query.Get("expires"), 10, 64); err != nil {
+		err = ErrUrlSignature
+		return
+	}
+	if expires < time.Now().Unix() {
+		err = ErrUrlExpired
+		return
+	}
+	if !hmac.Equal([]byte(signUrl(req.Method, req.URL.Path, expires, secret)), []byte(strings.ToLower(query.Get("signature")))) {
+		err = ErrUrlSignature
+		return
+	}
+	signed = true
+	return
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	s := &ProxyServer{signKeys: map[string]string{"key1": "secret1"}}
+	expires := time.Now().Unix() + DefaultSignTTL
+	tests := []struct {
+		name    string
+		url     string
+		method  string
+		secret  string
+		expires int64
+		host    string
+		routed  string
+		mutate  func(query url.Values)
+		signed  bool
+		err     error
+	}{
+		{name: "signed", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: expires, signed: true},
+		{name: "head as get", url: "http://vxfs/photos/a.jpg", method: "HEAD", secret: "secret1", expires: expires, signed: true},
+		{name: "expired", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: time.Now().Unix() - 1, err: ErrUrlExpired},
+		{name: "bad secret", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret2", expires: expires, err: ErrUrlSignature},
+		{name: "unknown key", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			mutate: func(query url.Values) { query.Set("keyId", "key2") }, err: ErrUrlSignature},
+		{name: "changed expires", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			mutate: func(query url.Values) { query.Set("expires", "9999999999") }, err: ErrUrlSignature},
+		{name: "bad expires", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			mutate: func(query url.Values) { query.Set("expires", "never") }, err: ErrUrlSignature},
+		{name: "not signed", url: "http://vxfs/photos/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			mutate: func(query url.Values) { query.Del("signature") }},
+		{name: "virtual host", url: "http://photos.vxfs.io/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			host: "vxfs.io", routed: "/photos/a.jpg", signed: true},
+		{name: "virtual host unrouted", url: "http://photos.vxfs.io/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			host: "vxfs.io", err: ErrUrlSignature},
+		{name: "other bucket", url: "http://photos.vxfs.io/a.jpg", method: "GET", secret: "secret1", expires: expires,
+			host: "vxfs.io", routed: "/videos/a.jpg", err: ErrUrlSignature},
+	}
+	for _, tt := range tests {
+		rawurl, err := PresignUrl(tt.url, "GET", "key1", tt.secret, tt.expires, tt.host)
+		if err != nil {
+			t.Errorf("%s: presign error(%v)", tt.name, err)
+			continue
+		}
+		req, _ := http.NewRequest(tt.method, rawurl, nil)
+		if tt.mutate != nil {
+			query := req.URL.Query()
+			tt.mutate(query)
+			req.URL.RawQuery = query.Encode()
+		}
+		if len(tt.routed) > 0 {
+			req.URL.Path = tt.routed
+		}
+		signed, err := s.verifySignature(req)
+		if signed != tt.signed || err != tt.err {
+			t.Errorf("%s: verifySignature = %v, %v, want %v, %v", tt.name, signed, err, tt.signed, tt.err)
+		}
+	}
+}
+
+func TestHostBucket(t *testing.T) {
+	tests := []struct {
+		host   string
+		domain string
+		bucket string
+	}{
+		{"photos.vxfs.io", "vxfs.io", "photos"},
+		{"photos.vxfs.io:1750", "vxfs.io", "photos"},
+		{"photos.vxfs.io", ".vxfs.io", "photos"},
+		{"vxfs.io", "vxfs.io", ""},
+		{"photos.other.io", "vxfs.io", ""},
+		{"photos.vxfs.io", "", ""},
+	}
+	for _, tt := range tests {
+		if bucket := hostBucket(tt.host, tt.domain); bucket != tt.bucket {
+			t.Errorf("hostBucket(%q, %q) = %q, want %q", tt.host, tt.domain, bucket, tt.bucket)
+		}
+	}
+}
@@ -2,18 +2,38 @@ package proxy
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 	"vxfs/dao/name"
 	"vxfs/dao/store"
 	"vxfs/libs"
 )
 
+const (
+	DefaultNameLength = 1024
+	MaxHttpRanges     = 16
+	MaxDeleteBatch    = 1000
+
+	prefixBoundary = "\xff"
+)
+
 type FileMeta struct {
-	Mime string `json:"mime"`
-	ETag string `json:"etag"`
+	Mime     string `json:"mime"`
+	ETag     string `json:"etag"`
+	Manifest bool   `json:"manifest,omitempty"`
+
+	// the hex checksums of the whole file
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// the user metadata and the standard headers, replayed on the reads
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type ProxyServer struct {
@@ -21,20 +41,38 @@ type ProxyServer struct {
 	server   *http.Server
 	listener net.Listener
 
-	safeCode       string
+	credentials    *CredentialStore
 	noDigMime      bool
 	keyMaker       *libs.SnowFlake
 	serviceManager *ServiceManager
+
+	versionPrefixes []string
+	trashRetention  int64
+	bucketMode      bool
+	bucketHost      string
+	namePolicy      *name.NamePolicy
+	chunkSize       int64
+	uploadExpire    int64
+	signKeys        map[string]string
+	signPrefixes    []string
+	thumbPixels     int64
+	thumbSizes      map[string]bool
+	thumbSlots      chan struct{}
+	davLocks        *davLockStore
 }
 
 type HttpHandler func(http.ResponseWriter, *http.Request)
 
-func NewProxyServer(address string, safeCode string, noDigMime bool, keyMaker *libs.SnowFlake, serviceManager *ServiceManager) (s *ProxyServer, err error) {
+func NewProxyServer(address string, credentials *CredentialStore, noDigMime bool, keyMaker *libs.SnowFlake, serviceManager *ServiceManager) (s *ProxyServer, err error) {
 	s = &ProxyServer{}
-	s.safeCode = safeCode
+	s.credentials = credentials
 	s.noDigMime = noDigMime
 	s.keyMaker = keyMaker
 	s.serviceManager = serviceManager
+	s.namePolicy = &name.NamePolicy{MaxLength: DefaultNameLength}
+	s.chunkSize = DefaultChunkSize
+	s.uploadExpire = DefaultUploadExpire
+	s.davLocks = newDavLockStore()
 
 	if s.listener, err = net.Listen("tcp", address); err != nil {
 		s.Close()
@@ -47,9 +85,27 @@ func NewProxyServer(address string, safeCode string, noDigMime bool, keyMaker *l
 		Handler: serveMux,
 	}
 	serveMux.HandleFunc("/", s.route)
+	serveMux.HandleFunc(DavPrefix, s.routeDav)
 	return
 }
 
+func (s *ProxyServer) SetNamePolicy(policy *name.NamePolicy) {
+	s.namePolicy = policy
+}
+
+func (s *ProxyServer) SetVersionPrefixes(prefixes []string) {
+	s.versionPrefixes = prefixes
+}
+
+func (s *ProxyServer) isVersioned(path string) bool {
+	for _, prefix := range s.versionPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *ProxyServer) Serve() error {
 	return s.server.Serve(s.listener)
 }
@@ -66,109 +122,569 @@ func (s *ProxyServer) Close() {
 
 func (s *ProxyServer) route(res http.ResponseWriter, req *http.Request) {
 	var (
-		write   bool
+		perm    byte
+		plain   bool
 		handler HttpHandler
 	)
 
+	var (
+		err    error
+		system bool
+		signed bool
+		bucket *name.Bucket
+	)
+	if s.bucketMode {
+		if bucket, system, err = s.routeBucket(req); err != nil {
+			httpSendJsonData(res, &err, map[string]interface{}{})
+			return
+		}
+	} else {
+		system = isReservedName(req.URL.Path[1:])
+	}
+	// the signature was of the path with the virtual host bucket, not valid in the other buckets
+	if signed, err = s.verifySignature(req); err != nil {
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	switch req.Method {
 	case "PUT":
-		write = true
-		handler = s.handleUpload
+		perm = PermWrite
+		if _, ok := req.URL.Query()["bucket"]; ok && s.bucketMode && req.URL.Path == "/" {
+			handler = s.handlePutBucket
+		} else if _, ok := req.URL.Query()["tagging"]; ok {
+			handler = s.handleTagging
+		} else if _, ok := req.URL.Query()["uploadId"]; ok {
+			handler = s.handleUploadPart
+		} else {
+			plain = true
+			handler = s.handleUpload
+		}
+	case "POST":
+		perm = PermWrite
+		if _, ok := req.URL.Query()["restore"]; ok {
+			handler = s.handleRestore
+		} else if _, ok := req.URL.Query()["uploads"]; ok {
+			handler = s.handleCreateUpload
+		} else if _, ok := req.URL.Query()["uploadId"]; ok {
+			handler = s.handleCompleteUpload
+		} else {
+			http.Error(res, "Access POST with restore, uploads or uploadId", http.StatusMethodNotAllowed)
+			return
+		}
 	case "DELETE":
-		write = true
-		handler = s.handleDelete
+		perm = PermDelete
+		if _, ok := req.URL.Query()["trash"]; ok && req.URL.Path == "/" {
+			handler = s.handlePurgeTrash
+		} else if _, ok := req.URL.Query()["bucket"]; ok && s.bucketMode && req.URL.Path == "/" {
+			handler = s.handleDeleteBucket
+		} else if req.URL.Query().Get("recursive") == "true" && strings.HasSuffix(req.URL.Path, "/") {
+			handler = s.handleDeletePrefix
+		} else if _, ok := req.URL.Query()["tagging"]; ok {
+			handler = s.handleTagging
+		} else if _, ok := req.URL.Query()["uploadId"]; ok {
+			handler = s.handleAbortUpload
+		} else {
+			handler = s.handleDelete
+		}
+	case "COPY":
+		perm = PermRead
+		handler = s.handleCopy
+	case "MOVE":
+		perm = PermDelete
+		handler = s.handleMove
 	case "HEAD", "GET":
-		handler = s.handleDownload
+		perm = PermRead
+		if _, ok := req.URL.Query()["watch"]; ok && req.URL.Path == "/" {
+			handler = s.handleWatch
+		} else if _, ok := req.URL.Query()["trash"]; ok && req.URL.Path == "/" {
+			handler = s.handleTrash
+		} else if _, ok := req.URL.Query()["buckets"]; ok && s.bucketMode && req.URL.Path == "/" {
+			handler = s.handleBuckets
+		} else if _, ok := req.URL.Query()["du"]; ok && req.URL.Path == "/" {
+			handler = s.handleUsage
+		} else if _, ok := req.URL.Query()["tag"]; ok && strings.HasSuffix(req.URL.Path, "/") {
+			handler = s.handleTagged
+		} else if _, ok := req.URL.Query()["tagging"]; ok {
+			handler = s.handleTagging
+		} else if _, ok := req.URL.Query()["versions"]; ok {
+			handler = s.handleVersions
+		} else if _, ok := req.URL.Query()["uploadId"]; ok {
+			handler = s.handleListParts
+		} else if s.thumbRequested(req) {
+			handler = s.handleThumbnail
+		} else {
+			plain = true
+			handler = s.handleDownload
+		}
 	default:
-		http.Error(res, "Access PUT,DELETE,HEAD,GET", http.StatusMethodNotAllowed)
+		http.Error(res, "Access PUT,POST,DELETE,COPY,MOVE,HEAD,GET", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if write && len(s.safeCode) > 0 {
-		safeCode := req.Header.Get("VXFS-SAFE-CODE")
-		if len(safeCode) < 1 || s.safeCode != safeCode {
-			http.Error(res, "Require Header `VXFS-SAFE-CODE`", http.StatusUnauthorized)
+	// the presigned url only authorize the upload and download
+	if !signed || !plain || system {
+		key := req.Header.Get("VXFS-SAFE-CODE")
+		err = s.authorize(key, s.namePolicy.Transform(req.URL.Path[1:]), perm, bucket, system)
+		// the copy and move also write the destination
+		if err == nil && (req.Method == "COPY" || req.Method == "MOVE") {
+			if to, e := s.parseDestination(req); e == nil {
+				b, _ := s.getBucket(to)
+				err = s.authorize(key, to, PermWrite, b, false)
+			}
+		}
+		if err != nil {
+			if len(key) < 1 {
+				http.Error(res, "Require Header `VXFS-SAFE-CODE` or the signature", http.StatusUnauthorized)
+				return
+			}
+			httpSendJsonData(res, &err, map[string]interface{}{})
 			return
 		}
 	}
 	handler(res, req)
 }
 
+// the system names of trash, buckets, uploads, parts and thumbnails can't be written by the clients
+func isReservedName(path string) bool {
+	return isTrash(path) || isBucketRecord(path) || isUploadRecord(path) || isThumbName(path)
+}
+
 func (s *ProxyServer) parseName(req *http.Request) (name string, err error) {
 	path := req.URL.Path[1:]
 	if len(path) < 1 || strings.HasSuffix(path, "/") || strings.Contains(req.URL.Path, "/./") || strings.Contains(req.URL.Path, "/../") {
 		err = ErrHttpPathFormat
 		return
 	}
-	name = path
+	name, err = s.namePolicy.Apply(path)
 	return
 }
 
-func (s *ProxyServer) handleUpload(res http.ResponseWriter, req *http.Request) {
+func (s *ProxyServer) parseDestination(req *http.Request) (name string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(req.Header.Get("Destination")); err != nil || len(u.Path) < 2 || u.Path[0] != '/' {
+		err = ErrHttpDestination
+		return
+	}
+	path := u.Path[1:]
+	if strings.HasSuffix(path, "/") || strings.Contains(u.Path, "/./") || strings.Contains(u.Path, "/../") {
+		err = ErrHttpDestination
+		return
+	}
+	if bucket := s.hostBucket(req); len(bucket) > 0 {
+		path = bucket + "/" + path
+	}
+	if name, err = s.namePolicy.Apply(path); err != nil {
+		return
+	}
+	// the reserved names were checked after the policy, as the folded case
+	if isReservedName(name) {
+		name = ""
+		err = ErrHttpDestination
+		return
+	}
+	// the buckets were isolated
+	if s.bucketMode && bucketOf(name) != bucketOf(s.namePolicy.Transform(req.URL.Path[1:])) {
+		name = ""
+		err = ErrHttpDestination
+		return
+	}
+	return
+}
+
+func (s *ProxyServer) parseVersion(req *http.Request) (version int64, err error) {
+	value := req.URL.Query().Get("versionId")
+	if len(value) < 1 {
+		return
+	}
+	if version, err = strconv.ParseInt(value, 10, 64); err != nil || version == 0 {
+		err = ErrHttpVersionId
+		return
+	}
+	return
+}
+
+func (s *ProxyServer) parseExpires(req *http.Request) (expires int64, err error) {
 	var (
-		err   error
-		xdata = map[string]interface{}{}
+		ttl int64
+		now = time.Now().Unix()
+	)
+	if value := req.Header.Get("X-VXFS-Expires"); len(value) > 0 {
+		if expires, err = strconv.ParseInt(value, 10, 64); err != nil {
+			var t time.Time
+			if t, err = http.ParseTime(value); err != nil {
+				err = ErrHttpExpires
+				return
+			}
+			expires = t.Unix()
+		}
+	} else if value := req.Header.Get("X-VXFS-TTL"); len(value) > 0 {
+		if ttl, err = strconv.ParseInt(value, 10, 64); err != nil || ttl < 1 {
+			err = ErrHttpExpires
+			return
+		}
+		expires = now + ttl
+	}
+	if expires != 0 && expires <= now {
+		err = ErrHttpExpires
+		return
+	}
+	return
+}
 
-		meta = &FileMeta{}
+// the store data was deleted with the last name refer to it
+func (s *ProxyServer) deleteName(ndreq *name.DeleteRequest) (err error) {
+	ndres := &name.DeleteResponse{}
+	if err = s.serviceManager.DeleteName(ndreq, ndres); err != nil {
+		return
+	}
+	if !ndres.Removed || ndres.Refs > 0 || ndres.Key == 0 {
+		return
+	}
+	return s.deleteData(ndres.Sid, ndres.Key, ndres.Meta.Chunked)
+}
 
+// the expired name was kept until reaped, remove it for the new upload
+func (s *ProxyServer) removeExpired(path string) (removed bool, err error) {
+	var (
+		nreq = &name.ReadRequest{Name: path, Expired: true}
+		nres = &name.ReadResponse{}
+	)
+	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+		return
+	}
+	if nres.Meta.Expires <= 0 || nres.Meta.Expires > time.Now().Unix() {
+		return
+	}
+	freed := s.serviceManager.FreeQuota(path, nres.Meta.Size)
+	if err = s.deleteName(&name.DeleteRequest{Name: path}); err != nil {
+		return
+	}
+	s.serviceManager.ReleaseQuota(freed)
+	removed = true
+	return
+}
+
+func (s *ProxyServer) writeName(nwreq *name.WriteRequest, nwres *name.WriteResponse) (err error) {
+	if err = s.serviceManager.WriteName(nwreq, nwres); err == nil {
+		return
+	}
+	if nwreq.Versioned || !libs.IsErrorSame(err, name.ErrNameExists) {
+		return
+	}
+	var removed bool
+	if removed, err = s.removeExpired(nwreq.Name); err != nil || !removed {
+		if err == nil {
+			err = name.ErrNameExists
+		}
+		return
+	}
+	return s.serviceManager.WriteName(nwreq, nwres)
+}
+
+// the existing name was deleted as the delete of the file, into the trash if enabled, its usage was freed from the quotas
+func (s *ProxyServer) replaceName(nwreq *name.WriteRequest, nwres *name.WriteResponse) (err error) {
+	var (
+		freed *quotaReserve
+		nres  = &name.ReadResponse{}
+	)
+	if err = s.writeName(nwreq, nwres); err == nil || !libs.IsErrorSame(err, name.ErrNameExists) {
+		return
+	}
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: nwreq.Name}, nres); err != nil {
+		return
+	}
+	freed = s.serviceManager.FreeQuota(nwreq.Name, nres.Meta.Size)
+	if err = s.deleteFile(&name.DeleteRequest{Name: nwreq.Name}, map[string]interface{}{}); err != nil {
+		return
+	}
+	s.serviceManager.ReleaseQuota(freed)
+	return s.serviceManager.WriteName(nwreq, nwres)
+}
+
+// the same shard refer to the store data, across the shards copy it
+func (s *ProxyServer) copyFile(nreq *name.ReadRequest, to string, expires int64) (version int64, err error) {
+	var (
+		nres  = &name.ReadResponse{}
 		nwreq = &name.WriteRequest{}
 		nwres = &name.WriteResponse{}
 
+		sreq  = &store.ReadRequest{}
+		sres  = &store.ReadResponse{}
 		swreq = &store.WriteRequest{}
-		swres = &store.WriteResponse{}
 
-		ndreq = &name.DeleteRequest{}
-		ndres = &name.DeleteResponse{}
+		reserve *quotaReserve
+		swres   = &store.WriteResponse{}
+	)
+	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+		return
+	}
+	bucket, usage := s.getBucket(to)
+	if bucket != nil {
+		if err = checkBucket(bucket, usage, nres.Meta.Size); err != nil {
+			return
+		}
+	}
+	if reserve, err = s.serviceManager.ReserveQuota(to, nres.Meta.Size); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.serviceManager.ReleaseQuota(reserve)
+		}
+	}()
+	nwreq.Name = to
+	nwreq.Sid = nres.Sid
+	nwreq.Key = nres.Key
+	nwreq.Meta.Size = nres.Meta.Size
+	nwreq.Meta.Mime = nres.Meta.Mime
+	nwreq.Meta.ETag = nres.Meta.ETag
+	nwreq.Meta.MD5 = nres.Meta.MD5
+	nwreq.Meta.SHA256 = nres.Meta.SHA256
+	nwreq.Meta.Headers = nres.Meta.Headers
+	nwreq.Meta.Tags = nres.Meta.Tags
+	nwreq.Meta.Chunked = nres.Meta.Chunked
+	if nwreq.Meta.Expires = nres.Meta.Expires; expires > 0 {
+		nwreq.Meta.Expires = expires
+	} else if expires < 0 {
+		nwreq.Meta.Expires = 0
+	}
+	if err = checkNameMeta(&nwreq.Meta); err != nil {
+		return
+	}
+
+	if s.serviceManager.SameShard(nreq.Name, to) {
+		nwreq.Link = true
+		if s.isVersioned(to) {
+			nwreq.Versioned = true
+			if nwreq.Meta.Version, err = s.keyMaker.NextId(); err != nil {
+				return
+			}
+		}
+		if err = s.writeName(nwreq, nwres); err != nil {
+			return
+		}
+		version = nwreq.Meta.Version
+		return
+	}
+
+	sreq.Key = nres.Key
+	if err = s.serviceManager.ReadStore(nres.Sid, sreq, sres); err != nil {
+		return
+	}
+	if nres.Meta.Chunked {
+		var m, copied *Manifest
+		if err = json.Unmarshal(sres.Data, &m); err != nil {
+			return
+		}
+		if copied, err = s.copyChunks(m, bucketSids(bucket)); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				s.deleteChunks(copied)
+			}
+		}()
+		if sres.Data, err = json.Marshal(copied); err != nil {
+			return
+		}
+	}
+	if nwreq.Key, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	if nwreq.Sid, err = s.serviceManager.GetSid(int64(len(sres.Data)), bucketSids(bucket)); err != nil {
+		return
+	}
+	if s.isVersioned(to) {
+		nwreq.Versioned = true
+		nwreq.Meta.Version = nwreq.Key
+	}
+	if err = s.writeName(nwreq, nwres); err != nil {
+		return
+	}
+	swreq.Key = nwreq.Key
+	swreq.Data = sres.Data
+	swreq.Meta = sres.Meta
+	if err = s.serviceManager.WriteStore(nwreq.Sid, swreq, swres); err != nil {
+		s.serviceManager.DeleteName(&name.DeleteRequest{Name: to, Version: nwreq.Meta.Version}, &name.DeleteResponse{})
+		return
+	}
+	version = nwreq.Meta.Version
+	return
+}
+
+func (s *ProxyServer) handleUpload(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+
+		nwreq = &name.WriteRequest{}
 	)
 	defer httpSendJsonData(res, &err, xdata)
 
 	if nwreq.Name, err = s.parseName(req); err != nil {
 		return
 	}
+	if isReservedName(nwreq.Name) {
+		err = ErrHttpPathFormat
+		return
+	}
+	if nwreq.Meta.Expires, err = s.parseExpires(req); err != nil {
+		return
+	}
+	if value := req.Header.Get("X-VXFS-Tags"); len(value) > 0 {
+		if nwreq.Meta.Tags, err = parseTags(value); err != nil {
+			return
+		}
+	}
 
-	if swreq.Data, err = ioutil.ReadAll(req.Body); err != nil || len(swreq.Data) < 1 {
-		err = ErrHttpUploadBody
+	if err = s.uploadFile(req, nwreq, false); err != nil {
 		return
 	}
-	req.Body.Close()
-	meta.ETag = libs.HashSHA1(swreq.Data)
+	if nwreq.Versioned {
+		xdata["version_id"] = strconv.FormatInt(nwreq.Meta.Version, 10)
+	}
+}
 
-	if s.noDigMime {
-		if meta.Mime = req.Header.Get("Content-Type"); meta.Mime == "" {
-			meta.Mime = http.DetectContentType(swreq.Data)
+// write the body as the name, the meta of the request was filled, replace the existing one if required
+func (s *ProxyServer) uploadFile(req *http.Request, nwreq *name.WriteRequest, replace bool) (err error) {
+	var (
+		meta     = &FileMeta{}
+		size     int64
+		manifest *Manifest
+
+		nwres = &name.WriteResponse{}
+
+		swreq = &store.WriteRequest{}
+		swres = &store.WriteResponse{}
+
+		ndreq = &name.DeleteRequest{}
+		ndres = &name.DeleteResponse{}
+
+		reserve *quotaReserve
+	)
+	bucket, usage := s.getBucket(nwreq.Name)
+	if bucket != nil && bucket.MaxSize > 0 && req.ContentLength > bucket.MaxSize {
+		err = ErrBucketMaxSize
+		return
+	}
+	// the empty file was an empty manifest
+	if s.isChunked(req) || req.ContentLength == 0 {
+		var maxSize int64
+		if bucket != nil {
+			maxSize = bucket.MaxSize
 		}
-	} else {
-		headerMime := req.Header.Get("Content-Type")
-		meta.Mime = http.DetectContentType(swreq.Data)
-		if len(headerMime) > 0 {
-			if strings.HasPrefix(meta.Mime, "text/") || meta.Mime == "application/octet-stream" {
-				meta.Mime = headerMime
+		if manifest, err = s.writeChunks(req, maxSize, bucketSids(bucket), meta); err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				s.deleteChunks(manifest)
 			}
+		}()
+		if swreq.Data, err = json.Marshal(manifest); err != nil {
+			return
+		}
+		meta.Manifest = true
+		size = manifest.Size
+	} else {
+		if swreq.Data, err = ioutil.ReadAll(req.Body); err != nil || len(swreq.Data) < 1 {
+			err = ErrHttpUploadBody
+			return
 		}
+		hash := newBodyHash()
+		hash.Write(swreq.Data)
+		hash.fill(meta)
+		meta.Mime = s.detectMime(req, swreq.Data)
+		size = int64(len(swreq.Data))
 	}
+	req.Body.Close()
+	if err = verifyChecksums(req, meta); err != nil {
+		return
+	}
+	if bucket != nil {
+		if err = checkBucket(bucket, usage, size); err != nil {
+			return
+		}
+		if nwreq.Meta.Expires == 0 && bucket.TTL > 0 {
+			nwreq.Meta.Expires = time.Now().Unix() + bucket.TTL
+		}
+	}
+	if reserve, err = s.serviceManager.ReserveQuota(nwreq.Name, size); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			s.serviceManager.ReleaseQuota(reserve)
+		}
+	}()
 
+	meta.Headers = parseMetaHeaders(req)
 	if swreq.Meta, err = json.Marshal(meta); err != nil {
 		return
 	}
+	if len(swreq.Meta) > store.MaxMetaSize {
+		err = ErrHttpMetaSize
+		return
+	}
 
 	if nwreq.Key, err = s.keyMaker.NextId(); err != nil {
 		return
 	}
+	nwreq.Meta.Size = size
+	nwreq.Meta.Mime = meta.Mime
+	nwreq.Meta.ETag = meta.ETag
+	nwreq.Meta.MD5 = meta.MD5
+	nwreq.Meta.SHA256 = meta.SHA256
+	nwreq.Meta.Headers = meta.Headers
+	nwreq.Meta.Chunked = meta.Manifest
+	if s.isVersioned(nwreq.Name) {
+		nwreq.Versioned = true
+		nwreq.Meta.Version = nwreq.Key
+	}
+	if err = checkNameMeta(&nwreq.Meta); err != nil {
+		return
+	}
 
-	if nwreq.Sid, err = s.serviceManager.GetSid(int64(len(swreq.Data))); err != nil {
+	if nwreq.Sid, err = s.serviceManager.GetSid(int64(len(swreq.Data)), bucketSids(bucket)); err != nil {
 		return
 	}
 
-	if err = s.serviceManager.WriteName(nwreq, nwres); err != nil {
+	if replace {
+		err = s.replaceName(nwreq, nwres)
+	} else {
+		err = s.writeName(nwreq, nwres)
+	}
+	if err != nil {
 		return
 	}
 
 	swreq.Key = nwreq.Key
 	if err = s.serviceManager.WriteStore(nwreq.Sid, swreq, swres); err != nil {
 		ndreq.Name = nwreq.Name
+		ndreq.Version = nwreq.Meta.Version
 		s.serviceManager.DeleteName(ndreq, ndres)
 		return
 	}
+	return
+}
+
+// the mime of the data, or of the header for the text
+func (s *ProxyServer) detectMime(req *http.Request, data []byte) (mime string) {
+	if s.noDigMime {
+		if mime = req.Header.Get("Content-Type"); mime == "" {
+			mime = http.DetectContentType(data)
+		}
+		return
+	}
+	headerMime := req.Header.Get("Content-Type")
+	mime = http.DetectContentType(data)
+	if len(headerMime) > 0 {
+		if strings.HasPrefix(mime, "text/") || mime == "application/octet-stream" {
+			mime = headerMime
+		}
+	}
+	return
 }
 
 func (s *ProxyServer) handleDelete(res http.ResponseWriter, req *http.Request) {
@@ -176,75 +692,563 @@ func (s *ProxyServer) handleDelete(res http.ResponseWriter, req *http.Request) {
 		err   error
 		xdata = map[string]interface{}{}
 
-		nreq = &name.ReadRequest{}
-		nres = &name.ReadResponse{}
-
 		ndreq = &name.DeleteRequest{}
-		ndres = &name.DeleteResponse{}
-
-		sdreq = &store.DeleteRequest{}
-		sdres = &store.DeleteResponse{}
 	)
 	defer httpSendJsonData(res, &err, xdata)
 
-	if nreq.Name, err = s.parseName(req); err != nil {
+	if ndreq.Name, err = s.parseName(req); err != nil {
+		return
+	}
+	if ndreq.Version, err = s.parseVersion(req); err != nil {
+		return
+	}
+	// the purge skip the trash, as the expired names reaped
+	if _, ok := req.URL.Query()["purge"]; ok && ndreq.Version == 0 && !s.isVersioned(ndreq.Name) {
+		err = s.deleteName(ndreq)
 		return
 	}
+	if err = s.deleteFile(ndreq, xdata); err != nil {
+		return
+	}
+}
 
-	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
-		if libs.IsErrorSame(err, name.ErrNameNotExists) {
-			err = nil
+func (s *ProxyServer) deleteFile(ndreq *name.DeleteRequest, xdata map[string]interface{}) (err error) {
+	// the versioned name only write a delete marker, keep the store data
+	if ndreq.Version == 0 && s.isVersioned(ndreq.Name) {
+		if ndreq.Marker, err = s.keyMaker.NextId(); err != nil {
+			return
+		}
+		if err = s.serviceManager.DeleteName(ndreq, &name.DeleteResponse{}); err != nil {
+			return
 		}
+		xdata["version_id"] = strconv.FormatInt(ndreq.Marker, 10)
 		return
 	}
 
-	sdreq.Key = nres.Key
-	if err = s.serviceManager.DeleteStore(nres.Sid, sdreq, sdres); err != nil {
-		if !libs.IsErrorSame(err, store.ErrStoreNotExists) {
+	if s.trashRetention > 0 && ndreq.Version == 0 && !isTrash(ndreq.Name) && !isThumbName(ndreq.Name) {
+		var id int64
+		if id, err = s.trashFile(ndreq.Name); err != nil || id == 0 {
 			return
 		}
+		xdata["trash_id"] = strconv.FormatInt(id, 10)
+		return
 	}
 
-	ndreq.Name = nreq.Name
-	if err = s.serviceManager.DeleteName(ndreq, ndres); err != nil {
+	return s.deleteName(ndreq)
+}
+
+func (s *ProxyServer) handleDeletePrefix(res http.ResponseWriter, req *http.Request) {
+	var (
+		err     error
+		deleted = 0
+		encoder = json.NewEncoder(res)
+
+		nlreq = &name.ListRequest{Limit: MaxDeleteBatch}
+	)
+	nlreq.Prefix = s.namePolicy.Transform(req.URL.Path[1:])
+	nlreq.Marker = req.URL.Query().Get("marker")
+	if len(nlreq.Prefix) < 1 || strings.Contains(req.URL.Path, "/./") || strings.Contains(req.URL.Path, "/../") {
+		err = ErrHttpPathFormat
+		httpSendJsonData(res, &err, map[string]interface{}{})
 		return
 	}
+
+	// a progress line for every batch, resume by the marker of the last line
+	res.Header().Set("Content-Type", "application/x-ndjson;charset=utf-8")
+	progress := func(done bool) {
+		result := map[string]interface{}{
+			"code": 0,
+			"data": map[string]interface{}{
+				"deleted": deleted,
+				"marker":  nlreq.Marker,
+				"done":    done,
+			},
+		}
+		if err != nil {
+			result["code"] = errorToErrorCode(err)
+			result["error"] = err.Error()
+		}
+		encoder.Encode(result)
+		if flusher, ok := res.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	for {
+		nlres := &name.ListResponse{}
+		if err = s.serviceManager.ListName(nlreq, nlres, true); err != nil {
+			break
+		}
+		for _, v := range nlres.Entries {
+			if err = s.deleteFile(&name.DeleteRequest{Name: v.Name}, map[string]interface{}{}); err != nil {
+				break
+			}
+			nlreq.Marker = v.Name
+			deleted += 1
+		}
+		if err != nil || !nlres.More || len(nlres.Entries) < 1 {
+			break
+		}
+		progress(false)
+	}
+	progress(err == nil)
+}
+
+// the names and the common prefixes after the marker, and the last one of them for the next page
+func (s *ProxyServer) listNames(prefix string, marker string, delimiter string, limit int) (entries []name.NameEntry, prefixes []string, next string, more bool, err error) {
+	nlreq := &name.ListRequest{Prefix: prefix, Marker: marker}
+	// the marker of a common prefix skip the names under it, the names were valid utf-8
+	if len(delimiter) > 0 && strings.HasSuffix(marker, delimiter) {
+		nlreq.Marker = marker + prefixBoundary
+	}
+	for count := 0; ; {
+		nlres := &name.ListResponse{}
+		if err = s.serviceManager.ListName(nlreq, nlres, false); err != nil {
+			return
+		}
+		skip := ""
+		for _, v := range nlres.Entries {
+			common := ""
+			if len(delimiter) > 0 {
+				if i := strings.Index(v.Name[len(prefix):], delimiter); i >= 0 {
+					common = v.Name[:len(prefix)+i+len(delimiter)]
+				}
+			}
+			if len(common) > 0 && (common <= marker || (len(prefixes) > 0 && prefixes[len(prefixes)-1] == common)) {
+				continue
+			}
+			if count >= limit {
+				more = true
+				return
+			}
+			count++
+			if len(common) > 0 {
+				prefixes = append(prefixes, common)
+				next = common
+				skip = common + prefixBoundary
+			} else {
+				entries = append(entries, v)
+				next = v.Name
+				skip = ""
+			}
+		}
+		if !nlres.More || len(nlres.Entries) < 1 {
+			return
+		}
+		if nlreq.Marker = nlres.Entries[len(nlres.Entries)-1].Name; skip > nlreq.Marker {
+			nlreq.Marker = skip
+		}
+	}
 }
 
 func (s *ProxyServer) handleDownload(res http.ResponseWriter, req *http.Request) {
 	var (
-		err   error
-		mime  string
-		xdata []byte
+		err  error
+		nreq = &name.ReadRequest{}
+	)
+	defer func() {
+		if err != nil {
+			var (
+				mime  string
+				xdata []byte
+			)
+			httpSendByteData(res, &err, &mime, &xdata)
+		}
+	}()
+
+	if nreq.Name, err = s.parseName(req); err != nil {
+		return
+	}
+	if nreq.Version, err = s.parseVersion(req); err != nil {
+		return
+	}
+	err = s.sendFile(res, req, nreq)
+}
+
+// the response was written unless the error was returned
+func (s *ProxyServer) sendFile(res http.ResponseWriter, req *http.Request, nreq *name.ReadRequest) (err error) {
+	var (
+		mime   string
+		status int
+		xdata  []byte
+		etag   string
+		size   int64
+		ranges []httpRange
+		parts  [][]byte
+		stream bool
 
 		meta FileMeta
 
-		nreq = &name.ReadRequest{}
 		nres = &name.ReadResponse{}
 
 		sreq = &store.ReadRequest{}
 		sres = &store.ReadResponse{}
 	)
-	defer httpSendByteData(res, &err, &mime, &xdata)
+	defer func() {
+		if err != nil || stream {
+			return
+		}
+		if status != 0 {
+			res.WriteHeader(status)
+			res.Write(xdata)
+			return
+		}
+		httpSendByteData(res, &err, &mime, &xdata)
+	}()
+
+	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+		return
+	}
+	header := res.Header()
+	if nres.Meta.Version != 0 {
+		header.Set("X-VXFS-Version-Id", strconv.FormatInt(nres.Meta.Version, 10))
+	}
+	if nres.Meta.Expires > 0 {
+		header.Set("X-VXFS-Expires", strconv.FormatInt(nres.Meta.Expires, 10))
+	}
+	if len(nres.Meta.Tags) > 0 {
+		header.Set("X-VXFS-Tags", formatTags(nres.Meta.Tags))
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	// the name record with meta can answer without the store, the checksums and headers were kept with the etag
+	sreq.Key = nres.Key
+	if len(nres.Meta.ETag) > 0 {
+		etag, mime, size = nres.Meta.ETag, nres.Meta.Mime, nres.Meta.Size
+		meta.MD5, meta.SHA256, meta.Headers = nres.Meta.MD5, nres.Meta.SHA256, nres.Meta.Headers
+	} else {
+		if err = s.serviceManager.ReadStore(nres.Sid, sreq, sres); err != nil {
+			return
+		}
+		if err = json.Unmarshal(sres.Meta, &meta); err != nil {
+			return
+		}
+		etag, mime, size, xdata = meta.ETag, meta.Mime, int64(len(sres.Data)), sres.Data
+	}
+
+	httpSetFileHeader(res, etag, nres.Meta.Mtime)
+	if httpPreconditionFailed(req, etag, nres.Meta.Mtime) {
+		xdata = nil
+		status = http.StatusPreconditionFailed
+		return
+	}
+	if httpNotModified(req, etag, nres.Meta.Mtime) {
+		xdata = nil
+		status = http.StatusNotModified
+		return
+	}
+	setChecksumHeader(header, &meta)
+	setMetaHeader(header, &meta)
+	if req.Method == "HEAD" {
+		header.Set("Content-Type", mime)
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+		xdata = nil
+		status = http.StatusOK
+		return
+	}
+
+	if value := req.Header.Get("Range"); len(value) > 0 && httpIfRange(req, etag, nres.Meta.Mtime) {
+		if ranges, err = httpParseRange(value, size); err != nil {
+			err = nil
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			xdata = nil
+			status = http.StatusRequestedRangeNotSatisfiable
+			return
+		}
+	}
+	// the md5 was not of the partial content
+	if len(ranges) > 0 {
+		header.Del("Content-MD5")
+	}
+	if nres.Meta.Chunked {
+		var m *Manifest
+		if m, err = s.readManifest(nres.Sid, nres.Key); err != nil {
+			return
+		}
+		stream = true
+		s.streamChunks(res, m, ranges, mime, size)
+		return
+	}
+	if len(ranges) < 1 {
+		if xdata == nil {
+			if err = s.serviceManager.ReadStore(nres.Sid, sreq, sres); err != nil {
+				return
+			}
+			xdata = sres.Data
+		}
+		return
+	}
+
+	// the ranges were read from the store, unless the whole data was read
+	for _, r := range ranges {
+		if xdata != nil {
+			parts = append(parts, xdata[r.start:r.start+r.length])
+			continue
+		}
+		rres := &store.ReadResponse{}
+		if err = s.serviceManager.ReadStore(nres.Sid, &store.ReadRequest{Key: nres.Key, Offset: r.start, Length: r.length}, rres); err != nil {
+			return
+		}
+		parts = append(parts, rres.Data)
+	}
+	if len(mime) < 1 {
+		mime = "application/octet-stream"
+	}
+	if len(ranges) == 1 {
+		header.Set("Content-Type", mime)
+		header.Set("Content-Range", ranges[0].contentRange(size))
+		xdata = parts[0]
+	} else {
+		var contentType string
+		contentType, xdata = httpRangeBody(ranges, parts, mime, size)
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(xdata)))
+	status = http.StatusPartialContent
+	return
+}
+
+func (s *ProxyServer) handleVersions(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+
+		nvreq = &name.VersionsRequest{}
+		nvres = &name.VersionsResponse{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if nvreq.Name, err = s.parseName(req); err != nil {
+		return
+	}
+	if err = s.serviceManager.VersionsName(nvreq, nvres); err != nil {
+		return
+	}
+
+	versions := make([]map[string]interface{}, 0, len(nvres.Versions))
+	for i, v := range nvres.Versions {
+		version := map[string]interface{}{
+			"version_id": strconv.FormatInt(v.Meta.Version, 10),
+			"latest":     i == 0,
+			"mtime":      v.Meta.Mtime,
+		}
+		if v.Meta.Marker {
+			version["marker"] = true
+		} else {
+			version["size"] = v.Meta.Size
+			version["mime"] = v.Meta.Mime
+			version["etag"] = v.Meta.ETag
+		}
+		versions = append(versions, version)
+	}
+	xdata["versions"] = versions
+}
+
+func (s *ProxyServer) handleCopy(res http.ResponseWriter, req *http.Request) {
+	var (
+		err     error
+		xdata   = map[string]interface{}{}
+		to      string
+		expires int64
+		version int64
+
+		nreq = &name.ReadRequest{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
 
 	if nreq.Name, err = s.parseName(req); err != nil {
 		return
 	}
+	if nreq.Version, err = s.parseVersion(req); err != nil {
+		return
+	}
+	if to, err = s.parseDestination(req); err != nil {
+		return
+	}
+	if expires, err = s.parseExpires(req); err != nil {
+		return
+	}
+	if version, err = s.copyFile(nreq, to, expires); err != nil {
+		return
+	}
+	if version != 0 {
+		xdata["version_id"] = strconv.FormatInt(version, 10)
+	}
+}
 
-	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+func (s *ProxyServer) handleMove(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+
+		from string
+		to   string
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if from, err = s.parseName(req); err != nil {
+		return
+	}
+	if isTrash(from) {
+		err = ErrHttpPathFormat
+		return
+	}
+	if to, err = s.parseDestination(req); err != nil {
 		return
 	}
+	if s.isVersioned(from) || s.isVersioned(to) {
+		err = name.ErrNameVersion
+		return
+	}
+	if err = s.moveFile(from, to, 0); err != nil {
+		return
+	}
+}
 
-	sreq.Key = nres.Key
-	if err = s.serviceManager.ReadStore(nres.Sid, sreq, sres); err != nil {
+// the expires replace the old one, or clear it when negative
+func (s *ProxyServer) moveFile(from string, to string, expires int64) (err error) {
+	if s.serviceManager.SameShard(from, to) {
+		return s.serviceManager.RenameName(&name.RenameRequest{Name: from, To: to, Expires: expires}, &name.RenameResponse{})
+	}
+
+	// the references were counted in a shard, copy the store data
+	if _, err = s.copyFile(&name.ReadRequest{Name: from}, to, expires); err != nil {
 		return
 	}
+	return s.deleteName(&name.DeleteRequest{Name: from})
+}
 
-	if err = json.Unmarshal(sres.Meta, &meta); err != nil {
+func (s *ProxyServer) parseWatch(req *http.Request) (shard int32, nwreq *name.WatchRequest, err error) {
+	var (
+		v     int64
+		query = req.URL.Query()
+	)
+	nwreq = &name.WatchRequest{Prefix: s.namePolicy.Transform(query.Get("prefix"))}
+	if value := query.Get("shard"); len(value) > 0 {
+		if v, err = strconv.ParseInt(value, 10, 32); err != nil {
+			err = ErrHttpWatchParam
+			return
+		}
+		shard = int32(v)
+	} else if shards := s.serviceManager.NameShards(); len(shards) == 1 {
+		shard = shards[0]
+	} else {
+		err = ErrHttpWatchParam
 		return
 	}
+	sequence := query.Get("sequence")
+	if value := req.Header.Get("Last-Event-ID"); len(value) > 0 {
+		sequence = value
+	}
+	if len(sequence) > 0 {
+		if nwreq.Sequence, err = strconv.ParseInt(sequence, 10, 64); err != nil || nwreq.Sequence < 0 {
+			err = ErrHttpWatchParam
+			return
+		}
+	}
+	if value := query.Get("limit"); len(value) > 0 {
+		if nwreq.Limit, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpWatchParam
+			return
+		}
+	}
+	if value := query.Get("wait"); len(value) > 0 {
+		if nwreq.Wait, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpWatchParam
+			return
+		}
+	}
+	return
+}
+
+func watchEvent(e *name.NameEvent) map[string]interface{} {
+	event := map[string]interface{}{
+		"sequence": strconv.FormatInt(e.Sequence, 10),
+		"type":     e.Type,
+		"name":     e.Name,
+	}
+	if e.Meta.Mtime > 0 {
+		event["mtime"] = e.Meta.Mtime
+	}
+	if len(e.From) > 0 {
+		event["from"] = e.From
+	}
+	if e.Meta.Version != 0 {
+		event["version_id"] = strconv.FormatInt(e.Meta.Version, 10)
+	}
+	if e.Type != "delete" {
+		event["size"] = e.Meta.Size
+		event["mime"] = e.Meta.Mime
+		event["etag"] = e.Meta.ETag
+	}
+	return event
+}
 
-	mime = meta.Mime
-	xdata = sres.Data
-	res.Header().Set("ETag", meta.ETag)
+func (s *ProxyServer) handleWatch(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		shard int32
+
+		nwreq *name.WatchRequest
+		nwres = &name.WatchResponse{}
+	)
+	if shard, nwreq, err = s.parseWatch(req); err != nil {
+		httpSendJsonData(res, &err, xdata)
+		return
+	}
+	if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		s.streamWatch(res, req, shard, nwreq)
+		return
+	}
+	defer httpSendJsonData(res, &err, xdata)
+
+	if err = s.serviceManager.WatchName(shard, nwreq, nwres); err != nil {
+		return
+	}
+	events := make([]map[string]interface{}, 0, len(nwres.Events))
+	for i := range nwres.Events {
+		events = append(events, watchEvent(&nwres.Events[i]))
+	}
+	xdata["shard"] = shard
+	xdata["sequence"] = strconv.FormatInt(nwres.Sequence, 10)
+	xdata["events"] = events
+}
+
+func (s *ProxyServer) streamWatch(res http.ResponseWriter, req *http.Request, shard int32, nwreq *name.WatchRequest) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	header := res.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if nwreq.Wait < 1 {
+		nwreq.Wait = 30
+	}
+	for !s.closed {
+		nwres := &name.WatchResponse{}
+		if err := s.serviceManager.WatchName(shard, nwreq, nwres); err != nil {
+			fmt.Fprintf(res, "event: error\ndata: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		for i := range nwres.Events {
+			data, _ := json.Marshal(watchEvent(&nwres.Events[i]))
+			if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", nwres.Events[i].Sequence, nwres.Events[i].Type, data); err != nil {
+				return
+			}
+		}
+		// keep alive, and detect the gone client
+		if len(nwres.Events) == 0 {
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		nwreq.Sequence = nwres.Sequence
+	}
 }
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/libs"
+)
+
+// trash name: .trash/<trash id>/<name>
+const (
+	TrashPrefix = ".trash/"
+)
+
+func isTrash(path string) bool {
+	return strings.HasPrefix(path, TrashPrefix)
+}
+
+func toTrashName(id int64, path string) string {
+	return fmt.Sprintf("%s%d/%s", TrashPrefix, id, path)
+}
+
+func fromTrashName(trash string) (id int64, path string, err error) {
+	var (
+		i     int
+		value = strings.TrimPrefix(trash, TrashPrefix)
+	)
+	if i = strings.Index(value, "/"); i < 1 {
+		err = ErrHttpPathFormat
+		return
+	}
+	if id, err = strconv.ParseInt(value[:i], 10, 64); err != nil {
+		err = ErrHttpPathFormat
+		return
+	}
+	path = value[i+1:]
+	return
+}
+
+// the retention of the trashed files, second
+func (s *ProxyServer) SetTrash(retention int64) {
+	s.trashRetention = retention
+}
+
+// move the name into trash, and delete the store data if only the trashed name refer to it
+func (s *ProxyServer) trashFile(path string) (id int64, err error) {
+	var (
+		nres = &name.ReadResponse{}
+		tres = &name.ReadResponse{}
+		now  = time.Now().Unix()
+	)
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: path, Expired: true}, nres); err != nil {
+		if libs.IsErrorSame(err, name.ErrNameNotExists) {
+			err = nil
+		}
+		return
+	}
+	// the expired file skip the trash
+	if nres.Meta.Expires > 0 && nres.Meta.Expires <= now {
+		err = s.deleteName(&name.DeleteRequest{Name: path})
+		return
+	}
+
+	if id, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	trash := toTrashName(id, path)
+	if err = s.moveFile(path, trash, now+s.trashRetention); err != nil {
+		return
+	}
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: trash, Expired: true}, tres); err != nil {
+		return
+	}
+	if tres.Refs == 1 {
+		err = s.deleteData(tres.Sid, tres.Key, tres.Meta.Chunked)
+	}
+	return
+}
+
+func (s *ProxyServer) handleTrash(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		query = req.URL.Query()
+
+		nlreq = &name.ListRequest{Prefix: TrashPrefix}
+		nlres = &name.ListResponse{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	nlreq.Marker = query.Get("marker")
+	if value := query.Get("limit"); len(value) > 0 {
+		if nlreq.Limit, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpListParam
+			return
+		}
+	}
+	if err = s.serviceManager.ListName(nlreq, nlres, false); err != nil {
+		return
+	}
+
+	files := make([]map[string]interface{}, 0, len(nlres.Entries))
+	for _, v := range nlres.Entries {
+		id, path, err1 := fromTrashName(v.Name)
+		if err1 != nil {
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"trash_id": strconv.FormatInt(id, 10),
+			"name":     path,
+			"size":     v.Meta.Size,
+			"mime":     v.Meta.Mime,
+			"deleted":  v.Meta.Mtime,
+			"expires":  v.Meta.Expires,
+		})
+	}
+	xdata["files"] = files
+	if nlres.More && len(nlres.Entries) > 0 {
+		xdata["marker"] = nlres.Entries[len(nlres.Entries)-1].Name
+	}
+}
+
+func (s *ProxyServer) handleRestore(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		path  string
+		id    int64
+
+		nres = &name.ReadResponse{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if path, err = s.parseName(req); err != nil {
+		return
+	}
+	if id, err = strconv.ParseInt(req.URL.Query().Get("restore"), 10, 64); err != nil {
+		err = ErrHttpTrashId
+		return
+	}
+	trash := toTrashName(id, path)
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: trash, Expired: true}, nres); err != nil {
+		return
+	}
+	if err = s.undeleteData(nres.Sid, nres.Key, nres.Meta.Chunked); err != nil {
+		return
+	}
+	if err = s.moveFile(trash, path, -1); err != nil {
+		return
+	}
+}
+
+func (s *ProxyServer) handlePurgeTrash(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		xdata  = map[string]interface{}{}
+		purged = 0
+
+		nlreq = &name.ListRequest{Prefix: TrashPrefix}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	for {
+		nlres := &name.ListResponse{}
+		if err = s.serviceManager.ListName(nlreq, nlres, true); err != nil {
+			break
+		}
+		for _, v := range nlres.Entries {
+			if err = s.deleteName(&name.DeleteRequest{Name: v.Name}); err != nil {
+				break
+			}
+			purged += 1
+		}
+		if err != nil || !nlres.More || len(nlres.Entries) < 1 {
+			break
+		}
+		nlreq.Marker = nlres.Entries[len(nlres.Entries)-1].Name
+	}
+	xdata["purged"] = purged
+}
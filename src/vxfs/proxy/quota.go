@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"vxfs/dao/name"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+
+// the quota of the names under the prefix, 0 for unlimited
+type Quota struct {
+	Prefix string
+	Bytes  int64
+	Count  int64
+}
+
+// the usage reserved by a write in the refresh round, released when the write failed
+type quotaReserve struct {
+	path  string
+	size  int64
+	round int64
+}
+
+// prefix1/=bytes[:count],prefix2/=bytes..., the prefixes were transformed as the names
+func ParseQuotas(text string, policy *name.NamePolicy) (quotas []Quota, err error) {
+	for _, v := range strings.Split(text, ",") {
+		var (
+			q     Quota
+			parts = strings.SplitN(v, "=", 2)
+		)
+		if len(parts) != 2 || len(parts[0]) < 1 {
+			err = ErrInvalidatePrameter
+			return
+		}
+		q.Prefix = policy.Transform(parts[0])
+		limits := strings.SplitN(parts[1], ":", 2)
+		if q.Bytes, err = strconv.ParseInt(limits[0], 10, 64); err != nil || q.Bytes < 0 {
+			err = ErrInvalidatePrameter
+			return
+		}
+		if len(limits) > 1 {
+			if q.Count, err = strconv.ParseInt(limits[1], 10, 64); err != nil || q.Count < 0 {
+				err = ErrInvalidatePrameter
+				return
+			}
+		}
+		quotas = append(quotas, q)
+	}
+	return
+}
+
+// the usages of all shards, or the configured prefixes of the name servers if empty, the tracked prefixes were counted by them from now on
+func (s *ServiceManager) UsageName(prefixes []string, track bool) (usages map[string]name.NameUsage, err error) {
+	var clients []*libs.RpcClient
+	if clients, err = s.getShardClients(false); err != nil {
+		return
+	}
+	usages = make(map[string]name.NameUsage)
+	for _, client := range clients {
+		res := &name.UsageResponse{}
+		if err = client.Call("NameService.Usage", &name.UsageRequest{Prefixes: prefixes, Track: track}, res); err != nil {
+			return
+		}
+		for i := 0; i < len(res.Prefixes) && i < len(res.Usages); i++ {
+			u := usages[res.Prefixes[i]]
+			u.Count += res.Usages[i].Count
+			u.Bytes += res.Usages[i].Bytes
+			usages[res.Prefixes[i]] = u
+		}
+	}
+	return
+}
+
+func (s *ServiceManager) SetQuotas(quotas []Quota) {
+	s.quotaLock.Lock()
+	s.quotas = quotas
+	s.quotaUsages = make(map[string]name.NameUsage)
+	s.quotaPending = make(map[string]name.NameUsage)
+	s.quotaLock.Unlock()
+}
+
+func addUsage(usages map[string]name.NameUsage, prefix string, count int64, bytes int64) {
+	u := usages[prefix]
+	u.Count += count
+	u.Bytes += bytes
+	usages[prefix] = u
+}
+
+// the reserves of the round were flushing with the refresh, they were kept on the errors
+func (s *ServiceManager) refreshQuotas() {
+	s.quotaLock.Lock()
+	prefixes := make([]string, 0, len(s.quotas))
+	for _, q := range s.quotas {
+		prefixes = append(prefixes, q.Prefix)
+	}
+	if len(prefixes) < 1 {
+		s.quotaLock.Unlock()
+		return
+	}
+	s.quotaFlushing = s.quotaPending
+	s.quotaPending = make(map[string]name.NameUsage)
+	s.quotaRound++
+	s.quotaLock.Unlock()
+
+	// the quota prefixes were counted by the name servers instead of walking the names every refresh
+	usages, err := s.UsageName(prefixes, true)
+	s.quotaLock.Lock()
+	if err == nil {
+		s.quotaUsages = usages
+	} else {
+		for k, u := range s.quotaFlushing {
+			addUsage(s.quotaPending, k, u.Count, u.Bytes)
+		}
+	}
+	s.quotaFlushing = nil
+	s.quotaLock.Unlock()
+	if err != nil {
+		glog.Warningf("Quotas usage refresh error(%v)\n", err)
+	}
+}
+
+func (s *ServiceManager) Quotas() (quotas []Quota) {
+	s.quotaLock.RLock()
+	defer s.quotaLock.RUnlock()
+
+	return s.quotas
+}
+
+// the quota was checked with the usage of last refresh and the writes reserved since it
+func (s *ServiceManager) ReserveQuota(path string, size int64) (r *quotaReserve, err error) {
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	for _, q := range s.quotas {
+		if !strings.HasPrefix(path, q.Prefix) {
+			continue
+		}
+		u, p, f := s.quotaUsages[q.Prefix], s.quotaPending[q.Prefix], s.quotaFlushing[q.Prefix]
+		bytes, count := u.Bytes+p.Bytes+f.Bytes+size, u.Count+p.Count+f.Count+1
+		if (q.Bytes > 0 && bytes > q.Bytes) || (q.Count > 0 && count > q.Count) {
+			err = ErrQuotaExceeded
+			return
+		}
+	}
+	r = &quotaReserve{path: path, size: size, round: s.quotaRound}
+	for _, q := range s.quotas {
+		if strings.HasPrefix(path, q.Prefix) {
+			addUsage(s.quotaPending, q.Prefix, 1, size)
+		}
+	}
+	return
+}
+
+// the reserve of the failed write, the one of the refreshed usage was dropped already
+func (s *ServiceManager) ReleaseQuota(r *quotaReserve) {
+	if r == nil {
+		return
+	}
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	usages := s.quotaPending
+	if r.round != s.quotaRound {
+		if r.round != s.quotaRound-1 || s.quotaFlushing == nil {
+			return
+		}
+		usages = s.quotaFlushing
+	}
+	for _, q := range s.quotas {
+		if strings.HasPrefix(r.path, q.Prefix) {
+			addUsage(usages, q.Prefix, -1, -r.size)
+		}
+	}
+}
+
+// the usage of the replaced or removed file, released as a reserve of the round before the delete
+func (s *ServiceManager) FreeQuota(path string, size int64) (r *quotaReserve) {
+	s.quotaLock.RLock()
+	defer s.quotaLock.RUnlock()
+
+	r = &quotaReserve{path: path, size: size, round: s.quotaRound}
+	return
+}
+
+func usageData(prefix string, u name.NameUsage, q *Quota) map[string]interface{} {
+	data := map[string]interface{}{
+		"prefix": prefix,
+		"count":  u.Count,
+		"bytes":  u.Bytes,
+	}
+	if q != nil {
+		data["quota_count"] = q.Count
+		data["quota_bytes"] = q.Bytes
+	}
+	return data
+}
+
+// the usage of a prefix, or all counted prefixes with quotas
+func (s *ProxyServer) handleUsage(res http.ResponseWriter, req *http.Request) {
+	var (
+		err      error
+		xdata    = map[string]interface{}{}
+		usages   map[string]name.NameUsage
+		prefixes []string
+		prefix   = s.namePolicy.Transform(req.URL.Query().Get("prefix"))
+		quotas   = make(map[string]*Quota)
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	for _, q := range s.serviceManager.Quotas() {
+		quota := q
+		quotas[q.Prefix] = &quota
+	}
+	if len(prefix) > 0 {
+		prefixes = []string{prefix}
+	}
+	if usages, err = s.serviceManager.UsageName(prefixes, false); err != nil {
+		return
+	}
+	if len(prefix) > 0 {
+		xdata["usage"] = usageData(prefix, usages[prefix], quotas[prefix])
+		return
+	}
+
+	for k := range quotas {
+		if _, ok := usages[k]; !ok {
+			prefixes = append(prefixes, k)
+		}
+	}
+	if len(prefixes) > 0 {
+		var more map[string]name.NameUsage
+		if more, err = s.serviceManager.UsageName(prefixes, false); err != nil {
+			return
+		}
+		for k, u := range more {
+			usages[k] = u
+		}
+	}
+	prefixes = prefixes[:0]
+	for k := range usages {
+		prefixes = append(prefixes, k)
+	}
+	sort.Strings(prefixes)
+	list := make([]map[string]interface{}, 0, len(prefixes))
+	for _, k := range prefixes {
+		list = append(list, usageData(k, usages[k], quotas[k]))
+	}
+	xdata["usages"] = list
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"testing"
+	"vxfs/dao/name"
+)
+
+func testQuotaUsed(s *ServiceManager, prefix string) (count int64, bytes int64) {
+	u, p, f := s.quotaUsages[prefix], s.quotaPending[prefix], s.quotaFlushing[prefix]
+	return u.Count + p.Count + f.Count, u.Bytes + p.Bytes + f.Bytes
+}
+
+// the refresh was split as the rpc of the usages ran between its two halves
+func testQuotaFlush(s *ServiceManager) {
+	s.quotaFlushing = s.quotaPending
+	s.quotaPending = make(map[string]name.NameUsage)
+	s.quotaRound++
+}
+
+func testQuotaRefreshed(s *ServiceManager, usages map[string]name.NameUsage) {
+	s.quotaUsages = usages
+	s.quotaFlushing = nil
+}
+
+func TestQuotaReserve(t *testing.T) {
+	s := &ServiceManager{}
+	s.SetQuotas([]Quota{{Prefix: "img/", Bytes: 100, Count: 3}})
+	s.quotaUsages["img/"] = name.NameUsage{Count: 1, Bytes: 40}
+
+	var reserves []*quotaReserve
+	reserve := func(path string, size int64) func() error {
+		return func() (err error) {
+			var r *quotaReserve
+			if r, err = s.ReserveQuota(path, size); err == nil {
+				reserves = append(reserves, r)
+			}
+			return
+		}
+	}
+	release := func(i int) func() error {
+		return func() error {
+			s.ReleaseQuota(reserves[i])
+			return nil
+		}
+	}
+	free := func(path string, size int64) func() error {
+		return func() error {
+			s.ReleaseQuota(s.FreeQuota(path, size))
+			return nil
+		}
+	}
+	tests := []struct {
+		name  string
+		do    func() error
+		err   error
+		count int64
+		bytes int64
+	}{
+		{"reserve", reserve("img/a.jpg", 50), nil, 2, 90},
+		{"over bytes", reserve("img/b.jpg", 20), ErrQuotaExceeded, 2, 90},
+		{"other prefix", reserve("logs/a.log", 1000), nil, 2, 90},
+		{"release", release(0), nil, 1, 40},
+		{"reserve again", reserve("img/b.jpg", 20), nil, 2, 60},
+		{"reserve last", reserve("img/c.jpg", 10), nil, 3, 70},
+		{"over count", reserve("img/d.jpg", 1), ErrQuotaExceeded, 3, 70},
+		{"replaced", free("img/a.jpg", 40), nil, 2, 30},
+		{"reserve replacing", reserve("img/a.jpg", 60), nil, 3, 90},
+		{"flush", func() error { testQuotaFlush(s); return nil }, nil, 3, 90},
+		{"release flushing", release(4), nil, 2, 30},
+		{"refreshed", func() error {
+			testQuotaRefreshed(s, map[string]name.NameUsage{"img/": {Count: 3, Bytes: 90}})
+			return nil
+		}, nil, 3, 90},
+		{"release refreshed", release(3), nil, 3, 90},
+		{"freed refreshed", func() error {
+			r := s.FreeQuota("img/b.jpg", 20)
+			testQuotaFlush(s)
+			testQuotaRefreshed(s, map[string]name.NameUsage{"img/": {Count: 2, Bytes: 70}})
+			s.ReleaseQuota(r)
+			return nil
+		}, nil, 2, 70},
+	}
+	for _, tt := range tests {
+		if err := tt.do(); err != tt.err {
+			t.Errorf("%s: error(%v), want (%v)", tt.name, err, tt.err)
+		}
+		if count, bytes := testQuotaUsed(s, "img/"); count != tt.count || bytes != tt.bytes {
+			t.Errorf("%s: used %d, %d, want %d, %d", tt.name, count, bytes, tt.count, tt.bytes)
+		}
+	}
+}
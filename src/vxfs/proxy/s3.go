@@ -0,0 +1,716 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/dao/store"
+	"vxfs/libs"
+)
+
+// the path style buckets, /<bucket>/<key> was the name <bucket>/<key>
+const (
+	S3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+	S3TimeFormat    = "2006-01-02T15:04:05.000Z"
+	DefaultS3Region = "us-east-1"
+	MaxS3ListKeys   = 1000
+)
+
+type S3Server struct {
+	closed   bool
+	server   *http.Server
+	listener net.Listener
+
+	region string
+	keys   map[string]string
+	proxy  *ProxyServer
+}
+
+type S3Handler func(http.ResponseWriter, *http.Request, *s3Request)
+
+type s3Request struct {
+	secret string
+	bucket string
+	key    string
+	name   string
+	body   *s3Body
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   s3Owner
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	KeyCount              int
+	MaxKeys               int
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	Contents              []s3Object
+	CommonPrefixes        []s3CommonPrefix
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string
+	ETag         string
+}
+
+type s3ErrorResult struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string
+}
+
+// access1:secret1,access2:secret2
+func ParseS3Keys(text string) (keys map[string]string, err error) {
+	keys = make(map[string]string)
+	for _, v := range strings.Split(text, ",") {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 || len(parts[0]) < 1 || len(parts[1]) < 1 {
+			err = ErrInvalidatePrameter
+			return
+		}
+		keys[parts[0]] = parts[1]
+	}
+	return
+}
+
+// the requests were signed by the keys, the secrets were the keys of the credentials
+func NewS3Server(address string, region string, keys map[string]string, proxy *ProxyServer) (s *S3Server, err error) {
+	if len(keys) < 1 {
+		err = ErrS3KeysRequired
+		return
+	}
+	s = &S3Server{}
+	s.region = region
+	s.keys = keys
+	s.proxy = proxy
+
+	if s.listener, err = net.Listen("tcp", address); err != nil {
+		s.Close()
+		s = nil
+		return
+	}
+
+	serveMux := http.NewServeMux()
+	s.server = &http.Server{
+		Handler: serveMux,
+	}
+	serveMux.HandleFunc("/", s.route)
+	return
+}
+
+func (s *S3Server) Serve() error {
+	return s.server.Serve(s.listener)
+}
+
+func (s *S3Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+}
+
+// the etag was quoted, and the version id was in the s3 header
+type s3Writer struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *s3Writer) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	header := w.Header()
+	if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "\"") {
+		header.Set("ETag", "\""+etag+"\"")
+	}
+	if version := header.Get("X-VXFS-Version-Id"); len(version) > 0 {
+		header.Set("X-Amz-Version-Id", version)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(data)
+}
+
+func s3ErrorOf(err error) *S3Error {
+	if e, ok := err.(*S3Error); ok {
+		return e
+	}
+	switch {
+	case libs.IsErrorSame(err, name.ErrNameNotExists), libs.IsErrorSame(err, store.ErrStoreNotExists):
+		return ErrS3NoSuchKey
+	case err == ErrBucketNotExists:
+		return ErrS3NoSuchBucket
+	case err == ErrAccessDenied:
+		return ErrS3AccessDenied
+	case err == ErrBucketNotEmpty:
+		return &S3Error{409, "BucketNotEmpty", err.Error()}
+	case err == ErrBucketMaxSize:
+		return &S3Error{400, "EntityTooLarge", err.Error()}
+	case err == ErrBucketQuota, err == ErrQuotaExceeded:
+		return &S3Error{403, "QuotaExceeded", err.Error()}
+	case libs.IsErrorSame(err, name.ErrNameTooLong):
+		return &S3Error{400, "KeyTooLongError", err.Error()}
+	case libs.IsErrorSame(err, name.ErrNameInvalid):
+		return &S3Error{400, "InvalidArgument", err.Error()}
+	case libs.IsErrorSame(err, name.ErrNameExists):
+		return &S3Error{409, "OperationAborted", err.Error()}
+	case err == ErrHttpUploadBody:
+		return ErrS3IncompleteBody
+	case err == ErrHttpUploadPart:
+		return ErrS3InvalidPart
+	case err == ErrChecksumMismatch:
+		return &S3Error{400, "BadDigest", "The Content-MD5 or checksum you specified did not match what we received."}
+	case err == ErrHttpChecksum:
+		return &S3Error{400, "InvalidDigest", "The Content-MD5 or checksum you specified is not valid."}
+	case err == ErrHttpMetaSize:
+		return &S3Error{400, "MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size."}
+	case err == ErrHttpTags:
+		return &S3Error{400, "InvalidTag", err.Error()}
+	case isHttpBadRequest(err):
+		return &S3Error{400, "InvalidRequest", err.Error()}
+	case err == ErrNameServiceNoLive, err == ErrStoreServiceNoLive:
+		return &S3Error{503, "ServiceUnavailable", err.Error()}
+	}
+	return ErrS3InternalError
+}
+
+func s3SendXml(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/xml")
+	res.WriteHeader(status)
+	res.Write([]byte(xml.Header))
+	xml.NewEncoder(res).Encode(v)
+}
+
+func s3SendError(res http.ResponseWriter, req *http.Request, err error) {
+	e := s3ErrorOf(err)
+	if req.Method == "HEAD" {
+		res.WriteHeader(e.Status)
+		return
+	}
+	s3SendXml(res, e.Status, &s3ErrorResult{
+		Code:      e.Code,
+		Message:   e.Message,
+		Resource:  req.URL.Path,
+		RequestId: res.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+func s3Time(t int64) string {
+	return time.Unix(t, 0).UTC().Format(S3TimeFormat)
+}
+
+func (s *S3Server) route(res http.ResponseWriter, req *http.Request) {
+	var (
+		err     error
+		handler S3Handler
+		r       = &s3Request{}
+		query   = req.URL.Query()
+		w       = &s3Writer{ResponseWriter: res}
+	)
+	if id, e := s.proxy.keyMaker.NextId(); e == nil {
+		res.Header().Set("X-Amz-Request-Id", strconv.FormatInt(id, 16))
+	}
+	defer func() {
+		if err != nil {
+			s3SendError(w, req, err)
+		}
+	}()
+
+	var signer *s3Signer
+	if signer, r.body, err = s.authenticate(req); err != nil {
+		return
+	}
+	r.secret = signer.secret
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		r.bucket, r.key = path[:i], path[i+1:]
+	} else {
+		r.bucket = path
+	}
+	if len(r.bucket) > 0 && (r.bucket[0] == '.' || (s.proxy.bucketMode && !bucketNameRegexp.MatchString(r.bucket))) {
+		err = ErrS3InvalidBucket
+		return
+	}
+
+	_, upload := query["uploadId"]
+	switch {
+	case len(r.bucket) < 1:
+		if req.Method == "GET" {
+			handler = s.handleListBuckets
+		}
+	case len(r.key) < 1:
+		switch req.Method {
+		case "GET":
+			handler = s.handleListObjects
+		case "HEAD":
+			handler = s.handleHeadBucket
+		case "PUT":
+			handler = s.handlePutBucket
+		case "DELETE":
+			handler = s.handleDeleteBucket
+		}
+	default:
+		if r.name, err = s.proxy.namePolicy.Apply(r.bucket + "/" + r.key); err != nil {
+			return
+		}
+		switch req.Method {
+		case "GET", "HEAD":
+			if upload {
+				handler = s.handleListParts
+			} else {
+				handler = s.handleGetObject
+			}
+		case "PUT":
+			if upload {
+				handler = s.handleUploadPart
+			} else if len(req.Header.Get("X-Amz-Copy-Source")) > 0 {
+				handler = s.handleCopyObject
+			} else {
+				handler = s.handlePutObject
+			}
+		case "POST":
+			if _, ok := query["uploads"]; ok {
+				handler = s.handleCreateUpload
+			} else if upload {
+				handler = s.handleCompleteUpload
+			}
+		case "DELETE":
+			if upload {
+				handler = s.handleAbortUpload
+			} else {
+				handler = s.handleDeleteObject
+			}
+		}
+	}
+	if handler == nil {
+		err = ErrS3MethodNotAllowed
+		return
+	}
+	if err = s.authorize(req, r); err != nil {
+		return
+	}
+	// the bucket was created by PUT
+	if len(r.key) > 0 || (len(r.bucket) > 0 && req.Method != "PUT") {
+		if err = s.checkBucket(r.bucket); err != nil {
+			return
+		}
+	}
+	handler(w, req, r)
+}
+
+// the buckets were created and deleted as the system names, the list by the prefix
+func (s *S3Server) authorize(req *http.Request, r *s3Request) error {
+	var (
+		perm   byte
+		path   = r.name
+		system bool
+	)
+	switch req.Method {
+	case "GET", "HEAD":
+		perm = PermRead
+	case "PUT", "POST":
+		perm = PermWrite
+	default:
+		perm = PermDelete
+	}
+	if len(r.bucket) < 1 {
+		system = true
+	} else if len(r.key) < 1 {
+		if req.Method == "PUT" || req.Method == "DELETE" {
+			system = true
+		} else {
+			path = s.proxy.namePolicy.Transform(r.bucket + "/" + req.URL.Query().Get("prefix"))
+		}
+	}
+	if system {
+		return s.proxy.authorize(r.secret, "", perm, nil, true)
+	}
+	bucket, _ := s.proxy.getBucket(path)
+	return s.proxy.authorize(r.secret, path, perm, bucket, false)
+}
+
+func (s *S3Server) checkBucket(bucket string) error {
+	if !s.proxy.bucketMode {
+		return nil
+	}
+	if b, _ := s.proxy.serviceManager.GetBucket(bucket); b == nil {
+		return ErrBucketNotExists
+	}
+	return nil
+}
+
+// the error of the body verify was reported first
+func (r *s3Request) error(err error) error {
+	if r.body != nil && r.body.err != nil {
+		return r.body.err
+	}
+	return err
+}
+
+// the buckets of the bucket mode, or the first path segments
+func (s *S3Server) handleListBuckets(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err    error
+		result = &s3ListAllMyBucketsResult{Xmlns: S3Namespace}
+	)
+	if s.proxy.bucketMode {
+		bs, _ := s.proxy.serviceManager.Buckets()
+		for _, b := range bs {
+			result.Buckets = append(result.Buckets, s3Bucket{Name: b.Name, CreationDate: s3Time(0)})
+		}
+	} else {
+		var prefixes []string
+		if _, prefixes, _, _, err = s.proxy.listNames("", "", "/", MaxS3ListKeys); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+		for _, v := range prefixes {
+			if v[0] != '.' {
+				result.Buckets = append(result.Buckets, s3Bucket{Name: strings.TrimSuffix(v, "/"), CreationDate: s3Time(0)})
+			}
+		}
+	}
+	s3SendXml(res, http.StatusOK, result)
+}
+
+func (s *S3Server) handleHeadBucket(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	res.WriteHeader(http.StatusOK)
+}
+
+// the buckets with the default policy, require the bucket mode
+func (s *S3Server) handlePutBucket(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	if s.proxy.bucketMode {
+		if b, _ := s.proxy.serviceManager.GetBucket(r.bucket); b != nil {
+			s3SendError(res, req, &S3Error{409, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it."})
+			return
+		}
+		if err := s.proxy.serviceManager.PutBucket(&name.Bucket{Name: r.bucket}); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+	}
+	res.Header().Set("Location", "/"+r.bucket)
+	res.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) handleDeleteBucket(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err     error
+		entries []name.NameEntry
+	)
+	if entries, _, _, _, err = s.proxy.listNames(r.bucket+"/", "", "", 1); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if len(entries) > 0 {
+		s3SendError(res, req, ErrBucketNotEmpty)
+		return
+	}
+	if s.proxy.bucketMode {
+		if err = s.proxy.serviceManager.DeleteBucket(r.bucket); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// ListObjectsV2 with list-type=2, the marker of ListObjects otherwise
+func (s *S3Server) handleListObjects(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err      error
+		query    = req.URL.Query()
+		v2       = query.Get("list-type") == "2"
+		bucket   = r.bucket + "/"
+		marker   string
+		entries  []name.NameEntry
+		prefixes []string
+		next     string
+		result   = &s3ListBucketResult{
+			Xmlns:     S3Namespace,
+			Name:      r.bucket,
+			Prefix:    query.Get("prefix"),
+			Delimiter: query.Get("delimiter"),
+			MaxKeys:   MaxS3ListKeys,
+		}
+	)
+	if value := query.Get("max-keys"); len(value) > 0 {
+		if result.MaxKeys, err = strconv.Atoi(value); err != nil || result.MaxKeys < 0 {
+			s3SendError(res, req, ErrS3InvalidArgument)
+			return
+		}
+		if result.MaxKeys > MaxS3ListKeys {
+			result.MaxKeys = MaxS3ListKeys
+		}
+	}
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		marker = result.StartAfter
+		if result.ContinuationToken = query.Get("continuation-token"); len(result.ContinuationToken) > 0 {
+			var token []byte
+			if token, err = base64.URLEncoding.DecodeString(result.ContinuationToken); err != nil {
+				s3SendError(res, req, ErrS3InvalidArgument)
+				return
+			}
+			marker = string(token)
+		}
+	} else {
+		result.Marker = query.Get("marker")
+		marker = result.Marker
+	}
+	if value := query.Get("encoding-type"); len(value) > 0 {
+		if value != "url" {
+			s3SendError(res, req, ErrS3InvalidArgument)
+			return
+		}
+		result.EncodingType = value
+	}
+
+	prefix := s.proxy.namePolicy.Transform(bucket + result.Prefix)
+	if len(marker) > 0 {
+		marker = bucket + marker
+	}
+	if result.MaxKeys > 0 {
+		if entries, prefixes, next, result.IsTruncated, err = s.proxy.listNames(prefix, marker, result.Delimiter, result.MaxKeys); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+	}
+
+	encode := func(key string) string {
+		if len(result.EncodingType) > 0 {
+			return s3Escape(key, true)
+		}
+		return key
+	}
+	for _, v := range entries {
+		result.Contents = append(result.Contents, s3Object{
+			Key:          encode(v.Name[len(bucket):]),
+			LastModified: s3Time(v.Meta.Mtime),
+			ETag:         "\"" + v.Meta.ETag + "\"",
+			Size:         v.Meta.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, v := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(v[len(bucket):])})
+	}
+	result.KeyCount = len(entries) + len(prefixes)
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(next[len(bucket):]))
+		} else if len(result.Delimiter) > 0 {
+			result.NextMarker = encode(next[len(bucket):])
+		}
+	}
+	s3SendXml(res, http.StatusOK, result)
+}
+
+func (s *S3Server) handleGetObject(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err  error
+		nreq = &name.ReadRequest{Name: r.name}
+	)
+	if value := req.URL.Query().Get("versionId"); len(value) > 0 && value != "null" {
+		if nreq.Version, err = strconv.ParseInt(value, 10, 64); err != nil {
+			s3SendError(res, req, &S3Error{404, "NoSuchVersion", "The specified version does not exist."})
+			return
+		}
+	}
+	if err = s.proxy.sendFile(res, req, nreq); err != nil {
+		s3SendError(res, req, err)
+	}
+}
+
+func (s *S3Server) handlePutObject(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err   error
+		nwreq = &name.WriteRequest{Name: r.name}
+	)
+	if isReservedName(nwreq.Name) {
+		s3SendError(res, req, ErrHttpPathFormat)
+		return
+	}
+	if value := req.Header.Get("X-Amz-Tagging"); len(value) > 0 {
+		if nwreq.Meta.Tags, err = parseTags(value); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+	}
+	if err = s.proxy.uploadFile(req, nwreq, true); err != nil {
+		s3SendError(res, req, r.error(err))
+		return
+	}
+	header := res.Header()
+	header.Set("ETag", nwreq.Meta.ETag)
+	if nwreq.Versioned {
+		header.Set("X-VXFS-Version-Id", strconv.FormatInt(nwreq.Meta.Version, 10))
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// x-amz-copy-source: /<bucket>/<key>?versionId=<version>, the key was escaped
+func (s *S3Server) parseCopySource(req *http.Request) (nreq *name.ReadRequest, bucket string, err error) {
+	var (
+		source  = strings.TrimPrefix(req.Header.Get("X-Amz-Copy-Source"), "/")
+		version string
+		path    string
+	)
+	if i := strings.Index(source, "?"); i >= 0 {
+		var query url.Values
+		if query, err = url.ParseQuery(source[i+1:]); err != nil {
+			err = ErrS3InvalidArgument
+			return
+		}
+		source, version = source[:i], query.Get("versionId")
+	}
+	if path, err = url.PathUnescape(source); err != nil {
+		err = ErrS3InvalidArgument
+		return
+	}
+	i := strings.Index(path, "/")
+	if i < 1 || i == len(path)-1 {
+		err = ErrS3InvalidArgument
+		return
+	}
+	bucket = path[:i]
+	nreq = &name.ReadRequest{}
+	if nreq.Name, err = s.proxy.namePolicy.Apply(path); err != nil {
+		return
+	}
+	if len(version) > 0 && version != "null" {
+		if nreq.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+			err = &S3Error{404, "NoSuchVersion", "The specified version does not exist."}
+			return
+		}
+	}
+	return
+}
+
+// the copy kept the meta of the source, the buckets were isolated as the COPY of the REST API
+func (s *S3Server) handleCopyObject(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err     error
+		bucket  string
+		version int64
+
+		nreq *name.ReadRequest
+		nres = &name.ReadResponse{}
+	)
+	defer func() {
+		if err != nil {
+			s3SendError(res, req, err)
+		}
+	}()
+
+	if strings.EqualFold(req.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		err = ErrS3NotImplemented
+		return
+	}
+	if nreq, bucket, err = s.parseCopySource(req); err != nil {
+		return
+	}
+	if isReservedName(r.name) || isReservedName(nreq.Name) {
+		err = ErrHttpPathFormat
+		return
+	}
+	if s.proxy.bucketMode && bucketOf(nreq.Name) != bucketOf(r.name) {
+		err = ErrS3InvalidRequest
+		return
+	}
+	b, _ := s.proxy.getBucket(nreq.Name)
+	if err = s.proxy.authorize(r.secret, nreq.Name, PermRead, b, false); err != nil {
+		return
+	}
+	if err = s.checkBucket(bucket); err != nil {
+		return
+	}
+	if err = s.proxy.serviceManager.ReadName(nreq, nres); err != nil {
+		return
+	}
+	if version, err = s.proxy.copyFile(nreq, r.name, 0); err != nil {
+		return
+	}
+	if version != 0 {
+		res.Header().Set("X-VXFS-Version-Id", strconv.FormatInt(version, 10))
+	}
+	s3SendXml(res, http.StatusOK, &s3CopyObjectResult{
+		Xmlns:        S3Namespace,
+		LastModified: s3Time(time.Now().Unix()),
+		ETag:         "\"" + nres.Meta.ETag + "\"",
+	})
+}
+
+// the missing key was deleted too
+func (s *S3Server) handleDeleteObject(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		ndreq = &name.DeleteRequest{Name: r.name}
+	)
+	if value := req.URL.Query().Get("versionId"); len(value) > 0 && value != "null" {
+		if ndreq.Version, err = strconv.ParseInt(value, 10, 64); err != nil {
+			s3SendError(res, req, ErrS3InvalidArgument)
+			return
+		}
+	}
+	if err = s.proxy.deleteFile(ndreq, xdata); err != nil && !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		s3SendError(res, req, err)
+		return
+	}
+	if version, ok := xdata["version_id"].(string); ok {
+		res.Header().Set("X-Amz-Delete-Marker", "true")
+		res.Header().Set("X-Amz-Version-Id", version)
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm        = "AWS4-HMAC-SHA256"
+	s3TimeFormat       = "20060102T150405Z"
+	s3StreamingSigned  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	s3StreamingTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	s3UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	s3EmptySHA256      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	MaxS3TimeSkew       = 15 * time.Minute
+	MaxS3ChunkSize      = 16 * 1024 * 1024
+	MaxS3PresignExpires = 7 * 24 * 3600
+)
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// the uri encoding of sigv4, keep the "/" in path
+func s3Escape(text string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+// the signature of the presigned url was not signed
+func s3CanonicalQuery(values url.Values) string {
+	var pairs []string
+	for k, vs := range values {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			pairs = append(pairs, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func s3CanonicalHeaders(req *http.Request, signed []string) string {
+	var b strings.Builder
+	for _, k := range signed {
+		var values []string
+		switch k {
+		case "host":
+			values = []string{req.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(req.ContentLength, 10)}
+			if v := req.Header.Get("Content-Length"); len(v) > 0 {
+				values = []string{v}
+			}
+		case "transfer-encoding":
+			values = req.TransferEncoding
+		default:
+			values = req.Header[textproto.CanonicalMIMEHeaderKey(k)]
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		b.WriteString(k + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	return b.String()
+}
+
+func s3CanonicalRequest(req *http.Request, signed []string, payload string) string {
+	return strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		s3CanonicalQuery(req.URL.Query()),
+		s3CanonicalHeaders(req, signed),
+		strings.Join(signed, ";"),
+		payload,
+	}, "\n")
+}
+
+// the key of the scope, <date>/<region>/s3/aws4_request
+func s3SigningKey(secret string, scope []string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, v := range scope {
+		key = hmacSHA256(key, v)
+	}
+	return key
+}
+
+// the signing key and the previous signature for the streaming chunks, the secret was the key of the credential
+type s3Signer struct {
+	secret    string
+	key       []byte
+	time      string
+	scope     string
+	signature string
+}
+
+func (s *s3Signer) chunk(data []byte) string {
+	text := "AWS4-HMAC-SHA256-PAYLOAD\n" + s.time + "\n" + s.scope + "\n" + s.signature + "\n" + s3EmptySHA256 + "\n" + sha256Hex(data)
+	s.signature = hex.EncodeToString(hmacSHA256(s.key, text))
+	return s.signature
+}
+
+// the secret of the credential <key>/<date>/<region>/s3/aws4_request, and the time of the signature in the date
+func (s *S3Server) credential(credential []string, amzTime string, malformed error) (secret string, t time.Time, err error) {
+	if len(credential) != 5 || credential[3] != "s3" || credential[4] != "aws4_request" {
+		err = malformed
+		return
+	}
+	var ok bool
+	if secret, ok = s.keys[credential[0]]; !ok {
+		err = ErrS3AccessKey
+		return
+	}
+	if credential[2] != s.region {
+		err = malformed
+		return
+	}
+	var e error
+	if t, e = time.Parse(s3TimeFormat, amzTime); e != nil || !strings.HasPrefix(amzTime, credential[1]) {
+		err = malformed
+		return
+	}
+	return
+}
+
+// the signature of the canonical request, the signer continue it for the streaming chunks
+func (s *S3Server) sign(req *http.Request, secret string, credential []string, signed []string, amzTime string, payload string) *s3Signer {
+	scope := strings.Join(credential[1:], "/")
+	text := s3Algorithm + "\n" + amzTime + "\n" + scope + "\n" + sha256Hex([]byte(s3CanonicalRequest(req, signed, payload)))
+	key := s3SigningKey(secret, credential[1:])
+	signature := hex.EncodeToString(hmacSHA256(key, text))
+	return &s3Signer{secret: secret, key: key, time: amzTime, scope: scope, signature: signature}
+}
+
+// Authorization: AWS4-HMAC-SHA256 Credential=<key>/<date>/<region>/s3/aws4_request, SignedHeaders=<headers>, Signature=<signature>
+func (s *S3Server) verifyHeader(req *http.Request, payload string) (signer *s3Signer, err error) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 1 {
+		err = ErrS3AccessDenied
+		return
+	}
+	if !strings.HasPrefix(auth, s3Algorithm+" ") {
+		err = ErrS3AuthHeader
+		return
+	}
+	fields := make(map[string]string)
+	for _, v := range strings.Split(auth[len(s3Algorithm)+1:], ",") {
+		if kv := strings.SplitN(strings.TrimSpace(v), "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if len(fields["Signature"]) < 1 {
+		err = ErrS3AuthHeader
+		return
+	}
+
+	amzTime := req.Header.Get("X-Amz-Date")
+	if len(amzTime) < 1 {
+		var t time.Time
+		if t, err = http.ParseTime(req.Header.Get("Date")); err != nil {
+			err = ErrS3AccessDenied
+			return
+		}
+		amzTime = t.UTC().Format(s3TimeFormat)
+	}
+	var (
+		secret string
+		t      time.Time
+	)
+	if secret, t, err = s.credential(credential, amzTime, ErrS3AuthHeader); err != nil {
+		return
+	}
+	if skew := time.Since(t); skew > MaxS3TimeSkew || skew < -MaxS3TimeSkew {
+		err = ErrS3TimeSkewed
+		return
+	}
+	if len(payload) < 1 {
+		err = ErrS3InvalidRequest
+		return
+	}
+
+	signer = s.sign(req, secret, credential, signed, amzTime, payload)
+	if !hmac.Equal([]byte(signer.signature), []byte(fields["Signature"])) {
+		signer = nil
+		err = ErrS3Signature
+		return
+	}
+	return
+}
+
+// X-Amz-Algorithm, X-Amz-Credential, X-Amz-Date, X-Amz-Expires, X-Amz-SignedHeaders and X-Amz-Signature of the presigned url, the payload was unsigned
+func (s *S3Server) verifyQuery(req *http.Request) (signer *s3Signer, err error) {
+	var (
+		query   = req.URL.Query()
+		expires int64
+		secret  string
+		t       time.Time
+	)
+	if query.Get("X-Amz-Algorithm") != s3Algorithm || len(query.Get("X-Amz-Signature")) < 1 || len(query.Get("X-Amz-SignedHeaders")) < 1 {
+		err = ErrS3AuthQuery
+		return
+	}
+	if expires, err = strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64); err != nil || expires < 1 || expires > MaxS3PresignExpires {
+		err = ErrS3AuthQuery
+		return
+	}
+	credential := strings.Split(query.Get("X-Amz-Credential"), "/")
+	signed := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	amzTime := query.Get("X-Amz-Date")
+	if secret, t, err = s.credential(credential, amzTime, ErrS3AuthQuery); err != nil {
+		return
+	}
+	if time.Until(t) > MaxS3TimeSkew {
+		err = ErrS3AccessDenied
+		return
+	}
+	if time.Since(t) > time.Duration(expires)*time.Second {
+		err = ErrS3Expired
+		return
+	}
+
+	signer = s.sign(req, secret, credential, signed, amzTime, s3UnsignedPayload)
+	if !hmac.Equal([]byte(signer.signature), []byte(query.Get("X-Amz-Signature"))) {
+		signer = nil
+		err = ErrS3Signature
+		return
+	}
+	return
+}
+
+// the request body verified by the payload hash, or decoded from the aws-chunked encoding
+type s3Body struct {
+	body io.ReadCloser
+	err  error
+
+	hash hash.Hash
+	sum  string
+
+	chunked bool
+	reader  *bufio.Reader
+	signer  *s3Signer
+	data    []byte
+	offset  int
+	done    bool
+}
+
+// the body was replaced, and the length was the decoded length for the aws-chunked
+func (s *S3Server) authenticate(req *http.Request) (signer *s3Signer, body *s3Body, err error) {
+	if _, ok := req.URL.Query()["X-Amz-Algorithm"]; ok {
+		if signer, err = s.verifyQuery(req); err != nil {
+			return
+		}
+		body = &s3Body{body: req.Body}
+		req.Body = body
+		return
+	}
+
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if signer, err = s.verifyHeader(req, payload); err != nil {
+		return
+	}
+
+	body = &s3Body{body: req.Body}
+	switch {
+	case payload == s3StreamingSigned || payload == s3StreamingTrailer:
+		body.chunked = true
+		body.reader = bufio.NewReader(req.Body)
+		if payload == s3StreamingSigned {
+			body.signer = signer
+		}
+		req.ContentLength = -1
+		if value := req.Header.Get("X-Amz-Decoded-Content-Length"); len(value) > 0 {
+			if req.ContentLength, err = strconv.ParseInt(value, 10, 64); err != nil {
+				err = ErrS3InvalidArgument
+				return
+			}
+		}
+	case strings.HasPrefix(payload, "STREAMING-"):
+		err = ErrS3NotImplemented
+		return
+	case len(payload) == sha256.Size*2:
+		body.hash = sha256.New()
+		body.sum = strings.ToLower(payload)
+	}
+	req.Body = body
+	return
+}
+
+func (b *s3Body) Read(p []byte) (n int, err error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if !b.chunked {
+		n, err = b.body.Read(p)
+		if b.hash != nil {
+			b.hash.Write(p[:n])
+			if err == io.EOF && hex.EncodeToString(b.hash.Sum(nil)) != b.sum {
+				b.err = ErrS3ContentSHA256
+				err = b.err
+			}
+		}
+		return
+	}
+	for b.offset >= len(b.data) {
+		if b.done {
+			return 0, io.EOF
+		}
+		if b.err = b.next(); b.err != nil {
+			return 0, b.err
+		}
+	}
+	n = copy(p, b.data[b.offset:])
+	b.offset += n
+	return
+}
+
+func (b *s3Body) Close() error {
+	return b.body.Close()
+}
+
+// <hex size>[;chunk-signature=<signature>]\r\n<data>\r\n, the last chunk was empty and followed by the trailers
+func (b *s3Body) next() (err error) {
+	var (
+		line []byte
+		size int64
+	)
+	if line, err = b.reader.ReadSlice('\n'); err != nil {
+		return ErrS3IncompleteBody
+	}
+	fields := strings.SplitN(strings.TrimRight(string(line), "\r\n"), ";", 2)
+	if size, err = strconv.ParseInt(fields[0], 16, 64); err != nil || size < 0 || size > MaxS3ChunkSize {
+		return ErrS3IncompleteBody
+	}
+	if int64(cap(b.data)) < size {
+		b.data = make([]byte, size)
+	}
+	b.data = b.data[:size]
+	b.offset = 0
+	if _, err = io.ReadFull(b.reader, b.data); err != nil {
+		return ErrS3IncompleteBody
+	}
+	if b.signer != nil {
+		if len(fields) < 2 || !hmac.Equal([]byte(b.signer.chunk(b.data)), []byte(strings.TrimPrefix(fields[1], "chunk-signature="))) {
+			return ErrS3Signature
+		}
+	}
+	if size > 0 {
+		if line, err = b.reader.ReadSlice('\n'); err != nil || strings.TrimRight(string(line), "\r\n") != "" {
+			return ErrS3IncompleteBody
+		}
+		return nil
+	}
+	// the trailers were not verified
+	b.done = true
+	for {
+		if line, err = b.reader.ReadSlice('\n'); err != nil || len(strings.TrimSpace(string(line))) < 1 {
+			return nil
+		}
+	}
+}
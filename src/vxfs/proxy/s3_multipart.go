@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"vxfs/dao/name"
+	"vxfs/libs"
+)
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type s3Part struct {
+	PartNumber   int
+	ETag         string
+	LastModified string `xml:",omitempty"`
+	Size         int64  `xml:",omitempty"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+type s3ListPartsResult struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Xmlns                string   `xml:"xmlns,attr"`
+	Bucket               string
+	Key                  string
+	UploadId             string
+	PartNumberMarker     int
+	NextPartNumberMarker int
+	MaxParts             int
+	IsTruncated          bool
+	Parts                []s3Part `xml:"Part"`
+}
+
+// the upload of the uploadId must exist
+func (s *S3Server) parseUpload(req *http.Request, r *s3Request) (id int64, err error) {
+	if id, err = strconv.ParseInt(req.URL.Query().Get("uploadId"), 10, 64); err != nil {
+		err = ErrS3NoSuchUpload
+		return
+	}
+	if _, err = s.proxy.readUpload(id, r.name); err != nil {
+		if libs.IsErrorSame(err, name.ErrNameNotExists) {
+			err = ErrS3NoSuchUpload
+		}
+	}
+	return
+}
+
+func (s *S3Server) handleCreateUpload(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err  error
+		id   int64
+		meta = name.NameMeta{Mime: req.Header.Get("Content-Type")}
+	)
+	if value := req.Header.Get("X-Amz-Tagging"); len(value) > 0 {
+		if meta.Tags, err = parseTags(value); err != nil {
+			s3SendError(res, req, err)
+			return
+		}
+	}
+	if id, err = s.proxy.createUpload(r.name, meta); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	s3SendXml(res, http.StatusOK, &s3InitiateMultipartUploadResult{
+		Xmlns:    S3Namespace,
+		Bucket:   r.bucket,
+		Key:      r.key,
+		UploadId: strconv.FormatInt(id, 10),
+	})
+}
+
+func (s *S3Server) handleUploadPart(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err    error
+		id     int64
+		number int
+		etag   string
+	)
+	if number, err = strconv.Atoi(req.URL.Query().Get("partNumber")); err != nil || number < 1 || number > MaxPartNumber {
+		s3SendError(res, req, ErrS3InvalidArgument)
+		return
+	}
+	if id, err = s.parseUpload(req, r); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if etag, err = s.proxy.writePart(req, id, r.name, number); err != nil {
+		s3SendError(res, req, r.error(err))
+		return
+	}
+	res.Header().Set("ETag", etag)
+	res.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) handleCompleteUpload(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err      error
+		id       int64
+		body     []byte
+		complete = &s3CompleteMultipartUpload{}
+		numbers  []int
+		etags    []string
+		nwreq    *name.WriteRequest
+	)
+	if id, err = s.parseUpload(req, r); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if body, err = ioutil.ReadAll(io.LimitReader(req.Body, MaxCompleteBody)); err != nil {
+		s3SendError(res, req, r.error(ErrS3IncompleteBody))
+		return
+	}
+	if err = xml.Unmarshal(body, complete); err != nil || len(complete.Parts) < 1 {
+		s3SendError(res, req, ErrS3MalformedXML)
+		return
+	}
+	for i, v := range complete.Parts {
+		if i > 0 && v.PartNumber <= complete.Parts[i-1].PartNumber {
+			s3SendError(res, req, ErrS3InvalidPartOrder)
+			return
+		}
+		numbers = append(numbers, v.PartNumber)
+		etags = append(etags, strings.Trim(v.ETag, "\""))
+	}
+	if nwreq, err = s.proxy.completeUpload(id, r.name, numbers, etags, true); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if nwreq.Versioned {
+		res.Header().Set("X-VXFS-Version-Id", strconv.FormatInt(nwreq.Meta.Version, 10))
+	}
+	s3SendXml(res, http.StatusOK, &s3CompleteMultipartUploadResult{
+		Xmlns:    S3Namespace,
+		Location: "/" + r.bucket + "/" + r.key,
+		Bucket:   r.bucket,
+		Key:      r.key,
+		ETag:     "\"" + nwreq.Meta.ETag + "\"",
+	})
+}
+
+func (s *S3Server) handleAbortUpload(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err error
+		id  int64
+	)
+	if id, err = s.parseUpload(req, r); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if err = s.proxy.abortUpload(id, r.name); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (s *S3Server) handleListParts(res http.ResponseWriter, req *http.Request, r *s3Request) {
+	var (
+		err    error
+		id     int64
+		parts  []name.NameEntry
+		query  = req.URL.Query()
+		result = &s3ListPartsResult{
+			Xmlns:    S3Namespace,
+			Bucket:   r.bucket,
+			Key:      r.key,
+			UploadId: query.Get("uploadId"),
+			MaxParts: MaxS3ListKeys,
+		}
+	)
+	if id, err = s.parseUpload(req, r); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	if value := query.Get("max-parts"); len(value) > 0 {
+		if result.MaxParts, err = strconv.Atoi(value); err != nil || result.MaxParts < 1 {
+			s3SendError(res, req, ErrS3InvalidArgument)
+			return
+		}
+		if result.MaxParts > MaxS3ListKeys {
+			result.MaxParts = MaxS3ListKeys
+		}
+	}
+	if value := query.Get("part-number-marker"); len(value) > 0 {
+		if result.PartNumberMarker, err = strconv.Atoi(value); err != nil || result.PartNumberMarker < 0 {
+			s3SendError(res, req, ErrS3InvalidArgument)
+			return
+		}
+	}
+	if parts, result.IsTruncated, err = s.proxy.listParts(id, result.PartNumberMarker, result.MaxParts); err != nil {
+		s3SendError(res, req, err)
+		return
+	}
+	for _, v := range parts {
+		result.Parts = append(result.Parts, s3Part{
+			PartNumber:   partNumberOf(v.Name),
+			ETag:         "\"" + v.Meta.ETag + "\"",
+			LastModified: s3Time(v.Meta.Mtime),
+			Size:         v.Meta.Size,
+		})
+	}
+	if len(result.Parts) > 0 {
+		result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
+	}
+	s3SendXml(res, http.StatusOK, result)
+}
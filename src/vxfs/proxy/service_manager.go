@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"vxfs/dao/name"
 	"vxfs/dao/store"
@@ -10,11 +13,19 @@ import (
 )
 
 type NameService struct {
+	live   bool
 	stime  int64
 	stats  name.NameStats
 	client *libs.RpcClient
 }
 
+// the highest term of the name servers was sent with the stats and writes, to stop the old leader, by the atomic
+type NameShard struct {
+	id       int32
+	term     int64
+	services []*NameService
+}
+
 type StoreService struct {
 	id     int32
 	stime  int64
@@ -30,9 +41,24 @@ type ServiceManager struct {
 	storeIndexFreeMB uint64
 
 	nameLock      sync.RWMutex
-	nameService   *NameService
+	nameFile      string
+	nameMtime     time.Time
+	nameMap       *ShardMap
+	nameShards    map[int32]*NameShard
 	storeLock     sync.RWMutex
 	storeServices map[int32]*StoreService
+
+	bucketMode   bool
+	bucketLock   sync.RWMutex
+	buckets      map[string]*name.Bucket
+	bucketUsages map[string]name.NameUsage
+
+	quotaLock     sync.RWMutex
+	quotas        []Quota
+	quotaUsages   map[string]name.NameUsage
+	quotaRound    int64
+	quotaPending  map[string]name.NameUsage
+	quotaFlushing map[string]name.NameUsage
 }
 
 func NewServiceManager(nameDataFreeMB int, storeDataFreeMB int, storeIndexFreeMB int, statsRefresh int) (s *ServiceManager) {
@@ -45,17 +71,81 @@ func NewServiceManager(nameDataFreeMB int, storeDataFreeMB int, storeIndexFreeMB
 	return
 }
 
-func (s *ServiceManager) SetNameService(address string) (err error) {
+func (s *ServiceManager) SetShardMap(m *ShardMap, file string) (err error) {
 	s.nameLock.Lock()
 	defer s.nameLock.Unlock()
 
-	if s.nameService != nil {
+	if s.nameMap != nil && m.Version < s.nameMap.Version {
 		err = ErrInvalidatePrameter
 		return
 	}
-	nameService := &NameService{}
-	nameService.client = libs.NetRpcClient(address)
-	s.nameService = nameService
+
+	olds := map[string]*NameService{}
+	for _, shard := range s.nameShards {
+		for _, v := range shard.services {
+			olds[v.client.Address] = v
+		}
+	}
+	shards := make(map[int32]*NameShard)
+	for _, config := range m.Shards {
+		shard := &NameShard{id: config.Id}
+		for _, address := range config.Servers {
+			nameService, ok := olds[address]
+			if ok {
+				delete(olds, address)
+			} else {
+				nameService = &NameService{}
+				nameService.client = libs.NetRpcClient(address)
+			}
+			shard.services = append(shard.services, nameService)
+		}
+		shards[config.Id] = shard
+	}
+	for _, v := range olds {
+		v.client.Close()
+	}
+
+	s.nameMap = m
+	s.nameShards = shards
+	if len(file) > 0 {
+		s.nameFile = file
+		if stat, err1 := os.Stat(file); err1 == nil {
+			s.nameMtime = stat.ModTime()
+		}
+	}
+	return
+}
+
+func (s *ServiceManager) reloadShardMap() {
+	var (
+		err  error
+		m    *ShardMap
+		stat os.FileInfo
+	)
+	if len(s.nameFile) < 1 {
+		return
+	}
+	if stat, err = os.Stat(s.nameFile); err != nil || stat.ModTime().Equal(s.nameMtime) {
+		return
+	}
+	if m, err = LoadShardMap(s.nameFile); err != nil {
+		glog.Warningf("ShardMap(%s) reload error(%v)\n", s.nameFile, err)
+		return
+	}
+	if err = s.SetShardMap(m, s.nameFile); err != nil {
+		glog.Warningf("ShardMap(%s) version(%d) apply error(%v)\n", s.nameFile, m.Version, err)
+		return
+	}
+	glog.Infof("ShardMap(%s) version(%d) reloaded\n", s.nameFile, m.Version)
+}
+
+func (s *ServiceManager) getNameShards() (shards []*NameShard) {
+	s.nameLock.RLock()
+	defer s.nameLock.RUnlock()
+
+	for _, shard := range s.nameShards {
+		shards = append(shards, shard)
+	}
 	return
 }
 
@@ -82,18 +172,34 @@ func (s *ServiceManager) Startup() {
 func (s *ServiceManager) refreshStats() {
 	var (
 		err  error
-		nreq = &name.StatsRequest{}
-		nres = &name.StatsResponse{}
 		sreq = &store.StatsRequest{}
-		sres = &store.StatsResponse{}
 	)
-	if err = s.nameService.client.Call("NameService.Stats", nreq, nres); err != nil {
-		glog.Warningf("NameService Stats error(%v)\n", err)
-	} else {
-		s.nameService.stats = nres.Stats
-		s.nameService.stime = time.Now().Unix()
+	s.reloadShardMap()
+	if s.bucketMode {
+		s.refreshBuckets()
+	}
+	s.refreshQuotas()
+
+	// gob skips zero values, so never decode into a used response
+	for _, shard := range s.getNameShards() {
+		nreq := &name.StatsRequest{Term: atomic.LoadInt64(&shard.term)}
+		for _, nameService := range shard.services {
+			nres := &name.StatsResponse{}
+			if err = nameService.client.Call("NameService.Stats", nreq, nres); err != nil {
+				glog.Warningf("NameService(%s) Stats error(%v)\n", nameService.client.Address, err)
+				nameService.live = false
+			} else {
+				nameService.stats = nres.Stats
+				nameService.stime = time.Now().Unix()
+				nameService.live = true
+				if nres.Stats.Term > atomic.LoadInt64(&shard.term) {
+					atomic.StoreInt64(&shard.term, nres.Stats.Term)
+				}
+			}
+		}
 	}
 	for id, storeService := range s.storeServices {
+		sres := &store.StatsResponse{}
 		if err = storeService.client.Call("StoreService.Stats", sreq, sres); err != nil {
 			glog.Warningf("StoreService(%d) Stats error(%v)\n", id, err)
 		} else {
@@ -103,19 +209,72 @@ func (s *ServiceManager) refreshStats() {
 	}
 }
 
-func (s *ServiceManager) getNameClient() (client *libs.RpcClient, err error) {
+func (s *ServiceManager) getNameClient(path string, write bool) (client *libs.RpcClient, leader bool, err error) {
+	s.nameLock.RLock()
+	defer s.nameLock.RUnlock()
+
+	if s.nameMap == nil {
+		err = ErrNameServiceNoLive
+		return
+	}
+
+	return s.getShardClient(s.nameShards[s.nameMap.Locate(path)], write)
+}
+
+// the leader of the name and the term known by the proxy, the writes carried the term
+func (s *ServiceManager) getLeaderClient(path string) (client *libs.RpcClient, term int64, err error) {
 	s.nameLock.RLock()
 	defer s.nameLock.RUnlock()
 
-	if s.nameService == nil {
+	if s.nameMap == nil {
 		err = ErrNameServiceNoLive
 		return
 	}
-	if s.nameService.stime > 0 && s.nameService.stats.DataFreeMB < s.nameDataFreeMB {
-		err = ErrNameServiceNoSpace
+	shard := s.nameShards[s.nameMap.Locate(path)]
+	if client, _, err = s.getShardClient(shard, true); err != nil {
 		return
 	}
-	client = s.nameService.client
+	term = atomic.LoadInt64(&shard.term)
+	return
+}
+
+func (s *ServiceManager) getShardClient(shard *NameShard, write bool) (client *libs.RpcClient, leader bool, err error) {
+	var (
+		count         = 0
+		lives         = make([]*NameService, len(shard.services))
+		leaderService *NameService
+	)
+	// the leader of the highest term, the old one may not know the new term yet
+	for _, v := range shard.services {
+		if v.stime > 0 && v.stats.Leader && (leaderService == nil || v.stats.Term > leaderService.stats.Term) {
+			leaderService = v
+		}
+		if v.live {
+			lives[count] = v
+			count += 1
+		}
+	}
+	// before the first stats, the single name server was the leader
+	if leaderService == nil && len(shard.services) == 1 {
+		leaderService = shard.services[0]
+	}
+
+	if write || count < 1 {
+		if leaderService == nil {
+			err = ErrNameServiceNoLive
+			return
+		}
+		if leaderService.stime > 0 && leaderService.stats.DataFreeMB < s.nameDataFreeMB {
+			err = ErrNameServiceNoSpace
+			return
+		}
+		client = leaderService.client
+		leader = true
+		return
+	}
+	nameService := lives[libs.Rand.Intn(count)]
+	client = nameService.client
+	leader = nameService == leaderService
 	return
 }
 
@@ -135,12 +294,16 @@ func (s *ServiceManager) getStoreClient(sid int32) (client *libs.RpcClient, err
 	return
 }
 
-func (s *ServiceManager) GetSid(size int64) (sid int32, err error) {
+// the store server in the pool, or any store server without pool
+func (s *ServiceManager) GetSid(size int64, pool []int32) (sid int32, err error) {
 	count := 0
 	frees := make([]*StoreService, len(s.storeServices))
 
 	s.storeLock.RLock()
 	for _, v := range s.storeServices {
+		if len(pool) > 0 && !inSids(pool, v.id) {
+			continue
+		}
 		if v.stime == 0 || (v.stats.DataFreeMB > s.storeDataFreeMB && v.stats.IndexFreeMB > s.storeIndexFreeMB) {
 			frees[count] = v
 			count += 1
@@ -148,7 +311,7 @@ func (s *ServiceManager) GetSid(size int64) (sid int32, err error) {
 	}
 	s.storeLock.RUnlock()
 
-	if count < 0 {
+	if count < 1 {
 		err = ErrStoreServiceNoSpace
 	} else if count == 1 {
 		sid = frees[0].id
@@ -158,17 +321,55 @@ func (s *ServiceManager) GetSid(size int64) (sid int32, err error) {
 	return
 }
 
+func inSids(sids []int32, sid int32) bool {
+	for _, v := range sids {
+		if v == sid {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *ServiceManager) ReadName(req *name.ReadRequest, res *name.ReadResponse) (err error) {
-	var client *libs.RpcClient
-	if client, err = s.getNameClient(); err != nil {
+	var (
+		leader bool
+		client *libs.RpcClient
+	)
+	if client, leader, err = s.getNameClient(req.Name, false); err != nil {
 		return
 	}
-	return client.Call("NameService.Read", req, res)
+	err = client.Call("NameService.Read", req, res)
+	// a follower may lag behind or gone, so confirm on the leader
+	if !leader && err != nil {
+		if client, _, err = s.getNameClient(req.Name, true); err != nil {
+			return
+		}
+		err = client.Call("NameService.Read", req, res)
+	}
+	return
+}
+
+func (s *ServiceManager) VersionsName(req *name.VersionsRequest, res *name.VersionsResponse) (err error) {
+	var (
+		leader bool
+		client *libs.RpcClient
+	)
+	if client, leader, err = s.getNameClient(req.Name, false); err != nil {
+		return
+	}
+	err = client.Call("NameService.Versions", req, res)
+	if !leader && err != nil {
+		if client, _, err = s.getNameClient(req.Name, true); err != nil {
+			return
+		}
+		err = client.Call("NameService.Versions", req, res)
+	}
+	return
 }
 
 func (s *ServiceManager) WriteName(req *name.WriteRequest, res *name.WriteResponse) (err error) {
 	var client *libs.RpcClient
-	if client, err = s.getNameClient(); err != nil {
+	if client, req.Term, err = s.getLeaderClient(req.Name); err != nil {
 		return
 	}
 	return client.Call("NameService.Write", req, res)
@@ -176,12 +377,114 @@ func (s *ServiceManager) WriteName(req *name.WriteRequest, res *name.WriteRespon
 
 func (s *ServiceManager) DeleteName(req *name.DeleteRequest, res *name.DeleteResponse) (err error) {
 	var client *libs.RpcClient
-	if client, err = s.getNameClient(); err != nil {
+	if client, req.Term, err = s.getLeaderClient(req.Name); err != nil {
 		return
 	}
 	return client.Call("NameService.Delete", req, res)
 }
 
+func (s *ServiceManager) SameShard(path string, other string) bool {
+	s.nameLock.RLock()
+	defer s.nameLock.RUnlock()
+
+	if s.nameMap == nil {
+		return false
+	}
+	return s.nameMap.Locate(path) == s.nameMap.Locate(other)
+}
+
+func (s *ServiceManager) RenameName(req *name.RenameRequest, res *name.RenameResponse) (err error) {
+	var client *libs.RpcClient
+	if client, req.Term, err = s.getLeaderClient(req.Name); err != nil {
+		return
+	}
+	return client.Call("NameService.Rename", req, res)
+}
+
+func (s *ServiceManager) WatchName(shard int32, req *name.WatchRequest, res *name.WatchResponse) (err error) {
+	var client *libs.RpcClient
+	s.nameLock.RLock()
+	if s.nameMap == nil {
+		err = ErrNameServiceNoLive
+	} else if nameShard, ok := s.nameShards[shard]; !ok {
+		err = ErrInvalidatePrameter
+	} else {
+		client, _, err = s.getShardClient(nameShard, true)
+	}
+	s.nameLock.RUnlock()
+	if err != nil {
+		return
+	}
+	return client.Call("NameService.Watch", req, res)
+}
+
+func (s *ServiceManager) NameShards() (shards []int32) {
+	s.nameLock.RLock()
+	defer s.nameLock.RUnlock()
+
+	for id := range s.nameShards {
+		shards = append(shards, id)
+	}
+	return
+}
+
+// a name server of every shard
+func (s *ServiceManager) getShardClients(leader bool) (clients []*libs.RpcClient, err error) {
+	var client *libs.RpcClient
+	s.nameLock.RLock()
+	defer s.nameLock.RUnlock()
+
+	if s.nameMap == nil {
+		err = ErrNameServiceNoLive
+		return
+	}
+	for _, shard := range s.nameShards {
+		if client, _, err = s.getShardClient(shard, leader); err != nil {
+			return
+		}
+		clients = append(clients, client)
+	}
+	return
+}
+
+// merge the names of all shards in order, the leaders were fresh than followers
+func (s *ServiceManager) ListName(req *name.ListRequest, res *name.ListResponse, leader bool) (err error) {
+	var (
+		clients []*libs.RpcClient
+		bound   string
+		bounded bool
+	)
+	if clients, err = s.getShardClients(leader); err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		r := &name.ListResponse{}
+		if err = client.Call("NameService.List", req, r); err != nil {
+			return
+		}
+		res.Entries = append(res.Entries, r.Entries...)
+		// the names after the last one of a partial shard were unknown
+		if r.More && len(r.Entries) > 0 {
+			if last := r.Entries[len(r.Entries)-1].Name; !bounded || last < bound {
+				bound = last
+				bounded = true
+			}
+		}
+	}
+	sort.Slice(res.Entries, func(i, j int) bool { return res.Entries[i].Name < res.Entries[j].Name })
+	if bounded {
+		count := sort.Search(len(res.Entries), func(i int) bool { return res.Entries[i].Name > bound })
+		res.Entries = res.Entries[:count]
+		res.More = true
+	}
+	if req.Limit > 0 && len(res.Entries) > req.Limit {
+		res.Entries = res.Entries[:req.Limit]
+		res.More = true
+	}
+	return
+}
+
 func (s *ServiceManager) ReadStore(sid int32, req *store.ReadRequest, res *store.ReadResponse) (err error) {
 	var client *libs.RpcClient
 	if client, err = s.getStoreClient(sid); err != nil {
@@ -206,13 +509,25 @@ func (s *ServiceManager) DeleteStore(sid int32, req *store.DeleteRequest, res *s
 	return client.Call("StoreService.Delete", req, res)
 }
 
+func (s *ServiceManager) UndeleteStore(sid int32, req *store.UndeleteRequest, res *store.UndeleteResponse) (err error) {
+	var client *libs.RpcClient
+	if client, err = s.getStoreClient(sid); err != nil {
+		return
+	}
+	return client.Call("StoreService.Undelete", req, res)
+}
+
 func (s *ServiceManager) Cleanup() {
 	s.ticker.Stop()
 
 	s.nameLock.Lock()
-	if s.nameService != nil {
-		s.nameService.client.Close()
-		s.nameService = nil
+	if s.nameShards != nil {
+		for _, shard := range s.nameShards {
+			for _, v := range shard.services {
+				v.client.Close()
+			}
+		}
+		s.nameShards = nil
 	}
 	s.nameLock.Unlock()
 
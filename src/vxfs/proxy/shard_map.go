@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"vxfs/libs"
+)
+
+const (
+	DefaultShardVnodes = 100
+)
+
+type ShardConfig struct {
+	Id      int32    `json:"id"`
+	Servers []string `json:"servers"`
+}
+
+type ShardMap struct {
+	Version int64         `json:"version"`
+	Vnodes  int           `json:"vnodes"`
+	Shards  []ShardConfig `json:"shards"`
+
+	ring  []uint32
+	owner map[uint32]int32
+}
+
+func NewShardMap(servers []string) (m *ShardMap, err error) {
+	m = &ShardMap{
+		Version: 1,
+		Vnodes:  DefaultShardVnodes,
+		Shards: []ShardConfig{
+			{Id: 1, Servers: servers},
+		},
+	}
+	if err = m.init(); err != nil {
+		m = nil
+		return
+	}
+	return
+}
+
+func LoadShardMap(file string) (m *ShardMap, err error) {
+	var body string
+	if body, err = libs.ReadTextFile(file); err != nil {
+		return
+	}
+	m = &ShardMap{}
+	if err = json.Unmarshal([]byte(body), m); err != nil {
+		m = nil
+		return
+	}
+	if err = m.init(); err != nil {
+		m = nil
+		return
+	}
+	return
+}
+
+func (m *ShardMap) init() (err error) {
+	if m.Vnodes < 1 {
+		m.Vnodes = DefaultShardVnodes
+	}
+	if len(m.Shards) < 1 {
+		return ErrShardMapFormat
+	}
+	addresses := map[string]bool{}
+	ids := map[int32]bool{}
+	m.owner = make(map[uint32]int32)
+	m.ring = make([]uint32, 0, len(m.Shards)*m.Vnodes)
+	for _, shard := range m.Shards {
+		if shard.Id < 1 || len(shard.Servers) < 1 || ids[shard.Id] {
+			return ErrShardMapFormat
+		}
+		ids[shard.Id] = true
+		for _, address := range shard.Servers {
+			if !libs.IsStrictHostPort(address) || addresses[address] {
+				return ErrShardMapFormat
+			}
+			addresses[address] = true
+		}
+		for i := 0; i < m.Vnodes; i++ {
+			hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d-%d", shard.Id, i)))
+			if _, ok := m.owner[hash]; ok {
+				continue
+			}
+			m.owner[hash] = shard.Id
+			m.ring = append(m.ring, hash)
+		}
+	}
+	sort.Slice(m.ring, func(i, j int) bool { return m.ring[i] < m.ring[j] })
+	return
+}
+
+func (m *ShardMap) Locate(name string) int32 {
+	hash := crc32.ChecksumIEEE([]byte(name))
+	i := sort.Search(len(m.ring), func(i int) bool { return m.ring[i] >= hash })
+	if i >= len(m.ring) {
+		i = 0
+	}
+	return m.owner[m.ring[i]]
+}
+
+func (m *ShardMap) Shard(id int32) *ShardConfig {
+	for i := range m.Shards {
+		if m.Shards[i].Id == id {
+			return &m.Shards[i]
+		}
+	}
+	return nil
+}
+
+func (m *ShardMap) Save(file string) (err error) {
+	var body []byte
+	if body, err = json.MarshalIndent(m, "", "  "); err != nil {
+		return
+	}
+	tmpFile := file + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, body, libs.ModeFile); err != nil {
+		return
+	}
+	return os.Rename(tmpFile, file)
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"vxfs/dao/name"
+	"vxfs/libs"
+)
+
+const (
+	MaxTagCount       = 10
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+)
+
+func checkTags(tags map[string]string) error {
+	if len(tags) > MaxTagCount {
+		return ErrHttpTags
+	}
+	for k, v := range tags {
+		if len(k) < 1 || len(k) > MaxTagKeyLength || len(v) > MaxTagValueLength || strings.ContainsAny(k, ":=&") {
+			return ErrHttpTags
+		}
+	}
+	return nil
+}
+
+// key1=value1&key2=value2
+func parseTags(value string) (tags map[string]string, err error) {
+	var values url.Values
+	if values, err = url.ParseQuery(value); err != nil {
+		err = ErrHttpTags
+		return
+	}
+	tags = make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) != 1 {
+			err = ErrHttpTags
+			return
+		}
+		tags[k] = v[0]
+	}
+	err = checkTags(tags)
+	return
+}
+
+func formatTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func (s *ServiceManager) TagName(req *name.TagRequest, res *name.TagResponse) (err error) {
+	var client *libs.RpcClient
+	if client, req.Term, err = s.getLeaderClient(req.Name); err != nil {
+		return
+	}
+	return client.Call("NameService.Tag", req, res)
+}
+
+// GET the tags, PUT a json object to replace them, DELETE to clear them
+func (s *ProxyServer) handleTagging(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		body  []byte
+		xdata = map[string]interface{}{}
+
+		ntreq = &name.TagRequest{}
+		nreq  = &name.ReadRequest{}
+		nres  = &name.ReadResponse{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	if ntreq.Name, err = s.parseName(req); err != nil {
+		return
+	}
+	switch req.Method {
+	case "PUT":
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			err = ErrHttpTags
+			return
+		}
+		req.Body.Close()
+		if err = json.Unmarshal(body, &ntreq.Tags); err != nil {
+			err = ErrHttpTags
+			return
+		}
+		if err = checkTags(ntreq.Tags); err != nil {
+			return
+		}
+		// the tags were kept in the name meta with the headers
+		nreq.Name = ntreq.Name
+		if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+			return
+		}
+		nres.Meta.Tags = ntreq.Tags
+		if err = checkNameMeta(&nres.Meta); err != nil {
+			return
+		}
+		fallthrough
+	case "DELETE":
+		err = s.serviceManager.TagName(ntreq, &name.TagResponse{})
+	default:
+		nreq.Name = ntreq.Name
+		if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+			return
+		}
+		tags := nres.Meta.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		xdata["tags"] = tags
+	}
+}
+
+// the files under the path with all the tags, tag=key:value
+func (s *ProxyServer) handleTagged(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		xdata = map[string]interface{}{}
+		query = req.URL.Query()
+
+		nlreq = &name.ListRequest{}
+		nlres = &name.ListResponse{}
+	)
+	defer httpSendJsonData(res, &err, xdata)
+
+	nlreq.Prefix = s.namePolicy.Transform(req.URL.Path[1:])
+	nlreq.Marker = query.Get("marker")
+	for _, tag := range query["tag"] {
+		if i := strings.Index(tag, ":"); i < 1 {
+			err = ErrHttpTags
+			return
+		}
+		nlreq.Tags = append(nlreq.Tags, tag)
+	}
+	if value := query.Get("limit"); len(value) > 0 {
+		if nlreq.Limit, err = strconv.Atoi(value); err != nil {
+			err = ErrHttpListParam
+			return
+		}
+	}
+	if err = s.serviceManager.ListName(nlreq, nlres, false); err != nil {
+		return
+	}
+
+	files := make([]map[string]interface{}, 0, len(nlres.Entries))
+	for _, v := range nlres.Entries {
+		// the trashed files keep their tags
+		if isTrash(v.Name) {
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"name":  v.Name,
+			"size":  v.Meta.Size,
+			"mime":  v.Meta.Mime,
+			"mtime": v.Meta.Mtime,
+			"tags":  v.Meta.Tags,
+		})
+	}
+	xdata["files"] = files
+	if nlres.More && len(nlres.Entries) > 0 {
+		xdata["marker"] = nlres.Entries[len(nlres.Entries)-1].Name
+	}
+}
@@ -0,0 +1,401 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/dao/store"
+	"vxfs/libs"
+	"vxfs/libs/glog"
+)
+
+// the thumbnails were cached as ".thumb/<etag>/<width>x<height>-<fit>" names, expired and reaped later
+const (
+	ThumbPrefix         = ".thumb/"
+	ThumbExpire         = 30 * 24 * 3600
+	ThumbJpegQuality    = 85
+	MaxThumbDimension   = 4096
+	MaxThumbSource      = 64 * 1024 * 1024
+	DefaultThumbPixels  = 16 * 1000 * 1000
+	DefaultThumbWorkers = 4
+)
+
+var thumbFormats = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// the fit of both width and height, "contain" in the box, "cover" the box and crop the center, or "fill" the box
+type thumbParam struct {
+	width  int
+	height int
+	fit    string
+}
+
+func isThumbName(path string) bool {
+	return strings.HasPrefix(path, ThumbPrefix)
+}
+
+func thumbName(etag string, p *thumbParam) string {
+	return fmt.Sprintf("%s%s/%dx%d-%s", ThumbPrefix, etag, p.width, p.height, p.fit)
+}
+
+// the sizes of the cached thumbnails, "<width>x<height>,...", the 0 for the unknown width or height
+func ParseThumbSizes(text string) (sizes map[string]bool, err error) {
+	sizes = make(map[string]bool)
+	for _, v := range strings.Split(text, ",") {
+		var width, height int
+		if len(v) < 1 {
+			continue
+		}
+		if _, err = fmt.Sscanf(v, "%dx%d", &width, &height); err != nil || thumbSize(width, height) != v ||
+			width < 0 || height < 0 || width+height < 1 || width > MaxThumbDimension || height > MaxThumbDimension {
+			sizes = nil
+			err = ErrInvalidatePrameter
+			return
+		}
+		sizes[v] = true
+	}
+	return
+}
+
+func thumbSize(width int, height int) string {
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// the thumbnails were made by the workers, and the source images were not larger than the pixels, 0 workers for disable,
+// only the thumbnails of the sizes were cached, the others were made on every request
+func (s *ProxyServer) SetThumbnails(maxPixels int64, workers int, sizes map[string]bool) {
+	s.thumbPixels = maxPixels
+	s.thumbSizes = sizes
+	if workers > 0 {
+		s.thumbSlots = make(chan struct{}, workers)
+	}
+}
+
+func (s *ProxyServer) thumbRequested(req *http.Request) bool {
+	if s.thumbSlots == nil {
+		return false
+	}
+	query := req.URL.Query()
+	return len(query.Get("w")) > 0 || len(query.Get("h")) > 0
+}
+
+func parseThumbParam(req *http.Request) (p *thumbParam, err error) {
+	query := req.URL.Query()
+	p = &thumbParam{fit: query.Get("fit")}
+	for key, size := range map[string]*int{"w": &p.width, "h": &p.height} {
+		value := query.Get(key)
+		if len(value) < 1 {
+			continue
+		}
+		if *size, err = strconv.Atoi(value); err != nil || *size < 1 || *size > MaxThumbDimension {
+			p = nil
+			err = ErrHttpThumbParam
+			return
+		}
+	}
+	switch p.fit {
+	case "":
+		p.fit = "contain"
+	case "contain", "cover", "fill":
+	default:
+		p = nil
+		err = ErrHttpThumbParam
+	}
+	return
+}
+
+// the size of the scaled image and the crop of its center, the thumbnail was not larger than the source
+func (p *thumbParam) geometry(width int, height int) (scaled image.Point, crop image.Point) {
+	w, h := float64(p.width), float64(p.height)
+	if p.width == 0 {
+		w = float64(width) * h / float64(height)
+	}
+	if p.height == 0 {
+		h = float64(height) * w / float64(width)
+	}
+	sx, sy := w/float64(width), h/float64(height)
+	switch p.fit {
+	case "fill":
+		sx, sy = minFloat(sx, 1), minFloat(sy, 1)
+	case "cover":
+		sx = minFloat(maxFloat(sx, sy), 1)
+		sy = sx
+	default:
+		sx = minFloat(minFloat(sx, sy), 1)
+		sy = sx
+	}
+	scaled.X = maxInt(int(float64(width)*sx+0.5), 1)
+	scaled.Y = maxInt(int(float64(height)*sy+0.5), 1)
+	crop = scaled
+	if p.fit == "cover" {
+		crop.X = minInt(scaled.X, maxInt(int(w+0.5), 1))
+		crop.Y = minInt(scaled.Y, maxInt(int(h+0.5), 1))
+	}
+	return
+}
+
+func (s *ProxyServer) handleThumbnail(res http.ResponseWriter, req *http.Request) {
+	var (
+		err  error
+		p    *thumbParam
+		meta *FileMeta
+		data []byte
+
+		nreq = &name.ReadRequest{}
+		nres = &name.ReadResponse{}
+	)
+	defer func() {
+		if err != nil {
+			var mime string
+			data = nil
+			httpSendByteData(res, &err, &mime, &data)
+		}
+	}()
+
+	if nreq.Name, err = s.parseName(req); err != nil {
+		return
+	}
+	if nreq.Version, err = s.parseVersion(req); err != nil {
+		return
+	}
+	if p, err = parseThumbParam(req); err != nil {
+		return
+	}
+	if err = s.serviceManager.ReadName(nreq, nres); err != nil {
+		return
+	}
+	if !thumbFormats[nres.Meta.Mime] {
+		err = ErrThumbFormat
+		return
+	}
+	etag := nres.Meta.ETag
+	if len(etag) < 1 {
+		etag = strconv.FormatInt(nres.Key, 10)
+	}
+
+	// the cached thumbnail was sent as a file, and written in the store pool of the source
+	path := thumbName(etag, p)
+	cached := s.thumbSizes[thumbSize(p.width, p.height)]
+	if cached {
+		if err = s.sendFile(res, req, &name.ReadRequest{Name: path}); err == nil || !libs.IsErrorSame(err, name.ErrNameNotExists) {
+			return
+		}
+	}
+	if meta, data, err = s.makeThumbnail(req, nres, p); err != nil {
+		return
+	}
+	if cached {
+		b, _ := s.getBucket(nreq.Name)
+		if e := s.writeThumbnail(path, meta, data, bucketSids(b)); e != nil {
+			glog.Warningf("Thumbnail(%s) write error(%v)\n", path, e)
+		}
+	}
+
+	httpSetFileHeader(res, meta.ETag, time.Now().Unix())
+	setChecksumHeader(res.Header(), meta)
+	httpSendByteData(res, &err, &meta.Mime, &data)
+}
+
+// read the source in a worker slot, the jpeg was kept, the png and gif were encoded as png
+func (s *ProxyServer) makeThumbnail(req *http.Request, nres *name.ReadResponse, p *thumbParam) (meta *FileMeta, data []byte, err error) {
+	var (
+		src    image.Image
+		config image.Config
+		format string
+		buffer bytes.Buffer
+	)
+	if nres.Meta.Size > MaxThumbSource {
+		err = ErrThumbTooLarge
+		return
+	}
+	select {
+	case s.thumbSlots <- struct{}{}:
+		defer func() { <-s.thumbSlots }()
+	case <-req.Context().Done():
+		err = req.Context().Err()
+		return
+	}
+
+	if data, err = s.readFile(nres); err != nil {
+		return
+	}
+	if config, format, err = image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		err = ErrThumbFormat
+		return
+	}
+	if s.thumbPixels > 0 && int64(config.Width)*int64(config.Height) > s.thumbPixels {
+		err = ErrThumbTooLarge
+		return
+	}
+	if src, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+		err = ErrThumbFormat
+		return
+	}
+
+	bounds := src.Bounds()
+	scaled, crop := p.geometry(bounds.Dx(), bounds.Dy())
+	dst := resizeImage(src, scaled.X, scaled.Y)
+	offset := image.Pt((scaled.X-crop.X)/2, (scaled.Y-crop.Y)/2)
+	thumb := dst.SubImage(image.Rectangle{offset, offset.Add(crop)})
+
+	meta = &FileMeta{}
+	if format == "jpeg" {
+		meta.Mime = "image/jpeg"
+		err = jpeg.Encode(&buffer, thumb, &jpeg.Options{Quality: ThumbJpegQuality})
+	} else {
+		meta.Mime = "image/png"
+		err = png.Encode(&buffer, thumb)
+	}
+	if err != nil {
+		return
+	}
+	data = buffer.Bytes()
+	hash := newBodyHash()
+	hash.Write(data)
+	hash.fill(meta)
+	return
+}
+
+// the whole data of the file, or of its chunks
+func (s *ProxyServer) readFile(nres *name.ReadResponse) (data []byte, err error) {
+	var m *Manifest
+	if !nres.Meta.Chunked {
+		sres := &store.ReadResponse{}
+		if err = s.serviceManager.ReadStore(nres.Sid, &store.ReadRequest{Key: nres.Key}, sres); err != nil {
+			return
+		}
+		data = sres.Data
+		return
+	}
+	if m, err = s.readManifest(nres.Sid, nres.Key); err != nil {
+		return
+	}
+	data = make([]byte, 0, m.Size)
+	for _, c := range m.Chunks {
+		sres := &store.ReadResponse{}
+		if err = s.serviceManager.ReadStore(c.Sid, &store.ReadRequest{Key: c.Key}, sres); err != nil {
+			return
+		}
+		data = append(data, sres.Data...)
+	}
+	return
+}
+
+// the store data was written before the name, the thumbnail made by another request was kept
+func (s *ProxyServer) writeThumbnail(path string, meta *FileMeta, data []byte, pool []int32) (err error) {
+	var (
+		nwreq = &name.WriteRequest{Name: path}
+		swreq = &store.WriteRequest{Data: data}
+	)
+	if swreq.Meta, err = json.Marshal(meta); err != nil {
+		return
+	}
+	if nwreq.Key, err = s.keyMaker.NextId(); err != nil {
+		return
+	}
+	nwreq.Meta.Size = int64(len(data))
+	nwreq.Meta.Mime = meta.Mime
+	nwreq.Meta.ETag = meta.ETag
+	nwreq.Meta.MD5 = meta.MD5
+	nwreq.Meta.SHA256 = meta.SHA256
+	nwreq.Meta.Expires = time.Now().Unix() + ThumbExpire
+	if nwreq.Sid, err = s.serviceManager.GetSid(nwreq.Meta.Size, pool); err != nil {
+		return
+	}
+	swreq.Key = nwreq.Key
+	if err = s.serviceManager.WriteStore(nwreq.Sid, swreq, &store.WriteResponse{}); err != nil {
+		return
+	}
+	if err = s.writeName(nwreq, &name.WriteResponse{}); err != nil {
+		s.serviceManager.DeleteStore(nwreq.Sid, &store.DeleteRequest{Key: nwreq.Key}, &store.DeleteResponse{})
+		if libs.IsErrorSame(err, name.ErrNameExists) {
+			err = nil
+		}
+	}
+	return
+}
+
+// the area average of the source pixels, by the rows and then the columns
+func resizeImage(src image.Image, width int, height int) *image.RGBA {
+	bounds := src.Bounds()
+	from := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(from, from.Bounds(), src, bounds.Min, draw.Src)
+	if bounds.Dx() == width && bounds.Dy() == height {
+		return from
+	}
+
+	rows := image.NewRGBA(image.Rect(0, 0, width, bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < width; x++ {
+			x0, x1 := boxSpan(x, width, bounds.Dx())
+			averagePixels(rows.Pix[rows.PixOffset(x, y):], from.Pix[from.PixOffset(x0, y):], x1-x0, 4)
+		}
+	}
+	to := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := boxSpan(y, height, bounds.Dy())
+		for x := 0; x < width; x++ {
+			averagePixels(to.Pix[to.PixOffset(x, y):], rows.Pix[rows.PixOffset(x, y0):], y1-y0, rows.Stride)
+		}
+	}
+	return to
+}
+
+// the source span of the target index
+func boxSpan(i int, n int, size int) (from int, to int) {
+	from, to = i*size/n, (i+1)*size/n
+	if to <= from {
+		to = from + 1
+	}
+	return
+}
+
+// the average of the count pixels by the stride into the first pixel of dst
+func averagePixels(dst []byte, src []byte, count int, stride int) {
+	var sum [4]uint64
+	for i := 0; i < count; i++ {
+		for c := 0; c < 4; c++ {
+			sum[c] += uint64(src[i*stride+c])
+		}
+	}
+	for c := 0; c < 4; c++ {
+		dst[c] = uint8((sum[c] + uint64(count)/2) / uint64(count))
+	}
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"image"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseThumbParam(t *testing.T) {
+	tests := []struct {
+		query string
+		param *thumbParam
+		err   error
+	}{
+		{"w=200", &thumbParam{200, 0, "contain"}, nil},
+		{"h=100&fit=cover", &thumbParam{0, 100, "cover"}, nil},
+		{"w=200&h=100&fit=fill", &thumbParam{200, 100, "fill"}, nil},
+		{"w=4096&h=4096", &thumbParam{4096, 4096, "contain"}, nil},
+		{"w=0", nil, ErrHttpThumbParam},
+		{"w=-1", nil, ErrHttpThumbParam},
+		{"w=4097", nil, ErrHttpThumbParam},
+		{"h=abc", nil, ErrHttpThumbParam},
+		{"w=200&fit=stretch", nil, ErrHttpThumbParam},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://vxfs/a.jpg?"+tt.query, nil)
+		p, err := parseThumbParam(req)
+		if err != tt.err || !reflect.DeepEqual(p, tt.param) {
+			t.Errorf("parseThumbParam(%q) = %+v, %v, want %+v, %v", tt.query, p, err, tt.param, tt.err)
+		}
+	}
+}
+
+func TestThumbGeometry(t *testing.T) {
+	tests := []struct {
+		param  thumbParam
+		width  int
+		height int
+		scaled image.Point
+		crop   image.Point
+	}{
+		{thumbParam{200, 0, "contain"}, 800, 600, image.Pt(200, 150), image.Pt(200, 150)},
+		{thumbParam{0, 60, "contain"}, 800, 600, image.Pt(80, 60), image.Pt(80, 60)},
+		{thumbParam{200, 200, "contain"}, 800, 600, image.Pt(200, 150), image.Pt(200, 150)},
+		{thumbParam{200, 200, "cover"}, 800, 600, image.Pt(267, 200), image.Pt(200, 200)},
+		{thumbParam{200, 200, "fill"}, 800, 600, image.Pt(200, 200), image.Pt(200, 200)},
+		{thumbParam{1000, 300, "fill"}, 800, 600, image.Pt(800, 300), image.Pt(800, 300)},
+		{thumbParam{400, 0, "contain"}, 100, 50, image.Pt(100, 50), image.Pt(100, 50)},
+		{thumbParam{400, 400, "cover"}, 100, 50, image.Pt(100, 50), image.Pt(100, 50)},
+		{thumbParam{10, 0, "contain"}, 1000, 1, image.Pt(10, 1), image.Pt(10, 1)},
+	}
+	for _, tt := range tests {
+		scaled, crop := tt.param.geometry(tt.width, tt.height)
+		if scaled != tt.scaled || crop != tt.crop {
+			t.Errorf("%+v geometry(%d, %d) = %v, %v, want %v, %v", tt.param, tt.width, tt.height, scaled, crop, tt.scaled, tt.crop)
+		}
+	}
+}
+
+func TestParseThumbSizes(t *testing.T) {
+	tests := []struct {
+		text  string
+		sizes map[string]bool
+		err   error
+	}{
+		{"", map[string]bool{}, nil},
+		{"200x200", map[string]bool{"200x200": true}, nil},
+		{"200x200,400x0,0x100", map[string]bool{"200x200": true, "400x0": true, "0x100": true}, nil},
+		{"0x0", nil, ErrInvalidatePrameter},
+		{"4097x10", nil, ErrInvalidatePrameter},
+		{"-1x10", nil, ErrInvalidatePrameter},
+		{"200", nil, ErrInvalidatePrameter},
+		{"200x200x1", nil, ErrInvalidatePrameter},
+		{"0200x200", nil, ErrInvalidatePrameter},
+	}
+	for _, tt := range tests {
+		sizes, err := ParseThumbSizes(tt.text)
+		if err != tt.err || !reflect.DeepEqual(sizes, tt.sizes) {
+			t.Errorf("ParseThumbSizes(%q) = %v, %v, want %v, %v", tt.text, sizes, err, tt.sizes, tt.err)
+		}
+	}
+}
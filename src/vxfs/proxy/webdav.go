@@ -0,0 +1,716 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vxfs/dao/name"
+	"vxfs/libs"
+)
+
+// the directories were implicit, they exist with the names under them
+const (
+	DavPrefix      = "/dav/"
+	DavNamespace   = "DAV:"
+	DavMethods     = "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE, MKCOL, COPY, MOVE, LOCK, UNLOCK"
+	MaxDavBody     = 64 * 1024
+	MaxDavListKeys = 1000
+)
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength string          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	CreationDate  string          `xml:"D:creationdate,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+// the properties of the found ones, or the status of the failed ones
+type davResponse struct {
+	Href     string       `xml:"D:href"`
+	Propstat *davPropstat `xml:"D:propstat,omitempty"`
+	Status   string       `xml:"D:status,omitempty"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Xmlns     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davInner struct {
+	Inner string `xml:",innerxml"`
+}
+
+type davOwner struct {
+	Href string `xml:"D:href,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type davActiveLock struct {
+	LockType  davInner `xml:"D:locktype"`
+	LockScope davInner `xml:"D:lockscope"`
+	Depth     string   `xml:"D:depth"`
+	Owner     davOwner `xml:"D:owner"`
+	Timeout   string   `xml:"D:timeout"`
+	LockToken string   `xml:"D:locktoken>D:href"`
+	LockRoot  string   `xml:"D:lockroot>D:href"`
+}
+
+type davLockResult struct {
+	XMLName xml.Name      `xml:"D:prop"`
+	Xmlns   string        `xml:"xmlns:D,attr"`
+	Lock    davActiveLock `xml:"D:lockdiscovery>D:activelock"`
+}
+
+type davLockInfo struct {
+	LockScope davInner `xml:"lockscope"`
+	Owner     struct {
+		Href string `xml:"href"`
+		Text string `xml:",chardata"`
+	} `xml:"owner"`
+}
+
+func davHref(path string, dir bool) string {
+	u := &url.URL{Path: DavPrefix + path}
+	if dir && len(path) > 0 {
+		u.Path += "/"
+	}
+	return u.EscapedPath()
+}
+
+func davDisplayName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func davSendXml(res http.ResponseWriter, status int, v interface{}) {
+	data, _ := xml.Marshal(v)
+	res.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	res.WriteHeader(status)
+	res.Write([]byte(xml.Header))
+	res.Write(data)
+}
+
+func davErrorStatus(err error) (status int) {
+	status = errorToHttpStatus(err)
+	if libs.IsErrorSame(err, name.ErrNameExists) || err == ErrDavLockToken {
+		status = http.StatusPreconditionFailed
+	} else if libs.IsErrorSame(err, name.ErrNameVersion) || err == ErrHttpDestination {
+		status = http.StatusForbidden
+	} else if err == ErrHttpDavBody {
+		status = http.StatusUnsupportedMediaType
+	} else if err == ErrDavLocked {
+		status = http.StatusLocked
+	}
+	return
+}
+
+func davSendError(res http.ResponseWriter, err error) {
+	http.Error(res, err.Error(), davErrorStatus(err))
+}
+
+func davStatusResponse(path string, err error) davResponse {
+	status := davErrorStatus(err)
+	return davResponse{
+		Href:   davHref(path, false),
+		Status: fmt.Sprintf("HTTP/1.1 %d %s", status, http.StatusText(status)),
+	}
+}
+
+// the write of the path, or the tree under it, with the lock tokens of the If header
+func (s *ProxyServer) davConfirm(req *http.Request, path string, tree bool) error {
+	return s.davLocks.Confirm(path, tree, davIfTokens(req.Header.Get("If")))
+}
+
+func (s *ProxyServer) routeDav(res http.ResponseWriter, req *http.Request) {
+	var (
+		perm    byte
+		handler HttpHandler
+	)
+	switch req.Method {
+	case "OPTIONS":
+		handler = s.handleDavOptions
+	case "PROPFIND":
+		perm = PermRead
+		handler = s.handleDavPropfind
+	case "HEAD", "GET":
+		perm = PermRead
+		handler = s.handleDavGet
+	case "PUT":
+		perm = PermWrite
+		handler = s.handleDavPut
+	case "DELETE":
+		perm = PermDelete
+		handler = s.handleDavDelete
+	case "MKCOL":
+		perm = PermWrite
+		handler = s.handleDavMkcol
+	case "COPY":
+		perm = PermRead
+		handler = s.handleDavCopy
+	case "MOVE":
+		perm = PermDelete
+		handler = s.handleDavCopy
+	case "LOCK":
+		perm = PermWrite
+		handler = s.handleDavLock
+	case "UNLOCK":
+		perm = PermWrite
+		handler = s.handleDavUnlock
+	default:
+		res.Header().Set("Allow", DavMethods)
+		http.Error(res, "Access "+DavMethods, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if perm != 0 && !s.davAuthorized(res, req, strings.TrimPrefix(req.URL.Path, DavPrefix), perm) {
+		return
+	}
+	handler(res, req)
+}
+
+// the drives can't set the header, the key was also the password of the basic auth
+func (s *ProxyServer) davAuthorized(res http.ResponseWriter, req *http.Request, path string, perm byte) bool {
+	path = s.namePolicy.Transform(strings.TrimSuffix(path, "/"))
+	key := req.Header.Get("VXFS-SAFE-CODE")
+	if len(key) < 1 {
+		_, key, _ = req.BasicAuth()
+	}
+	bucket, _ := s.getBucket(path + "/")
+	if err := s.authorize(key, path, perm, bucket, false); err != nil {
+		if len(key) < 1 {
+			res.Header().Set("WWW-Authenticate", `Basic realm="vxfs"`)
+			http.Error(res, "Require Header `VXFS-SAFE-CODE` or the password", http.StatusUnauthorized)
+			return false
+		}
+		http.Error(res, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// the name of the path under the prefix, the system names were hidden, the root was empty
+func (s *ProxyServer) davPath(urlPath string) (path string, err error) {
+	path = strings.TrimSuffix(strings.TrimPrefix(urlPath, DavPrefix), "/")
+	if len(path) < 1 {
+		return
+	}
+	if path[0] == '.' {
+		path = ""
+		err = name.ErrNameNotExists
+		return
+	}
+	if strings.Contains(path, "//") || strings.Contains("/"+path+"/", "/./") || strings.Contains("/"+path+"/", "/../") {
+		path = ""
+		err = ErrHttpPathFormat
+		return
+	}
+	if path, err = s.namePolicy.Apply(path); err != nil {
+		return
+	}
+	// the top directories were the buckets
+	if s.bucketMode {
+		if bucket, _ := s.serviceManager.GetBucket(bucketOf(path + "/")); bucket == nil {
+			path = ""
+			err = ErrBucketNotExists
+			return
+		}
+	}
+	return
+}
+
+func (s *ProxyServer) parseDavDestination(req *http.Request) (to string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(req.Header.Get("Destination")); err != nil || !strings.HasPrefix(u.Path, DavPrefix) {
+		err = ErrHttpDestination
+		return
+	}
+	if to, err = s.davPath(u.Path); err != nil {
+		return
+	}
+	if !s.davWritable(to) {
+		err = ErrHttpDestination
+		return
+	}
+	return
+}
+
+// the files can't be in the root, or in the root of the buckets
+func (s *ProxyServer) davWritable(path string) bool {
+	return len(path) > 0 && (!s.bucketMode || strings.Contains(path, "/"))
+}
+
+// the file or the directory of the path, the directory with the trailing slash always exist
+func (s *ProxyServer) statDav(path string, collection bool) (nres *name.ReadResponse, dir bool, err error) {
+	if len(path) < 1 || (s.bucketMode && !strings.Contains(path, "/")) {
+		dir = true
+		return
+	}
+	nres = &name.ReadResponse{}
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: path}, nres); err == nil || !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		return
+	}
+	nres = nil
+	var (
+		entries  []name.NameEntry
+		prefixes []string
+	)
+	if entries, prefixes, _, _, err = s.listNames(path+"/", "", "/", 1); err != nil {
+		return
+	}
+	if len(entries) < 1 && len(prefixes) < 1 && !collection {
+		err = name.ErrNameNotExists
+		return
+	}
+	dir = true
+	return
+}
+
+// the names under the prefix in batches
+func (s *ProxyServer) walkDav(prefix string, fn func(v name.NameEntry) error) (err error) {
+	nlreq := &name.ListRequest{Prefix: prefix, Limit: MaxDeleteBatch}
+	for {
+		nlres := &name.ListResponse{}
+		if err = s.serviceManager.ListName(nlreq, nlres, true); err != nil {
+			return
+		}
+		for _, v := range nlres.Entries {
+			if err = fn(v); err != nil {
+				return
+			}
+			nlreq.Marker = v.Name
+		}
+		if !nlres.More || len(nlres.Entries) < 1 {
+			return
+		}
+	}
+}
+
+func (s *ProxyServer) deleteDav(path string, dir bool) error {
+	if !dir {
+		return s.deleteFile(&name.DeleteRequest{Name: path}, map[string]interface{}{})
+	}
+	return s.walkDav(path+"/", func(v name.NameEntry) error {
+		return s.deleteFile(&name.DeleteRequest{Name: v.Name}, map[string]interface{}{})
+	})
+}
+
+func davFileResponse(path string, meta *name.NameMeta) davResponse {
+	prop := davProp{
+		DisplayName:   davDisplayName(path),
+		ContentLength: strconv.FormatInt(meta.Size, 10),
+		ContentType:   meta.Mime,
+		ETag:          "\"" + meta.ETag + "\"",
+	}
+	if meta.Mtime > 0 {
+		prop.LastModified = time.Unix(meta.Mtime, 0).UTC().Format(http.TimeFormat)
+	}
+	if meta.Ctime > 0 {
+		prop.CreationDate = time.Unix(meta.Ctime, 0).UTC().Format(time.RFC3339)
+	}
+	return davResponse{
+		Href:     davHref(path, false),
+		Propstat: &davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+func davDirResponse(path string) davResponse {
+	prop := davProp{
+		DisplayName:  davDisplayName(path),
+		ResourceType: davResourceType{Collection: &struct{}{}},
+	}
+	return davResponse{
+		Href:     davHref(path, true),
+		Propstat: &davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+func (s *ProxyServer) handleDavOptions(res http.ResponseWriter, req *http.Request) {
+	header := res.Header()
+	header.Set("DAV", "1, 2")
+	header.Set("Allow", DavMethods)
+	header.Set("MS-Author-Via", "DAV")
+	res.WriteHeader(http.StatusOK)
+}
+
+// the depth infinity was served as 1, the requested properties were ignored
+func (s *ProxyServer) handleDavPropfind(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		path   string
+		dir    bool
+		nres   *name.ReadResponse
+		result = &davMultistatus{Xmlns: DavNamespace}
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(req.Body, MaxDavBody))
+	if nres, dir, err = s.statDav(path, strings.HasSuffix(req.URL.Path, "/")); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if !dir {
+		result.Responses = append(result.Responses, davFileResponse(path, &nres.Meta))
+		davSendXml(res, http.StatusMultiStatus, result)
+		return
+	}
+
+	result.Responses = append(result.Responses, davDirResponse(path))
+	if req.Header.Get("Depth") == "0" {
+		davSendXml(res, http.StatusMultiStatus, result)
+		return
+	}
+	if s.bucketMode && len(path) < 1 {
+		bs, _ := s.serviceManager.Buckets()
+		for _, b := range bs {
+			result.Responses = append(result.Responses, davDirResponse(b.Name))
+		}
+		davSendXml(res, http.StatusMultiStatus, result)
+		return
+	}
+	prefix := ""
+	if len(path) > 0 {
+		prefix = path + "/"
+	}
+	for marker, more := "", true; more; {
+		var (
+			entries  []name.NameEntry
+			prefixes []string
+		)
+		if entries, prefixes, marker, more, err = s.listNames(prefix, marker, "/", MaxDavListKeys); err != nil {
+			davSendError(res, err)
+			return
+		}
+		for _, v := range prefixes {
+			if len(prefix) > 0 || v[0] != '.' {
+				result.Responses = append(result.Responses, davDirResponse(strings.TrimSuffix(v, "/")))
+			}
+		}
+		for i := range entries {
+			if len(prefix) > 0 || entries[i].Name[0] != '.' {
+				result.Responses = append(result.Responses, davFileResponse(entries[i].Name, &entries[i].Meta))
+			}
+		}
+	}
+	davSendXml(res, http.StatusMultiStatus, result)
+}
+
+func (s *ProxyServer) handleDavGet(res http.ResponseWriter, req *http.Request) {
+	var (
+		err  error
+		path string
+		dir  bool
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if len(path) > 0 {
+		if err = s.sendFile(res, req, &name.ReadRequest{Name: path}); err == nil {
+			return
+		}
+		if !libs.IsErrorSame(err, name.ErrNameNotExists) {
+			davSendError(res, err)
+			return
+		}
+	}
+	if _, dir, err = s.statDav(path, false); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if dir {
+		res.Header().Set("Allow", "OPTIONS, PROPFIND, DELETE, COPY, MOVE")
+		http.Error(res, "Access the directory by PROPFIND", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *ProxyServer) handleDavPut(res http.ResponseWriter, req *http.Request) {
+	var (
+		err   error
+		nwreq = &name.WriteRequest{}
+	)
+	if nwreq.Name, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if !s.davWritable(nwreq.Name) || strings.HasSuffix(req.URL.Path, "/") {
+		http.Error(res, "Access PUT with a file", http.StatusMethodNotAllowed)
+		return
+	}
+	if err = s.davConfirm(req, nwreq.Name, false); err != nil {
+		davSendError(res, err)
+		return
+	}
+	// the replaced file was deleted
+	if err = s.serviceManager.ReadName(&name.ReadRequest{Name: nwreq.Name}, &name.ReadResponse{}); err == nil {
+		if !s.davAuthorized(res, req, nwreq.Name, PermDelete) {
+			return
+		}
+	} else if !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		davSendError(res, err)
+		return
+	}
+	if err = s.uploadFile(req, nwreq, true); err != nil {
+		davSendError(res, err)
+		return
+	}
+	res.Header().Set("ETag", "\""+nwreq.Meta.ETag+"\"")
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (s *ProxyServer) handleDavDelete(res http.ResponseWriter, req *http.Request) {
+	var (
+		err  error
+		path string
+		dir  bool
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if !s.davWritable(path) {
+		http.Error(res, "Access DELETE with a file or a directory", http.StatusForbidden)
+		return
+	}
+	if _, dir, err = s.statDav(path, false); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if err = s.davConfirm(req, path, dir); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if err = s.deleteDav(path, dir); err != nil {
+		davSendError(res, err)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// the directory was not kept until a file was put into it
+func (s *ProxyServer) handleDavMkcol(res http.ResponseWriter, req *http.Request) {
+	var (
+		err  error
+		path string
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if req.ContentLength > 0 {
+		davSendError(res, ErrHttpDavBody)
+		return
+	}
+	if !s.davWritable(path) {
+		http.Error(res, "Access MKCOL with a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, _, err = s.statDav(path, false); err == nil {
+		http.Error(res, "Access MKCOL with a new directory", http.StatusMethodNotAllowed)
+		return
+	} else if !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		davSendError(res, err)
+		return
+	}
+	res.WriteHeader(http.StatusCreated)
+}
+
+// the directory was copied or moved with all the names under it
+func (s *ProxyServer) handleDavCopy(res http.ResponseWriter, req *http.Request) {
+	var (
+		err    error
+		from   string
+		to     string
+		dir    bool
+		toDir  bool
+		exists bool
+		move   = req.Method == "MOVE"
+	)
+	if from, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if to, err = s.parseDavDestination(req); err != nil {
+		davSendError(res, err)
+		return
+	}
+	// the buckets were isolated
+	if !s.davWritable(from) || strings.HasPrefix(to+"/", from+"/") || strings.HasPrefix(from+"/", to+"/") ||
+		(s.bucketMode && bucketOf(from) != bucketOf(to)) {
+		davSendError(res, ErrHttpDestination)
+		return
+	}
+	if !s.davAuthorized(res, req, to, PermWrite) {
+		return
+	}
+	if _, dir, err = s.statDav(from, false); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if _, toDir, err = s.statDav(to, false); err == nil {
+		exists = true
+	} else if !libs.IsErrorSame(err, name.ErrNameNotExists) {
+		davSendError(res, err)
+		return
+	}
+	if err = s.davConfirm(req, to, dir || toDir); err == nil && move {
+		err = s.davConfirm(req, from, dir)
+	}
+	if err != nil {
+		davSendError(res, err)
+		return
+	}
+	if exists {
+		if req.Header.Get("Overwrite") == "F" {
+			davSendError(res, name.ErrNameExists)
+			return
+		}
+		if !s.davAuthorized(res, req, to, PermDelete) {
+			return
+		}
+		if err = s.deleteDav(to, toDir); err != nil {
+			davSendError(res, err)
+			return
+		}
+	}
+
+	transfer := func(from string, to string) (err error) {
+		if !move {
+			_, err = s.copyFile(&name.ReadRequest{Name: from}, to, 0)
+			return
+		}
+		if s.isVersioned(from) || s.isVersioned(to) {
+			return name.ErrNameVersion
+		}
+		return s.moveFile(from, to, 0)
+	}
+	// the failed names of the directory were in the multistatus, the others were done
+	result := &davMultistatus{Xmlns: DavNamespace}
+	if !dir {
+		err = transfer(from, to)
+	} else if move || req.Header.Get("Depth") != "0" {
+		err = s.walkDav(from+"/", func(v name.NameEntry) error {
+			if err := transfer(v.Name, to+v.Name[len(from):]); err != nil {
+				result.Responses = append(result.Responses, davStatusResponse(v.Name, err))
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		davSendError(res, err)
+		return
+	}
+	if len(result.Responses) > 0 {
+		davSendXml(res, http.StatusMultiStatus, result)
+		return
+	}
+	if exists {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	res.WriteHeader(http.StatusCreated)
+}
+
+// the lock of an unmapped path was kept for the following put, the refresh has no body and the token in the If header
+func (s *ProxyServer) handleDavLock(res http.ResponseWriter, req *http.Request) {
+	var (
+		err     error
+		id      int64
+		path    string
+		body    []byte
+		info    = &davLockInfo{}
+		l       = &davLock{infinite: true}
+		seconds = davLockSeconds(req.Header.Get("Timeout"))
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	if body, err = ioutil.ReadAll(io.LimitReader(req.Body, MaxDavBody)); err != nil {
+		davSendError(res, ErrHttpDavBody)
+		return
+	}
+	if len(body) < 1 {
+		var tokens []string
+		if tokens = davIfTokens(req.Header.Get("If")); len(tokens) < 1 {
+			davSendError(res, ErrDavLockToken)
+			return
+		}
+		if *l, err = s.davLocks.Refresh(tokens[0], path, seconds); err != nil {
+			davSendError(res, err)
+			return
+		}
+	} else {
+		if err = xml.Unmarshal(body, info); err != nil {
+			davSendError(res, ErrHttpDavBody)
+			return
+		}
+		if id, err = s.keyMaker.NextId(); err != nil {
+			davSendError(res, err)
+			return
+		}
+		l.token = fmt.Sprintf("opaquelocktoken:vxfs-%d", id)
+		l.root = path
+		l.infinite = req.Header.Get("Depth") != "0"
+		l.shared = strings.Contains(info.LockScope.Inner, "shared")
+		l.owner = davOwner{Href: info.Owner.Href, Text: strings.TrimSpace(info.Owner.Text)}
+		if err = s.davLocks.Lock(l, seconds); err != nil {
+			davSendError(res, err)
+			return
+		}
+		res.Header().Set("Lock-Token", "<"+l.token+">")
+	}
+
+	lock := davActiveLock{
+		LockType:  davInner{Inner: "<D:write/>"},
+		LockScope: davInner{Inner: "<D:exclusive/>"},
+		Depth:     "infinity",
+		Owner:     l.owner,
+		Timeout:   l.timeout(time.Now().Unix()),
+		LockToken: l.token,
+		LockRoot:  davHref(l.root, false),
+	}
+	if l.shared {
+		lock.LockScope.Inner = "<D:shared/>"
+	}
+	if !l.infinite {
+		lock.Depth = "0"
+	}
+	davSendXml(res, http.StatusOK, &davLockResult{Xmlns: DavNamespace, Lock: lock})
+}
+
+func (s *ProxyServer) handleDavUnlock(res http.ResponseWriter, req *http.Request) {
+	var (
+		err  error
+		path string
+	)
+	if path, err = s.davPath(req.URL.Path); err != nil {
+		davSendError(res, err)
+		return
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(req.Header.Get("Lock-Token")), "<"), ">")
+	if err = s.davLocks.Unlock(token, path); err != nil {
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
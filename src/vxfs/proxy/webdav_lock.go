@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the locks were kept by the proxy server until unlocked or timed out
+const (
+	MaxDavLockSeconds = 3600
+)
+
+type davLock struct {
+	token    string
+	root     string
+	infinite bool
+	shared   bool
+	owner    davOwner
+	expires  int64
+}
+
+type davLockStore struct {
+	lock  sync.Mutex
+	locks map[string]*davLock
+}
+
+func newDavLockStore() *davLockStore {
+	return &davLockStore{locks: make(map[string]*davLock)}
+}
+
+// the path was the root, or under it, the root of the dav was ""
+func davUnder(path string, root string) bool {
+	return len(root) < 1 || path == root || strings.HasPrefix(path, root+"/")
+}
+
+// Second-n or Infinite of the first timeout, at most MaxDavLockSeconds
+func davLockSeconds(value string) int64 {
+	value = strings.TrimSpace(strings.SplitN(value, ",", 2)[0])
+	if strings.HasPrefix(value, "Second-") {
+		if n, err := strconv.ParseInt(value[len("Second-"):], 10, 64); err == nil && n > 0 && n < MaxDavLockSeconds {
+			return n
+		}
+	}
+	return MaxDavLockSeconds
+}
+
+// the lock tokens of the conditions in the If header, the not conditions and entity tags were ignored
+func davIfTokens(value string) (tokens []string) {
+	for {
+		i := strings.Index(value, "<")
+		if i < 0 {
+			return
+		}
+		j := strings.Index(value[i:], ">")
+		if j < 0 {
+			return
+		}
+		if token := value[i+1 : i+j]; strings.HasPrefix(token, "opaquelocktoken:") {
+			tokens = append(tokens, token)
+		}
+		value = value[i+j+1:]
+	}
+}
+
+func (l *davLock) covers(path string) bool {
+	return path == l.root || (l.infinite && davUnder(path, l.root))
+}
+
+func (l *davLock) timeout(now int64) string {
+	return "Second-" + strconv.FormatInt(l.expires-now, 10)
+}
+
+func (d *davLockStore) expire(now int64) {
+	for token, l := range d.locks {
+		if l.expires <= now {
+			delete(d.locks, token)
+		}
+	}
+}
+
+// the exclusive lock conflict with all the locks of the path, the shared only with the exclusive ones
+func (d *davLockStore) Lock(l *davLock, seconds int64) (err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now().Unix()
+	d.expire(now)
+	for _, v := range d.locks {
+		if !v.covers(l.root) && !(l.infinite && davUnder(v.root, l.root)) {
+			continue
+		}
+		if !l.shared || !v.shared {
+			err = ErrDavLocked
+			return
+		}
+	}
+	l.expires = now + seconds
+	d.locks[l.token] = l
+	return
+}
+
+// the lock of the token was refreshed, the path must be under its root
+func (d *davLockStore) Refresh(token string, path string, seconds int64) (l davLock, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now().Unix()
+	d.expire(now)
+	v, ok := d.locks[token]
+	if !ok || !v.covers(path) {
+		err = ErrDavLockToken
+		return
+	}
+	v.expires = now + seconds
+	l = *v
+	return
+}
+
+func (d *davLockStore) Unlock(token string, path string) (err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(time.Now().Unix())
+	v, ok := d.locks[token]
+	if !ok || !v.covers(path) {
+		err = ErrDavLockToken
+		return
+	}
+	delete(d.locks, token)
+	return
+}
+
+// the write of the path, and all the names under it for the tree, require the tokens of the covering locks, one of the shared ones was enough
+func (d *davLockStore) Confirm(path string, tree bool, tokens []string) (err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire(time.Now().Unix())
+	var shared, sharedHeld bool
+	for _, v := range d.locks {
+		if !v.covers(path) && !(tree && davUnder(v.root, path)) {
+			continue
+		}
+		held := false
+		for _, token := range tokens {
+			if token == v.token {
+				held = true
+				break
+			}
+		}
+		if !v.shared && !held {
+			err = ErrDavLocked
+			return
+		}
+		if v.shared {
+			shared = true
+			sharedHeld = sharedHeld || held
+		}
+	}
+	if shared && !sharedHeld {
+		err = ErrDavLocked
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDavIfTokens(t *testing.T) {
+	tests := []struct {
+		value  string
+		tokens []string
+	}{
+		{"", nil},
+		{"(<opaquelocktoken:vxfs-1>)", []string{"opaquelocktoken:vxfs-1"}},
+		{"<http://vxfs/dav/a.txt> (<opaquelocktoken:vxfs-1> [\"etag\"])", []string{"opaquelocktoken:vxfs-1"}},
+		{"(<opaquelocktoken:vxfs-1>) (Not <opaquelocktoken:vxfs-2>)", []string{"opaquelocktoken:vxfs-1", "opaquelocktoken:vxfs-2"}},
+		{"(<opaquelocktoken:vxfs-1", nil},
+	}
+	for _, tt := range tests {
+		if tokens := davIfTokens(tt.value); !reflect.DeepEqual(tokens, tt.tokens) {
+			t.Errorf("davIfTokens(%q) = %v, want %v", tt.value, tokens, tt.tokens)
+		}
+	}
+}
+
+func TestDavLockSeconds(t *testing.T) {
+	tests := []struct {
+		value   string
+		seconds int64
+	}{
+		{"", MaxDavLockSeconds},
+		{"Second-60", 60},
+		{"Second-60, Infinite", 60},
+		{"Infinite, Second-60", MaxDavLockSeconds},
+		{"Second-86400", MaxDavLockSeconds},
+		{"Second-0", MaxDavLockSeconds},
+		{"Second-a", MaxDavLockSeconds},
+	}
+	for _, tt := range tests {
+		if seconds := davLockSeconds(tt.value); seconds != tt.seconds {
+			t.Errorf("davLockSeconds(%q) = %d, want %d", tt.value, seconds, tt.seconds)
+		}
+	}
+}
+
+func TestDavLockStore(t *testing.T) {
+	d := newDavLockStore()
+	lock := func(token string, root string, infinite bool, shared bool) func() error {
+		return func() error {
+			return d.Lock(&davLock{token: token, root: root, infinite: infinite, shared: shared}, 60)
+		}
+	}
+	confirm := func(path string, tree bool, tokens ...string) func() error {
+		return func() error {
+			return d.Confirm(path, tree, tokens)
+		}
+	}
+	tests := []struct {
+		name string
+		do   func() error
+		err  error
+	}{
+		{"lock dir", lock("t1", "a/b", true, false), nil},
+		{"lock under", lock("t2", "a/b/c.txt", false, false), ErrDavLocked},
+		{"lock parent", lock("t2", "a", true, false), ErrDavLocked},
+		{"lock parent depth 0", lock("t2", "a", false, false), nil},
+		{"lock sibling", lock("t3", "a/bc.txt", false, false), nil},
+		{"put locked", confirm("a/b/c.txt", false), ErrDavLocked},
+		{"put with token", confirm("a/b/c.txt", false, "t1"), nil},
+		{"put sibling", confirm("a/c.txt", false), nil},
+		{"delete tree", confirm("a", true, "t2"), ErrDavLocked},
+		{"delete tree with tokens", confirm("a", true, "t1", "t2", "t3"), nil},
+		{"lock shared", lock("t4", "x.txt", false, true), nil},
+		{"lock shared again", lock("t5", "x.txt", false, true), nil},
+		{"lock exclusive of shared", lock("t6", "x.txt", false, false), ErrDavLocked},
+		{"put shared", confirm("x.txt", false), ErrDavLocked},
+		{"put one shared", confirm("x.txt", false, "t5"), nil},
+		{"refresh", func() error { _, err := d.Refresh("t1", "a/b/c.txt", 60); return err }, nil},
+		{"refresh other path", func() error { _, err := d.Refresh("t1", "a/c.txt", 60); return err }, ErrDavLockToken},
+		{"unlock other path", func() error { return d.Unlock("t1", "a") }, ErrDavLockToken},
+		{"unlock", func() error { return d.Unlock("t1", "a/b") }, nil},
+		{"put unlocked", confirm("a/b/c.txt", false), nil},
+		{"unlock again", func() error { return d.Unlock("t1", "a/b") }, ErrDavLockToken},
+		{"lock root", lock("t7", "", true, false), ErrDavLocked},
+	}
+	for _, tt := range tests {
+		if err := tt.do(); err != tt.err {
+			t.Errorf("%s: error(%v), want (%v)", tt.name, err, tt.err)
+		}
+	}
+
+	d.locks["t2"].expires = 0
+	if err := d.Confirm("a", false, nil); err != nil {
+		t.Errorf("expired: error(%v), want (<nil>)", err)
+	}
+}
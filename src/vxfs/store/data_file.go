@@ -194,6 +194,59 @@ func (d *DataFile) Read(offset int64, size int32) (key int64, flag byte, meta []
 	return
 }
 
+// the meta and the data in the range, read the block head first
+func (d *DataFile) ReadRange(offset int64, size int32, from int64, length int64) (key int64, flag byte, meta []byte, data []byte, dataSize int32, err error) {
+	var (
+		cursor      = 0
+		metaSize    int32
+		paddingSize int32
+		headBuffer  = make([]byte, dataBlockHeadSize)
+	)
+
+	if _, err = d.r.ReadAt(headBuffer, offset); err != nil {
+		return
+	}
+	if !bytes.Equal(headBuffer[cursor:cursor+dataBlockHeadMagicSize], dataBlockHeadMagic) {
+		err = ErrDataBlockMagic
+		return
+	}
+	cursor += dataBlockHeadMagicSize
+	key = int64(binary.BigEndian.Uint64(headBuffer[cursor:]))
+	cursor += 8
+	flag = headBuffer[cursor]
+	cursor += 1
+	paddingSize = int32(headBuffer[cursor])
+	cursor += 1
+	metaSize = int32(binary.BigEndian.Uint16(headBuffer[cursor:]))
+	cursor += 2
+	dataSize = int32(binary.BigEndian.Uint32(headBuffer[cursor:]))
+	if dataBlockHeadSize+metaSize+dataSize+paddingSize != size {
+		err = ErrDataBlockSizes
+		return
+	}
+	if from > int64(dataSize) {
+		from = int64(dataSize)
+	}
+	if length > int64(dataSize)-from {
+		length = int64(dataSize) - from
+	}
+
+	offset += dataBlockHeadSize
+	if metaSize > 0 {
+		meta = make([]byte, metaSize)
+		if _, err = d.r.ReadAt(meta, offset); err != nil {
+			return
+		}
+	}
+	data = make([]byte, length)
+	if length > 0 {
+		if _, err = d.r.ReadAt(data, offset+int64(metaSize)+from); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (d *DataFile) Write(key int64, meta []byte, data []byte) (offset int64, size int32, err error) {
 	var (
 		cursor                 = 0
@@ -204,6 +257,10 @@ func (d *DataFile) Write(key int64, meta []byte, data []byte) (offset int64, siz
 	)
 	defer libs.FreeBuffer(blockBuffer)
 
+	if metaSize > MaxMetaSize {
+		err = ErrDataMetaSize
+		return
+	}
 	copy(blockBuffer[cursor:], dataBlockHeadMagic)
 	cursor += dataBlockHeadMagicSize
 	binary.BigEndian.PutUint64(blockBuffer[cursor:], uint64(key))
@@ -243,6 +300,11 @@ func (d *DataFile) Delete(offset int64) (err error) {
 	return
 }
 
+func (d *DataFile) Undelete(offset int64) (err error) {
+	_, err = d.w.WriteAt([]byte{FlagOk}, offset+int64(dataBlockFlagOffset))
+	return
+}
+
 func (d *DataFile) Recovery(offset int64, fn func(int64, byte, int64, int32) error) (err error) {
 	var (
 		key         int64
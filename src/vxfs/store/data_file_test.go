@@ -0,0 +1,65 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+import . "vxfs/dao/store"
+
+func TestDataFileReadRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vxfs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDataFile(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var (
+		meta = []byte(`{"mime":"text/plain"}`)
+		data = []byte("0123456789abcdef")
+	)
+	offset, size, err := d.Write(7, meta, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from   int64
+		length int64
+		data   string
+	}{
+		{0, 16, "0123456789abcdef"},
+		{0, 4, "0123"},
+		{10, 6, "abcdef"},
+		{10, 100, "abcdef"},
+		{15, 1, "f"},
+		{16, 1, ""},
+		{100, 1, ""},
+		{4, 0, ""},
+	}
+	for _, tt := range tests {
+		key, flag, m, b, dataSize, err := d.ReadRange(offset, size, tt.from, tt.length)
+		if err != nil {
+			t.Errorf("ReadRange(%d, %d) error(%v)", tt.from, tt.length, err)
+			continue
+		}
+		if key != 7 || flag != FlagOk || !bytes.Equal(m, meta) || dataSize != int32(len(data)) {
+			t.Errorf("ReadRange(%d, %d) = %d, %d, %q, size %d", tt.from, tt.length, key, flag, m, dataSize)
+		}
+		if string(b) != tt.data {
+			t.Errorf("ReadRange(%d, %d) data %q, want %q", tt.from, tt.length, b, tt.data)
+		}
+	}
+	if _, _, _, _, _, err = d.ReadRange(offset, size+8, 0, 1); err != ErrDataBlockSizes {
+		t.Errorf("ReadRange() bad size error(%v), want (%v)", err, ErrDataBlockSizes)
+	}
+	if _, _, _, _, _, err = d.ReadRange(offset+8, size, 0, 1); err != ErrDataBlockMagic {
+		t.Errorf("ReadRange() bad offset error(%v), want (%v)", err, ErrDataBlockMagic)
+	}
+}
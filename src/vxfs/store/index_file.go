@@ -206,6 +206,36 @@ func (i *IndexFile) Recovery(fn func(int64, int64, int32) error) (err error) {
 	return
 }
 
+// the last block of the key before the limit, read without moving the write offset
+func (i *IndexFile) Lookup(key int64, limit int64) (offset int64, size int32, found bool, err error) {
+	var (
+		n           int
+		blockBuffer = make([]byte, indexBlockSize*1024)
+	)
+	for cursor := int64(indexHeadSize); cursor < limit; cursor += int64(n) {
+		if n, err = i.f.ReadAt(blockBuffer, cursor); err != nil && err != io.EOF {
+			glog.Errorf("IndexFile: \"%s\" ReadAt (%d) error(%v)", i.File, cursor, err)
+			return
+		}
+		err = nil
+		if int64(n) > limit-cursor {
+			n = int(limit - cursor)
+		}
+		if n -= n % indexBlockSize; n < 1 {
+			return
+		}
+		for j := 0; j < n; j += indexBlockSize {
+			if int64(binary.BigEndian.Uint64(blockBuffer[j:])) != key {
+				continue
+			}
+			offset = int64(binary.BigEndian.Uint64(blockBuffer[j+8:]))
+			size = int32(binary.BigEndian.Uint32(blockBuffer[j+16:]))
+			found = true
+		}
+	}
+	return
+}
+
 func (i *IndexFile) Close() {
 	var err error
 	if i.f != nil {
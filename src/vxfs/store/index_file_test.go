@@ -0,0 +1,56 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexFileLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vxfs-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	i, err := NewIndexFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+
+	// the blocks span more than one read buffer
+	for key := int64(1); key <= 2500; key++ {
+		if err = i.Write(key, key*100, int32(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limit := i.Offset
+	if err = i.Write(9999, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    int64
+		limit  int64
+		offset int64
+		size   int32
+		found  bool
+	}{
+		{1, limit, 100, 1, true},
+		{1024, limit, 102400, 1024, true},
+		{1025, limit, 102500, 1025, true},
+		{2500, limit, 250000, 2500, true},
+		{2501, limit, 0, 0, false},
+		{9999, limit, 0, 0, false},
+		{9999, i.Offset, 1, 1, true},
+		{2500, limit - indexBlockSize, 0, 0, false},
+		{1, indexHeadSize, 0, 0, false},
+	}
+	for _, tt := range tests {
+		offset, size, found, err := i.Lookup(tt.key, tt.limit)
+		if err != nil || offset != tt.offset || size != tt.size || found != tt.found {
+			t.Errorf("Lookup(%d, %d) = %d, %d, %v, %v, want %d, %d, %v", tt.key, tt.limit, offset, size, found, err, tt.offset, tt.size, tt.found)
+		}
+	}
+}
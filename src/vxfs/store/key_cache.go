@@ -40,6 +40,7 @@ func (c *KeyCache) Set(key int64, vid int32, offset int64, size int32) (k *KeyBl
 	return
 }
 
+// the deleted blocks keep on disk, the undelete look them up from the indexes
 func (c *KeyCache) Del(key int64) {
 	c.rwlock.Lock()
 	defer c.rwlock.Unlock()
@@ -23,6 +23,10 @@ func (s *StoreService) Delete(req *DeleteRequest, res *DeleteResponse) (err erro
 	return s.g.Delete(req, res)
 }
 
+func (s *StoreService) Undelete(req *UndeleteRequest, res *UndeleteResponse) (err error) {
+	return s.g.Undelete(req, res)
+}
+
 func (s *StoreService) Stats(req *StatsRequest, res *StatsResponse) (err error) {
 	return s.g.Stats(req, res)
 }
@@ -72,7 +72,7 @@ func (v *VolumeFile) init() (err error) {
 	return
 }
 
-func (v *VolumeFile) Read(k *KeyBlock, res *ReadResponse) (err error) {
+func (v *VolumeFile) Read(k *KeyBlock, req *ReadRequest, res *ReadResponse) (err error) {
 	if v.closed {
 		err = ErrVolumeClosed
 		return
@@ -83,8 +83,15 @@ func (v *VolumeFile) Read(k *KeyBlock, res *ReadResponse) (err error) {
 		flag byte
 		meta []byte
 		data []byte
+		size int32
 	)
-	if key, flag, meta, data, err = v.Data.Read(k.Offset, k.Size); err != nil {
+	if req.Length > 0 {
+		key, flag, meta, data, size, err = v.Data.ReadRange(k.Offset, k.Size, req.Offset, req.Length)
+	} else {
+		key, flag, meta, data, err = v.Data.Read(k.Offset, k.Size)
+		size = int32(len(data))
+	}
+	if err != nil {
 		return
 	}
 	if flag != FlagOk {
@@ -94,6 +101,7 @@ func (v *VolumeFile) Read(k *KeyBlock, res *ReadResponse) (err error) {
 	}
 	res.Meta = meta
 	res.Data = data
+	res.Size = size
 	return
 }
 
@@ -135,6 +143,58 @@ func (v *VolumeFile) Delete(k *KeyBlock) (err error) {
 	return
 }
 
+// the deleted block of the key in the index, nil if not found
+func (v *VolumeFile) Lookup(key int64) (k *KeyBlock, err error) {
+	if v.closed {
+		err = ErrVolumeClosed
+		return
+	}
+
+	var (
+		offset int64
+		size   int32
+		found  bool
+	)
+	v.wlock.Lock()
+	limit := v.Index.Offset
+	v.wlock.Unlock()
+	if offset, size, found, err = v.Index.Lookup(key, limit); err != nil || !found {
+		return
+	}
+	k = &KeyBlock{Vid: v.Vid, Offset: offset, Size: size}
+	return
+}
+
+func (v *VolumeFile) Undelete(key int64, k *KeyBlock) (err error) {
+	if v.closed {
+		return ErrVolumeClosed
+	}
+
+	var (
+		bkey int64
+		flag byte
+	)
+	v.wlock.Lock()
+	if bkey, flag, _, _, err = v.Data.Read(k.Offset, k.Size); err != nil {
+		v.wlock.Unlock()
+		return
+	}
+	if bkey != key {
+		v.wlock.Unlock()
+		err = ErrStoreNotExists
+		return
+	}
+	if flag != FlagOk {
+		if err = v.Data.Undelete(k.Offset); err != nil {
+			v.wlock.Unlock()
+			return
+		}
+	}
+	v.wlock.Unlock()
+	v.keyCache.Set(key, v.Vid, k.Offset, k.Size)
+	return
+}
+
 func (v *VolumeFile) Close() {
 	v.wlock.Lock()
 	defer v.wlock.Unlock()
@@ -151,11 +151,15 @@ func (g *VolumeGroup) Read(req *ReadRequest, res *ReadResponse) (err error) {
 	g.rwlock.RLock()
 	v = g.volumes[k.Vid]
 	g.rwlock.RUnlock()
-	if err = v.Read(k, res); err != nil {
+	if err = v.Read(k, req, res); err != nil {
 		return
 	}
 	atomic.AddUint64(&g.counters.ReadCount, uint64(1))
-	atomic.AddUint64(&g.counters.ReadBytes, uint64(k.Size))
+	if req.Length > 0 {
+		atomic.AddUint64(&g.counters.ReadBytes, uint64(len(res.Data)))
+	} else {
+		atomic.AddUint64(&g.counters.ReadBytes, uint64(k.Size))
+	}
 	return
 }
 
@@ -209,6 +213,42 @@ func (g *VolumeGroup) Delete(req *DeleteRequest, res *DeleteResponse) (err error
 	return
 }
 
+func (g *VolumeGroup) Undelete(req *UndeleteRequest, res *UndeleteResponse) (err error) {
+	var (
+		k *KeyBlock
+		v *VolumeFile
+	)
+	if k = g.keyCache.Get(req.Key); k == nil {
+		if k, err = g.lookup(req.Key); err != nil {
+			return
+		}
+		if k == nil {
+			err = ErrStoreNotExists
+			return
+		}
+	}
+
+	g.rwlock.RLock()
+	v = g.volumes[k.Vid]
+	g.rwlock.RUnlock()
+
+	return v.Undelete(req.Key, k)
+}
+
+// the deleted blocks were not cached, look them up from the indexes of the newer volumes first
+func (g *VolumeGroup) lookup(key int64) (k *KeyBlock, err error) {
+	g.rwlock.RLock()
+	volumes := append([]*VolumeFile{}, g.volumes...)
+	g.rwlock.RUnlock()
+
+	for i := len(volumes) - 1; i >= 0; i-- {
+		if k, err = volumes[i].Lookup(key); err != nil || k != nil {
+			return
+		}
+	}
+	return
+}
+
 func (g *VolumeGroup) refreshStats() {
 	g.stats.DataFreeMB, _ = libs.GetDiskFreeSpace(g.DataDir, 2)
 	g.stats.IndexFreeMB, _ = libs.GetDiskFreeSpace(g.IndexDir, 2)